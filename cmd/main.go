@@ -10,6 +10,15 @@ import (
 	"k8s.io/component-base/cli"
 
 	"github.com/stlaz/psachecker/pkg/clusterinspect"
+	"github.com/stlaz/psachecker/pkg/collect"
+	"github.com/stlaz/psachecker/pkg/controller"
+	"github.com/stlaz/psachecker/pkg/defaultconfig"
+	"github.com/stlaz/psachecker/pkg/diff"
+	"github.com/stlaz/psachecker/pkg/exportpolicies"
+	"github.com/stlaz/psachecker/pkg/history"
+	"github.com/stlaz/psachecker/pkg/metricsexporter"
+	"github.com/stlaz/psachecker/pkg/migratepsp"
+	"github.com/stlaz/psachecker/pkg/simulate"
 	"github.com/stlaz/psachecker/pkg/workloadinspect"
 )
 
@@ -39,6 +48,18 @@ func newCmd() *cobra.Command {
 
 	cmd.AddCommand(workloadinspect.NewWorkloadInspectCommand(o.ClientConfigOptions))
 	cmd.AddCommand(clusterinspect.NewClusterInspectCommand(o.ClientConfigOptions))
+	cmd.AddCommand(collect.NewCollectCommand(o.ClientConfigOptions))
+	cmd.AddCommand(clusterinspect.NewAnalyzeCommand(o.ClientConfigOptions))
+	cmd.AddCommand(controller.NewControllerCommand(o.ClientConfigOptions))
+	cmd.AddCommand(metricsexporter.NewMetricsExporterCommand(o.ClientConfigOptions))
+	cmd.AddCommand(history.NewHistoryCommand(o.ClientConfigOptions))
+	cmd.AddCommand(diff.NewDiffCommand())
+	cmd.AddCommand(migratepsp.NewMigratePSPCommand(o.ClientConfigOptions))
+	cmd.AddCommand(exportpolicies.NewExportPoliciesCommand(o.ClientConfigOptions))
+	cmd.AddCommand(defaultconfig.NewRecommendDefaultsCommand(o.ClientConfigOptions))
+	cmd.AddCommand(defaultconfig.NewPlanExemptionsCommand(o.ClientConfigOptions))
+	cmd.AddCommand(simulate.NewSimulateCommand(o.ClientConfigOptions))
+	cmd.AddCommand(simulate.NewUpgradeImpactCommand(o.ClientConfigOptions))
 	return cmd
 }
 
@@ -3,10 +3,10 @@ package admission
 import (
 	"context"
 	"fmt"
-	"sync"
 
 	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -16,19 +16,32 @@ import (
 	psadmission "k8s.io/pod-security-admission/admission"
 	psadmissionapi "k8s.io/pod-security-admission/admission/api"
 	psapi "k8s.io/pod-security-admission/api"
+	psmetrics "k8s.io/pod-security-admission/metrics"
 	"k8s.io/pod-security-admission/policy"
+
+	"github.com/stlaz/psachecker/pkg/openshift"
 )
 
 type ParallelAdmission struct {
 	podSpecExtractor psadmission.PodSpecExtractor
+	checks           *checkEvaluator
+
+	metrics *EvaluationCounter
+	// specCache is optional, it allows skipping the evaluation of already seen pod specs
+	specCache *SpecCache
 
-	privileged *psadmission.Admission
-	baseline   *psadmission.Admission
-	restricted *psadmission.Admission
+	// podLister retrieves the pods of the evaluated namespaces
+	podLister psadmission.PodLister
+	// namespaceAdmission is a privileged admission used to evaluate the pods
+	// of a namespace when its PodSecurity labels are being updated
+	namespaceAdmission *psadmission.Admission
 }
 
 type ParallelAdmissionResult struct {
 	Privileged, Baseline, Restricted *admissionv1.AdmissionResponse
+
+	// FailedChecks are all the latest-version checks the evaluated pod did not pass
+	FailedChecks []FailedCheck
 }
 
 type AdmissionResultsKey struct {
@@ -73,60 +86,151 @@ func (r *ParallelAdmissionResult) MostRestrictivePolicy() psapi.Level {
 }
 
 func NewParallelAdmission(kubeClient kubernetes.Interface) (*ParallelAdmission, error) {
-	evaluator, err := policy.NewEvaluator(policy.DefaultChecks()) // TODO: allow experimental checks by a flag
+	return NewParallelAdmissionWithMetrics(kubeClient, NewEvaluationCounter())
+}
+
+// NewParallelAdmissionWithMetrics creates a ParallelAdmission that records its evaluations
+// to the given counter so that the counts can be accumulated across several admissions.
+func NewParallelAdmissionWithMetrics(kubeClient kubernetes.Interface, metrics *EvaluationCounter) (*ParallelAdmission, error) {
+	return NewParallelAdmissionWithPodLister(psadmission.PodListerFromClient(kubeClient), metrics)
+}
+
+// NewParallelAdmissionWithPodLister creates a ParallelAdmission that retrieves the pods of
+// the evaluated namespaces from the given lister, e.g. one backed by a shared informer.
+func NewParallelAdmissionWithPodLister(podLister psadmission.PodLister, metrics *EvaluationCounter) (*ParallelAdmission, error) {
+	checks := policy.DefaultChecks() // TODO: allow experimental checks by a flag
+	evaluator, err := policy.NewEvaluator(checks)
 	if err != nil {
 		return nil, err
 	}
 
-	podLister := psadmission.PodListerFromClient(kubeClient) // only used while validating pods in an NS
-
 	podSpecExtractor := psadmission.DefaultPodSpecExtractor{}
 
 	// TODO: NamespaceGetter is currently only used to get the policies of the NS
 	//       during a given Pod/pod controller evaluation. We do not want the NS
 	//       policies to interfere with the admission that we are going to be testing
 	//       so we mock NS retrieval all the time w/ empty PSa labels.
-	// IMPORTANT: make sure to unit-test that Namespace-object admission validation
-	//            is not influenced by nsGetter
+	//       TestValidateNamespacesIgnoresNamespaceGetter makes sure the namespace
+	//       evaluations are not influenced by nsGetter.
 	nsGetter := KnowAllNamespaceGetter
-	privilegedAdm, err := setupAdmission(nsGetter, podLister, evaluator, podSpecExtractor, psapi.LevelPrivileged)
-	if err != nil {
-		return nil, err
-	}
-	baselineAdm, err := setupAdmission(nsGetter, podLister, evaluator, podSpecExtractor, psapi.LevelBaseline)
-	if err != nil {
-		return nil, err
-	}
-	restrictedAdm, err := setupAdmission(nsGetter, podLister, evaluator, podSpecExtractor, psapi.LevelRestricted)
+	namespaceAdm, err := setupAdmission(nsGetter, &prefetchedPodLister{delegate: podLister}, evaluator, podSpecExtractor, metrics, psapi.LevelPrivileged)
 	if err != nil {
 		return nil, err
 	}
 
 	return &ParallelAdmission{
 		podSpecExtractor: podSpecExtractor,
+		checks:           newCheckEvaluator(checks),
+		metrics:          metrics,
 
-		privileged: privilegedAdm,
-		baseline:   baselineAdm,
-		restricted: restrictedAdm,
+		podLister:          podLister,
+		namespaceAdmission: namespaceAdm,
 	}, nil
 }
 
+// WithSpecCache makes the admission remember the results of the evaluated pod specs
+// in the cache. The cache may be shared by several admissions.
+func (a *ParallelAdmission) WithSpecCache(cache *SpecCache) *ParallelAdmission {
+	a.specCache = cache
+	return a
+}
+
+// Metrics returns the counter of the evaluations performed by the admission.
+func (a *ParallelAdmission) Metrics() *EvaluationCounter {
+	return a.metrics
+}
+
+// Validate evaluates the pod or the pod template of the object in the attributes against
+// all the PodSecurity levels at once. The restricted level is a superset of the baseline level
+// so each of the checks only runs a single time and the verdicts for the individual levels are
+// derived from the results of the checks.
 func (a *ParallelAdmission) Validate(ctx context.Context, attrs psapi.Attributes) *ParallelAdmissionResult {
-	result := &ParallelAdmissionResult{}
-	resultsWG := &sync.WaitGroup{}
-	waitForAdmission := func(wg *sync.WaitGroup, admission *psadmission.Admission, result **admissionv1.AdmissionResponse) {
-		defer wg.Done()
-		*result = admission.Validate(ctx, attrs)
+	podMeta, podSpec, err := a.extractPodSpec(attrs)
+	if err != nil {
+		a.metrics.RecordError(true, attrs)
+		errResponse := &admissionv1.AdmissionResponse{
+			Allowed: false,
+			Result:  &apierrors.NewBadRequest(fmt.Sprintf("failed to extract pod spec: %v", err)).ErrStatus,
+		}
+		return &ParallelAdmissionResult{
+			Privileged: errResponse,
+			Baseline:   errResponse,
+			Restricted: errResponse,
+		}
 	}
 
-	resultsWG.Add(3)
-	go waitForAdmission(resultsWG, a.privileged, &result.Privileged)
-	go waitForAdmission(resultsWG, a.baseline, &result.Baseline)
-	go waitForAdmission(resultsWG, a.restricted, &result.Restricted)
+	// objects without a pod spec are not subject to PodSecurity
+	if podSpec == nil {
+		return &ParallelAdmissionResult{
+			Privileged: &admissionv1.AdmissionResponse{Allowed: true},
+			Baseline:   &admissionv1.AdmissionResponse{Allowed: true},
+			Restricted: &admissionv1.AdmissionResponse{Allowed: true},
+		}
+	}
 
-	resultsWG.Wait()
+	failedChecks := a.FailedChecks(podMeta, podSpec)
+	return &ParallelAdmissionResult{
+		Privileged:   a.levelResponse(attrs, psapi.LevelPrivileged, failedChecks),
+		Baseline:     a.levelResponse(attrs, psapi.LevelBaseline, failedChecks),
+		Restricted:   a.levelResponse(attrs, psapi.LevelRestricted, failedChecks),
+		FailedChecks: failedChecks,
+	}
+}
 
-	return result
+// extractPodSpec returns the pod spec of the object in the attributes or nil if the
+// object does not carry one
+func (a *ParallelAdmission) extractPodSpec(attrs psapi.Attributes) (*metav1.ObjectMeta, *corev1.PodSpec, error) {
+	obj, err := attrs.GetObject()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resource := attrs.GetResource().GroupResource()
+	switch {
+	case resource == corev1.Resource("pods"):
+		pod, ok := obj.(*corev1.Pod)
+		if !ok {
+			return nil, nil, fmt.Errorf("expected a pod, got %T", obj)
+		}
+		return &pod.ObjectMeta, &pod.Spec, nil
+	case a.podSpecExtractor.HasPodSpec(resource):
+		return a.podSpecExtractor.ExtractPodSpec(obj)
+	default:
+		return nil, nil, nil
+	}
+}
+
+// levelResponse creates the response that the admission enforcing the latest version of the
+// given level would return for a pod that failed the checks.
+func (a *ParallelAdmission) levelResponse(attrs psapi.Attributes, level psapi.Level, failedChecks []FailedCheck) *admissionv1.AdmissionResponse {
+	lv := psapi.LevelVersion{Level: level, Version: psapi.LatestVersion()}
+
+	var levelResults []policy.CheckResult
+	for _, c := range failedChecks {
+		if a.checks.appliesToLevel(c, lv) {
+			levelResults = append(levelResults, policy.CheckResult{
+				Allowed:         false,
+				ForbiddenReason: c.Reason,
+				ForbiddenDetail: c.Detail,
+			})
+		}
+	}
+
+	result := policy.AggregateCheckResults(levelResults)
+	if result.Allowed {
+		a.metrics.RecordEvaluation(psmetrics.DecisionAllow, lv, psmetrics.ModeEnforce, attrs)
+		return &admissionv1.AdmissionResponse{Allowed: true}
+	}
+
+	a.metrics.RecordEvaluation(psmetrics.DecisionDeny, lv, psmetrics.ModeEnforce, attrs)
+	return &admissionv1.AdmissionResponse{
+		Allowed: false,
+		Result: &apierrors.NewForbidden(attrs.GetResource().GroupResource(), attrs.GetName(), fmt.Errorf(
+			"violates PodSecurity %q: %s",
+			lv.String(),
+			result.ForbiddenDetail(),
+		)).ErrStatus,
+	}
 }
 
 func (a *ParallelAdmission) ValidateResources(ctx context.Context, localResources bool, defaultNamespace *string, resources ...*resource.Info) (AdmissionResultsMap, error) {
@@ -190,13 +294,40 @@ func (a *ParallelAdmission) ValidateResources(ctx context.Context, localResource
 	return results, nil
 }
 
-func (a *ParallelAdmission) ValidateNamespaces(ctx context.Context, namespaces ...corev1.Namespace) (map[string]psapi.Level, error) {
-	results := make(map[string]psapi.Level)
+// ValidateNamespaces evaluates the namespaces the same way the PodSecurity admission does
+// when their enforce label gets updated and returns the most restrictive level for each
+// of them along with the parsed warnings of the levels they did not pass. Unlike in the
+// admission, the pods that terminated are left out as in the other evaluations.
+func (a *ParallelAdmission) ValidateNamespaces(ctx context.Context, namespaces ...corev1.Namespace) (NamespaceEvaluations, error) {
+	results := make(NamespaceEvaluations)
 	for _, ns := range namespaces {
-		results[ns.Name] = psapi.LevelPrivileged
+		// list the pods only once and let all the level evaluations below use them
+		pods, err := a.podLister.ListPods(ctx, ns.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pods in namespace %q: %w", ns.Name, err)
+		}
+		pods = dedupePods(activePods(pods))
+		nsCtx := withNamespacePods(ctx, ns.Name, pods)
+
+		// the admission skips evaluating the pods if the enforce level does not get more
+		// restrictive, drop the current labels so that every level gets evaluated
+		oldNS := ns.DeepCopy()
+		delete(oldNS.Labels, psapi.EnforceLevelLabel)
+		delete(oldNS.Labels, psapi.EnforceVersionLabel)
+
+		result := &NamespaceEvaluation{
+			Name:                 ns.Name,
+			Level:                psapi.LevelPrivileged,
+			TotalPods:            len(pods),
+			AdmissionCheckedPods: len(pods),
+			LabelsSynced:         openshift.LabelsSynced(&ns),
+		}
 		// loop through available levels in order of restrictivness so that more restrictive levels override previous result if they are allowed
 		for _, privilegeLevel := range []psapi.Level{psapi.LevelBaseline, psapi.LevelRestricted} {
 			newNS := ns.DeepCopy()
+			if newNS.Labels == nil {
+				newNS.Labels = map[string]string{}
+			}
 			newNS.Labels[psapi.EnforceLevelLabel] = string(privilegeLevel)
 			newNS.Labels[psapi.EnforceVersionLabel] = string(psapi.VersionLatest) // FIXME: should this be the earliest version or the current one? Which version should the admission config use?
 
@@ -206,11 +337,11 @@ func (a *ParallelAdmission) ValidateNamespaces(ctx context.Context, namespaces .
 			//   may be stuck
 			// - a flag should be added to decide which admission level should run these
 			//   validation tests (based on cluster config)
-			admissionResult := a.privileged.Validate(ctx, &psapi.AttributesRecord{
+			admissionResult := a.namespaceAdmission.Validate(nsCtx, &psapi.AttributesRecord{
 				Name:      ns.Name,
 				Resource:  ns.GroupVersionKind().GroupVersion().WithResource("namespaces"),
 				Operation: admissionv1.Update,
-				OldObject: &ns,
+				OldObject: oldNS,
 				Object:    newNS,
 				Username:  "", // TODO: do we need this? What's it for anyway?
 			})
@@ -220,11 +351,28 @@ func (a *ParallelAdmission) ValidateNamespaces(ctx context.Context, namespaces .
 			// to be valid if they are specified
 			// If there are issues with PSa enforcement, these are passed in the
 			// results's `Warnings` attribute`
-			if len(admissionResult.Warnings) == 0 {
-				results[ns.Name] = privilegeLevel
+			levelVersion := psapi.LevelVersion{Level: privilegeLevel, Version: psapi.LatestVersion()}
+			violations, checkedPods := a.checks.parseNamespaceWarnings(levelVersion, admissionResult.Warnings)
+			if checkedPods >= 0 && checkedPods < result.AdmissionCheckedPods {
+				result.AdmissionCheckedPods = checkedPods
+			}
+			// a warning about the admission not checking all the pods is not a violation
+			if len(violations) == 0 {
+				result.Level = privilegeLevel
+				continue
 			}
+			result.addViolations(privilegeLevel, violations)
+		}
 
+		// the admission does not report the levels of the individual pods, evaluate the pods
+		// admitted by the OpenShift SCCs separately for the SCC report
+		for _, pod := range pods {
+			if scc := openshift.PodSCC(pod); len(scc) > 0 {
+				result.addSCCLevel(scc, a.podLevel(pod))
+			}
 		}
+
+		results[ns.Name] = result
 	}
 
 	return results, nil
@@ -235,6 +383,7 @@ func setupAdmission(
 	podLister psadmission.PodLister,
 	evaluator policy.Evaluator,
 	podSpecExtractor psadmission.PodSpecExtractor,
+	metrics psmetrics.Recorder,
 	admissionLevel psapi.Level,
 ) (*psadmission.Admission, error) {
 
@@ -253,7 +402,7 @@ func setupAdmission(
 		},
 		NamespaceGetter: nsGetter,
 		PodLister:       podLister,
-		Metrics:         &NoopMetricsRecorder{},
+		Metrics:         metrics,
 	}
 	if err := adm.CompleteConfiguration(); err != nil {
 		return nil, err
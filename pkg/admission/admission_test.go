@@ -0,0 +1,362 @@
+package admission
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	psadmission "k8s.io/pod-security-admission/admission"
+	psapi "k8s.io/pod-security-admission/api"
+	"k8s.io/pod-security-admission/policy"
+)
+
+// staticPodLister returns the same pods for any namespace
+type staticPodLister []*corev1.Pod
+
+func (l staticPodLister) ListPods(_ context.Context, namespace string) ([]*corev1.Pod, error) {
+	var pods []*corev1.Pod
+	for _, pod := range l {
+		if pod.Namespace == namespace {
+			pods = append(pods, pod)
+		}
+	}
+	return pods, nil
+}
+
+func boolPtr(b bool) *bool    { return &b }
+func int64Ptr(i int64) *int64 { return &i }
+
+// restrictedContainer is a container that passes all the checks of the restricted level
+func restrictedContainer(name string) corev1.Container {
+	return corev1.Container{
+		Name:  name,
+		Image: "registry.example.com/" + name,
+		SecurityContext: &corev1.SecurityContext{
+			AllowPrivilegeEscalation: boolPtr(false),
+			RunAsNonRoot:             boolPtr(true),
+			Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+			SeccompProfile:           &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+		},
+	}
+}
+
+func testPod(name string, mutate func(pod *corev1.Pod)) *corev1.Pod {
+	pod := &corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "test", Name: name},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{restrictedContainer("app")},
+		},
+	}
+	if mutate != nil {
+		mutate(pod)
+	}
+	return pod
+}
+
+// testPods covers each of the checks along with the pods that only pass some of the levels
+func testPods() []*corev1.Pod {
+	return []*corev1.Pod{
+		testPod("restricted", nil),
+		testPod("empty-security-context", func(pod *corev1.Pod) {
+			pod.Spec.Containers[0].SecurityContext = nil
+		}),
+		testPod("pod-level-restricted", func(pod *corev1.Pod) {
+			pod.Spec.Containers[0].SecurityContext.RunAsNonRoot = nil
+			pod.Spec.Containers[0].SecurityContext.SeccompProfile = nil
+			pod.Spec.SecurityContext = &corev1.PodSecurityContext{
+				RunAsNonRoot:   boolPtr(true),
+				SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeLocalhost, LocalhostProfile: new(string)},
+			}
+		}),
+		testPod("privileged", func(pod *corev1.Pod) {
+			pod.Spec.Containers[0].SecurityContext.Privileged = boolPtr(true)
+		}),
+		testPod("host-network", func(pod *corev1.Pod) {
+			pod.Spec.HostNetwork = true
+		}),
+		testPod("host-path", func(pod *corev1.Pod) {
+			pod.Spec.Volumes = []corev1.Volume{{Name: "host", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/"}}}}
+		}),
+		testPod("host-port", func(pod *corev1.Pod) {
+			pod.Spec.Containers[0].Ports = []corev1.ContainerPort{{ContainerPort: 80, HostPort: 80}}
+		}),
+		testPod("net-admin", func(pod *corev1.Pod) {
+			pod.Spec.Containers[0].SecurityContext.Capabilities.Add = []corev1.Capability{"NET_ADMIN"}
+		}),
+		testPod("net-raw", func(pod *corev1.Pod) {
+			// allowed by baseline but not by restricted
+			pod.Spec.Containers[0].SecurityContext.Capabilities.Add = []corev1.Capability{"NET_RAW"}
+		}),
+		testPod("seccomp-unconfined", func(pod *corev1.Pod) {
+			pod.Spec.Containers[0].SecurityContext.SeccompProfile.Type = corev1.SeccompProfileTypeUnconfined
+		}),
+		testPod("root", func(pod *corev1.Pod) {
+			pod.Spec.Containers[0].SecurityContext.RunAsUser = int64Ptr(0)
+		}),
+		testPod("privilege-escalation", func(pod *corev1.Pod) {
+			pod.Spec.Containers[0].SecurityContext.AllowPrivilegeEscalation = nil
+		}),
+		testPod("unsafe-sysctl", func(pod *corev1.Pod) {
+			pod.Spec.SecurityContext = &corev1.PodSecurityContext{Sysctls: []corev1.Sysctl{{Name: "kernel.msgmax", Value: "1"}}}
+		}),
+		testPod("safe-sysctl", func(pod *corev1.Pod) {
+			pod.Spec.SecurityContext = &corev1.PodSecurityContext{Sysctls: []corev1.Sysctl{{Name: "net.ipv4.ip_local_port_range", Value: "1024 65535"}}}
+		}),
+		testPod("apparmor-unconfined", func(pod *corev1.Pod) {
+			pod.Annotations = map[string]string{corev1.AppArmorBetaContainerAnnotationKeyPrefix + "app": corev1.AppArmorBetaProfileNameUnconfined}
+		}),
+		testPod("selinux-type", func(pod *corev1.Pod) {
+			pod.Spec.Containers[0].SecurityContext.SELinuxOptions = &corev1.SELinuxOptions{Type: "spc_t"}
+		}),
+		testPod("empty-dir", func(pod *corev1.Pod) {
+			pod.Spec.Volumes = []corev1.Volume{{Name: "tmp", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}}
+		}),
+		testPod("nfs", func(pod *corev1.Pod) {
+			// allowed by baseline but not by restricted
+			pod.Spec.Volumes = []corev1.Volume{{Name: "nfs", VolumeSource: corev1.VolumeSource{NFS: &corev1.NFSVolumeSource{Server: "nfs", Path: "/"}}}}
+		}),
+		testPod("windows", func(pod *corev1.Pod) {
+			pod.Spec.OS = &corev1.PodOS{Name: corev1.Windows}
+			pod.Spec.Containers[0].SecurityContext = &corev1.SecurityContext{RunAsNonRoot: boolPtr(true)}
+		}),
+		testPod("windows-host-process", func(pod *corev1.Pod) {
+			pod.Spec.OS = &corev1.PodOS{Name: corev1.Windows}
+			pod.Spec.SecurityContext = &corev1.PodSecurityContext{WindowsOptions: &corev1.WindowsSecurityContextOptions{HostProcess: boolPtr(true)}}
+		}),
+		testPod("init-container", func(pod *corev1.Pod) {
+			pod.Spec.InitContainers = []corev1.Container{{Name: "init", Image: "registry.example.com/init"}}
+		}),
+	}
+}
+
+// newPerLevelAdmissions sets up the upstream admission enforcing each of the levels
+func newPerLevelAdmissions(t testing.TB, podLister psadmission.PodLister) map[psapi.Level]*psadmission.Admission {
+	evaluator, err := policy.NewEvaluator(policy.DefaultChecks())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	admissions := map[psapi.Level]*psadmission.Admission{}
+	for _, level := range []psapi.Level{psapi.LevelPrivileged, psapi.LevelBaseline, psapi.LevelRestricted} {
+		admissions[level], err = setupAdmission(KnowAllNamespaceGetter, podLister, evaluator, psadmission.DefaultPodSpecExtractor{}, NewEvaluationCounter(), level)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return admissions
+}
+
+func podAttributes(pod *corev1.Pod) *psapi.AttributesRecord {
+	return &psapi.AttributesRecord{
+		Namespace: pod.Namespace,
+		Name:      pod.Name,
+		Resource:  corev1.SchemeGroupVersion.WithResource("pods"),
+		Operation: admissionv1.Create,
+		Object:    pod,
+	}
+}
+
+func TestValidateMatchesPerLevelAdmission(t *testing.T) {
+	ctx := context.Background()
+	pods := testPods()
+
+	adm, err := NewParallelAdmissionWithPodLister(staticPodLister(pods), NewEvaluationCounter())
+	if err != nil {
+		t.Fatal(err)
+	}
+	perLevel := newPerLevelAdmissions(t, staticPodLister(pods))
+
+	for _, pod := range pods {
+		t.Run(pod.Name, func(t *testing.T) {
+			result := adm.Validate(ctx, podAttributes(pod))
+			responses := map[psapi.Level]*admissionv1.AdmissionResponse{
+				psapi.LevelPrivileged: result.Privileged,
+				psapi.LevelBaseline:   result.Baseline,
+				psapi.LevelRestricted: result.Restricted,
+			}
+
+			for level, upstream := range perLevel {
+				expected := upstream.Validate(ctx, podAttributes(pod))
+				if got := responses[level]; got.Allowed != expected.Allowed {
+					t.Errorf("%s: expected allowed=%v, got allowed=%v (failed checks: %v)", level, expected.Allowed, got.Allowed, result.FailedChecks)
+				} else if !expected.Allowed && got.Result.Message != expected.Result.Message {
+					t.Errorf("%s: expected message %q, got %q", level, expected.Result.Message, got.Result.Message)
+				}
+			}
+
+			level, _ := adm.evaluatePod(pod)
+			if expected := result.MostRestrictivePolicy(); level != expected {
+				t.Errorf("evaluatePod: expected level %s, got %s", expected, level)
+			}
+		})
+	}
+}
+
+func TestValidateNamespacesIgnoresNamespaceGetter(t *testing.T) {
+	ctx := context.Background()
+	pods := testPods()
+	ns := corev1.Namespace{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"},
+		ObjectMeta: metav1.ObjectMeta{Name: "test"},
+	}
+
+	adm, err := NewParallelAdmissionWithPodLister(staticPodLister(pods), NewEvaluationCounter())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := adm.ValidateNamespaces(ctx, ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, level := range []psapi.Level{psapi.LevelPrivileged, psapi.LevelBaseline, psapi.LevelRestricted} {
+		t.Run(string(level), func(t *testing.T) {
+			adm.namespaceAdmission.NamespaceGetter = namespaceGetterFunc(func(_ context.Context, name string) (*corev1.Namespace, error) {
+				return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+					Name:   name,
+					Labels: map[string]string{psapi.EnforceLevelLabel: string(level), psapi.EnforceVersionLabel: "v1.22"},
+				}}, nil
+			})
+
+			got, err := adm.ValidateNamespaces(ctx, ns)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got["test"].Level != expected["test"].Level {
+				t.Errorf("expected level %s, got %s", expected["test"].Level, got["test"].Level)
+			}
+			if fmt.Sprint(got["test"].Violations) != fmt.Sprint(expected["test"].Violations) {
+				t.Errorf("expected violations %v, got %v", expected["test"].Violations, got["test"].Violations)
+			}
+		})
+	}
+
+	if expected["test"].Level != psapi.LevelPrivileged {
+		t.Errorf("expected the namespace to require the privileged level, got %s", expected["test"].Level)
+	}
+}
+
+func TestEvaluateNamespacesMatchesValidateNamespaces(t *testing.T) {
+	ctx := context.Background()
+
+	for _, pod := range testPods() {
+		t.Run(pod.Name, func(t *testing.T) {
+			ns := corev1.Namespace{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"},
+				ObjectMeta: metav1.ObjectMeta{Name: "test"},
+			}
+			adm, err := NewParallelAdmissionWithPodLister(staticPodLister{pod}, NewEvaluationCounter())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			evaluated, err := adm.EvaluateNamespaces(ctx, ns)
+			if err != nil {
+				t.Fatal(err)
+			}
+			validated, err := adm.ValidateNamespaces(ctx, ns)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if evaluated["test"].Level != validated["test"].Level {
+				t.Errorf("expected level %s, got %s", validated["test"].Level, evaluated["test"].Level)
+			}
+		})
+	}
+}
+
+func TestValidateNamespacesSkipsTerminatedPods(t *testing.T) {
+	ctx := context.Background()
+	ns := corev1.Namespace{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"},
+		ObjectMeta: metav1.ObjectMeta{Name: "test"},
+	}
+	pods := staticPodLister{
+		testPod("restricted", nil),
+		testPod("completed-privileged", func(pod *corev1.Pod) {
+			pod.Spec.Containers[0].SecurityContext.Privileged = boolPtr(true)
+			pod.Status.Phase = corev1.PodSucceeded
+		}),
+		testPod("failed-host-network", func(pod *corev1.Pod) {
+			pod.Spec.HostNetwork = true
+			pod.Status.Phase = corev1.PodFailed
+		}),
+	}
+	adm, err := NewParallelAdmissionWithPodLister(pods, NewEvaluationCounter())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validated, err := adm.ValidateNamespaces(ctx, ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := validated["test"]; got.Level != psapi.LevelRestricted || got.TotalPods != 1 || len(got.Violations) > 0 {
+		t.Errorf("expected the restricted level from the single running pod, got %s with %d pods and violations %v", got.Level, got.TotalPods, got.Violations)
+	}
+
+	evaluated, err := adm.EvaluateNamespaces(ctx, ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if evaluated["test"].Level != validated["test"].Level {
+		t.Errorf("expected level %s, got %s", validated["test"].Level, evaluated["test"].Level)
+	}
+
+	// the pods not prefetched by ValidateNamespaces are listed from the delegate
+	listed, err := (&prefetchedPodLister{delegate: pods}).ListPods(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0].Name != "restricted" {
+		t.Errorf("expected only the running pod to be listed, got %d pods", len(listed))
+	}
+}
+
+// benchmarkPods returns n pods cycling through the test pods
+func benchmarkPods(n int) []*corev1.Pod {
+	templates := testPods()
+	pods := make([]*corev1.Pod, 0, n)
+	for i := 0; i < n; i++ {
+		pod := templates[i%len(templates)].DeepCopy()
+		pod.Name = fmt.Sprintf("%s-%d", pod.Name, i)
+		pods = append(pods, pod)
+	}
+	return pods
+}
+
+// BenchmarkValidate compares evaluating all the levels in a single pass over the checks with
+// running the upstream admission for each of the levels
+func BenchmarkValidate(b *testing.B) {
+	ctx := context.Background()
+	pods := benchmarkPods(5000)
+
+	b.Run("single-pass", func(b *testing.B) {
+		adm, err := NewParallelAdmissionWithPodLister(staticPodLister(nil), NewEvaluationCounter())
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for _, pod := range pods {
+				adm.Validate(ctx, podAttributes(pod))
+			}
+		}
+	})
+
+	b.Run("per-level", func(b *testing.B) {
+		perLevel := newPerLevelAdmissions(b, staticPodLister(nil))
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for _, pod := range pods {
+				for _, adm := range perLevel {
+					adm.Validate(ctx, podAttributes(pod))
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,173 @@
+package admission
+
+import (
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	psapi "k8s.io/pod-security-admission/api"
+	"k8s.io/pod-security-admission/policy"
+)
+
+// FailedCheck describes a single PodSecurity check that a pod did not pass.
+type FailedCheck struct {
+	ID     policy.CheckID `json:"id"`
+	Level  psapi.Level    `json:"level"`
+	Reason string         `json:"reason"`
+	Detail string         `json:"detail,omitempty"`
+}
+
+// checkEvaluator runs the PodSecurity checks one-by-one so that, unlike with policy.Evaluator,
+// it is possible to tell which of the checks failed.
+type checkEvaluator struct {
+	// checks are ordered the same way policy.Evaluator orders them: baseline checks first,
+	// then restricted, each sorted by their ID
+	checks []policy.Check
+
+	latestOverrides map[policy.CheckID]bool
+}
+
+func newCheckEvaluator(checks []policy.Check) *checkEvaluator {
+	sorted := make([]policy.Check, len(checks))
+	copy(sorted, checks)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Level != sorted[j].Level {
+			return sorted[i].Level == psapi.LevelBaseline
+		}
+		return sorted[i].ID < sorted[j].ID
+	})
+
+	e := &checkEvaluator{checks: sorted}
+	e.latestOverrides = e.overrides(psapi.LatestVersion())
+	return e
+}
+
+// overrides returns the IDs of the baseline checks that are replaced by a restricted
+// check when evaluating the restricted level of the given version
+func (e *checkEvaluator) overrides(version psapi.Version) map[policy.CheckID]bool {
+	if version.Latest() && e.latestOverrides != nil {
+		return e.latestOverrides
+	}
+
+	overridden := map[policy.CheckID]bool{}
+	for _, check := range e.checks {
+		if check.Level != psapi.LevelRestricted {
+			continue
+		}
+		if versionedCheck := checkForVersion(check, version); versionedCheck != nil {
+			for _, id := range versionedCheck.OverrideCheckIDs {
+				overridden[id] = true
+			}
+		}
+	}
+	return overridden
+}
+
+// appliesToLevel returns true if the check is a part of the given level and version
+func (e *checkEvaluator) appliesToLevel(check FailedCheck, lv psapi.LevelVersion) bool {
+	switch lv.Level {
+	case psapi.LevelBaseline:
+		return check.Level == psapi.LevelBaseline
+	case psapi.LevelRestricted:
+		return !e.overrides(lv.Version)[check.ID]
+	default:
+		return false
+	}
+}
+
+// passes returns true if none of the failed checks is a part of the level
+func (e *checkEvaluator) passes(lv psapi.LevelVersion, failed []FailedCheck) bool {
+	for _, check := range failed {
+		if e.appliesToLevel(check, lv) {
+			return false
+		}
+	}
+	return true
+}
+
+// failedChecks runs all baseline and restricted checks in their revision for the given
+// version and returns those that failed. Baseline checks that are overridden by a restricted
+// check are evaluated as well so that the result also tells whether the pod passes baseline.
+func (e *checkEvaluator) failedChecks(version psapi.Version, podMeta *metav1.ObjectMeta, podSpec *corev1.PodSpec) []FailedCheck {
+	var failed []FailedCheck
+	for _, check := range e.checks {
+		versionedCheck := checkForVersion(check, version)
+		if versionedCheck == nil {
+			continue
+		}
+
+		if res := versionedCheck.CheckPod(podMeta, podSpec); !res.Allowed {
+			failed = append(failed, FailedCheck{
+				ID:     check.ID,
+				Level:  check.Level,
+				Reason: res.ForbiddenReason,
+				Detail: res.ForbiddenDetail,
+			})
+		}
+	}
+	return failed
+}
+
+// checkForVersion returns the revision of the check that applies to the given version
+// or nil if the check did not exist in that version.
+func checkForVersion(check policy.Check, version psapi.Version) *policy.VersionedCheck {
+	if version.Latest() {
+		return &check.Versions[len(check.Versions)-1]
+	}
+
+	var ret *policy.VersionedCheck
+	for i := range check.Versions {
+		if version.Older(check.Versions[i].MinimumVersion) {
+			break
+		}
+		ret = &check.Versions[i]
+	}
+	return ret
+}
+
+// FailedChecks returns the latest-version PodSecurity checks the pod does not pass.
+func (a *ParallelAdmission) FailedChecks(podMeta *metav1.ObjectMeta, podSpec *corev1.PodSpec) []FailedCheck {
+	evaluate := func() []FailedCheck {
+		return a.checks.failedChecks(psapi.LatestVersion(), podMeta, podSpec)
+	}
+	if a.specCache == nil {
+		return evaluate()
+	}
+	return a.specCache.failedChecks(podMeta, podSpec, evaluate)
+}
+
+// ChecksFailingLevel returns the failed checks that are a part of the latest version of the level.
+func (a *ParallelAdmission) ChecksFailingLevel(failed []FailedCheck, level psapi.Level) []FailedCheck {
+	lv := psapi.LevelVersion{Level: level, Version: psapi.LatestVersion()}
+
+	var ret []FailedCheck
+	for _, check := range failed {
+		if a.checks.appliesToLevel(check, lv) {
+			ret = append(ret, check)
+		}
+	}
+	return ret
+}
+
+// ChecksFailingLevelVersion evaluates the pod or the pod template of the object against the checks
+// of the given level and version and returns those it does not pass. Unlike FailedChecks, it
+// evaluates the revisions of the checks of the version and it does not use the spec cache.
+func (a *ParallelAdmission) ChecksFailingLevelVersion(obj runtime.Object, lv psapi.LevelVersion) ([]FailedCheck, error) {
+	podMeta, podSpec, err := a.podSpecExtractor.ExtractPodSpec(obj)
+	if err != nil {
+		return nil, fmt.Errorf("error extracting pod spec: %w", err)
+	}
+	if podSpec == nil {
+		return nil, nil
+	}
+
+	var ret []FailedCheck
+	for _, check := range a.checks.failedChecks(lv.Version, podMeta, podSpec) {
+		if a.checks.appliesToLevel(check, lv) {
+			ret = append(ret, check)
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,99 @@
+package admission
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	psapi "k8s.io/pod-security-admission/api"
+	psmetrics "k8s.io/pod-security-admission/metrics"
+)
+
+// EvaluationKey identifies a group of evaluations recorded by the admission
+type EvaluationKey struct {
+	Decision psmetrics.Decision
+	Level    psapi.Level
+	Version  string
+	Mode     psmetrics.Mode
+}
+
+// EvaluationCounts is a snapshot of the counts collected by EvaluationCounter
+type EvaluationCounts struct {
+	Evaluations map[EvaluationKey]int
+	Errors      int
+	FatalErrors int
+}
+
+// String returns a human-readable summary of the counts, one item per line.
+func (c *EvaluationCounts) String() string {
+	keys := make([]EvaluationKey, 0, len(c.Evaluations))
+	for k := range c.Evaluations {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+	})
+
+	b := &strings.Builder{}
+	for _, k := range keys {
+		fmt.Fprintf(b, "evaluations %s:%s (%s, %s): %d\n", k.Level, k.Version, k.Mode, k.Decision, c.Evaluations[k])
+	}
+	fmt.Fprintf(b, "errors: %d (fatal: %d)\n", c.Errors, c.FatalErrors)
+	return b.String()
+}
+
+// EvaluationCounter is a metrics recorder for the PodSecurity admission that counts
+// the evaluations and errors the admission records.
+type EvaluationCounter struct {
+	lock   sync.Mutex
+	counts EvaluationCounts
+}
+
+var _ psmetrics.Recorder = &EvaluationCounter{}
+
+func NewEvaluationCounter() *EvaluationCounter {
+	return &EvaluationCounter{
+		counts: EvaluationCounts{
+			Evaluations: map[EvaluationKey]int{},
+		},
+	}
+}
+
+func (r *EvaluationCounter) RecordEvaluation(decision psmetrics.Decision, policy psapi.LevelVersion, mode psmetrics.Mode, _ psapi.Attributes) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.counts.Evaluations[EvaluationKey{
+		Decision: decision,
+		Level:    policy.Level,
+		Version:  policy.Version.String(),
+		Mode:     mode,
+	}]++
+}
+
+// RecordExemption does nothing, the admission is configured without any exemptions
+func (r *EvaluationCounter) RecordExemption(psapi.Attributes) {}
+
+func (r *EvaluationCounter) RecordError(fatal bool, _ psapi.Attributes) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.counts.Errors++
+	if fatal {
+		r.counts.FatalErrors++
+	}
+}
+
+// Counts returns a copy of the current counts.
+func (r *EvaluationCounter) Counts() EvaluationCounts {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	ret := r.counts
+	ret.Evaluations = make(map[EvaluationKey]int, len(r.counts.Evaluations))
+	for k, v := range r.counts.Evaluations {
+		ret.Evaluations[k] = v
+	}
+	return ret
+}
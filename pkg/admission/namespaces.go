@@ -0,0 +1,284 @@
+package admission
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	psapi "k8s.io/pod-security-admission/api"
+	"k8s.io/pod-security-admission/policy"
+
+	"github.com/stlaz/psachecker/pkg/openshift"
+)
+
+const (
+	// admissionMaxPodsToCheck and admissionPodCheckTimeout are the limits the PodSecurity
+	// admission applies when checking the existing pods of a namespace whose enforce
+	// label gets updated
+	admissionMaxPodsToCheck  = 3000
+	admissionPodCheckTimeout = time.Second
+)
+
+// NamespaceEvaluation is the result of evaluating the pods of a namespace.
+type NamespaceEvaluation struct {
+	Name string `json:"name"`
+	// Level is the most restrictive level all the pods of the namespace are able to run with
+	Level psapi.Level `json:"level"`
+	// Violations are the checks the pods of the namespace violate, keyed by the levels
+	// the namespace did not pass
+	Violations map[psapi.Level][]Violation `json:"violations,omitempty"`
+	// TotalPods is the number of the pods in the namespace
+	TotalPods int `json:"totalPods"`
+	// AdmissionCheckedPods is the number of pods the PodSecurity admission checks within
+	// its pod count and time limits on an enforce label update. Unless it was reported by
+	// the admission itself, it is only an estimate, see AdmissionCheckedPodsEstimated.
+	AdmissionCheckedPods int `json:"admissionCheckedPods"`
+	// AdmissionCheckedPodsEstimated is set if AdmissionCheckedPods was estimated from the time
+	// the pods took to evaluate here rather than reported by the admission. The admission
+	// evaluates the pods separately for the new level, on a different machine and load.
+	AdmissionCheckedPodsEstimated bool `json:"admissionCheckedPodsEstimated,omitempty"`
+	// LabelsSynced is set for the OpenShift namespaces whose PodSecurity labels are managed
+	// by the label syncer
+	LabelsSynced bool `json:"labelsSynced,omitempty"`
+	// SCCLevels counts the pods admitted by each of the OpenShift SecurityContextConstraints
+	// by the most restrictive levels they are able to run with
+	SCCLevels map[string]map[psapi.Level]int `json:"sccLevels,omitempty"`
+}
+
+// Violation is a set of PodSecurity checks violated by one or more pods.
+type Violation struct {
+	// Pod is the alphabetically first of the violating pods
+	Pod      string `json:"pod"`
+	PodCount int    `json:"podCount"`
+	// Checks are the IDs of the violated checks, they are only missing if the reasons
+	// could not be matched with any known check
+	Checks  []policy.CheckID `json:"checks,omitempty"`
+	Reasons []string         `json:"reasons"`
+}
+
+func (v Violation) String() string {
+	pods := v.Pod
+	switch {
+	case v.PodCount == 2:
+		pods += " (and 1 other pod)"
+	case v.PodCount > 2:
+		pods += fmt.Sprintf(" (and %d other pods)", v.PodCount-1)
+	}
+
+	checks := make([]string, 0, len(v.Checks))
+	for _, id := range v.Checks {
+		checks = append(checks, string(id))
+	}
+	if len(checks) == 0 {
+		checks = v.Reasons
+	}
+
+	return fmt.Sprintf("%s: %s", pods, strings.Join(checks, ", "))
+}
+
+// Truncated returns true if the PodSecurity admission does not check all the pods. If the
+// evaluation estimated the number of the checked pods, it is only a likely outcome.
+func (e *NamespaceEvaluation) Truncated() bool {
+	return e.AdmissionCheckedPods < e.TotalPods
+}
+
+func (e *NamespaceEvaluation) addSCCLevel(scc string, level psapi.Level) {
+	if len(scc) == 0 {
+		return
+	}
+	if e.SCCLevels == nil {
+		e.SCCLevels = map[string]map[psapi.Level]int{}
+	}
+	if e.SCCLevels[scc] == nil {
+		e.SCCLevels[scc] = map[psapi.Level]int{}
+	}
+	e.SCCLevels[scc][level]++
+}
+
+func (e *NamespaceEvaluation) addViolations(level psapi.Level, violations []Violation) {
+	if len(violations) == 0 {
+		return
+	}
+	if e.Violations == nil {
+		e.Violations = map[psapi.Level][]Violation{}
+	}
+	e.Violations[level] = append(e.Violations[level], violations...)
+}
+
+// NamespaceEvaluations maps the names of the namespaces to their evaluations.
+type NamespaceEvaluations map[string]*NamespaceEvaluation
+
+// SCCLevels sums the pods admitted by each of the OpenShift SecurityContextConstraints by the
+// levels they are able to run with across all the evaluated namespaces.
+func (e NamespaceEvaluations) SCCLevels() map[string]map[psapi.Level]int {
+	sccLevels := map[string]map[psapi.Level]int{}
+	for _, evaluation := range e {
+		for scc, levels := range evaluation.SCCLevels {
+			if sccLevels[scc] == nil {
+				sccLevels[scc] = map[psapi.Level]int{}
+			}
+			for level, count := range levels {
+				sccLevels[scc][level] += count
+			}
+		}
+	}
+	return sccLevels
+}
+
+// Levels returns the most restrictive levels of the evaluated namespaces.
+func (e NamespaceEvaluations) Levels() map[string]psapi.Level {
+	levels := make(map[string]psapi.Level, len(e))
+	for name, evaluation := range e {
+		levels[name] = evaluation.Level
+	}
+	return levels
+}
+
+// EvaluateNamespaces evaluates each pod of the namespaces and returns the most restrictive
+// level for each of the namespaces. Unlike ValidateNamespaces, it is not limited by the
+// number of the pods or by the time it takes to check them.
+func (a *ParallelAdmission) EvaluateNamespaces(ctx context.Context, namespaces ...corev1.Namespace) (NamespaceEvaluations, error) {
+	results, _, err := a.InspectNamespaces(ctx, namespaces...)
+	return results, err
+}
+
+// InspectNamespaces is EvaluateNamespaces that also returns the per-workload results of the
+// namespaces. Each pod is only evaluated once for both of the results. The pods that already
+// terminated are counted but they don't affect the levels of the namespaces.
+func (a *ParallelAdmission) InspectNamespaces(ctx context.Context, namespaces ...corev1.Namespace) (NamespaceEvaluations, []*NamespaceResult, error) {
+	results := make(NamespaceEvaluations, len(namespaces))
+	inspections := make([]*NamespaceResult, 0, len(namespaces))
+	for i := range namespaces {
+		ns := &namespaces[i]
+		pods, err := a.podLister.ListPods(ctx, ns.Name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to list pods in namespace %q: %w", ns.Name, err)
+		}
+		pods = prioritizePods(dedupePods(pods))
+
+		result := &NamespaceEvaluation{
+			Name:                 ns.Name,
+			Level:                psapi.LevelRestricted,
+			TotalPods:            len(pods),
+			AdmissionCheckedPods: len(pods),
+			LabelsSynced:         openshift.LabelsSynced(ns),
+		}
+		if result.TotalPods > admissionMaxPodsToCheck {
+			result.AdmissionCheckedPods = admissionMaxPodsToCheck
+		}
+
+		workloads := newWorkloadAggregator(ns)
+		violations := map[psapi.Level]*violationAggregator{
+			psapi.LevelBaseline:   newViolationAggregator(),
+			psapi.LevelRestricted: newViolationAggregator(),
+		}
+		start := time.Now()
+		for i, pod := range pods {
+			if PodTerminated(pod) {
+				continue
+			}
+			podLevel, failedChecks := a.evaluatePod(pod)
+			result.Level = greaterPSAPrivileges(result.Level, podLevel)
+			result.addSCCLevel(openshift.PodSCC(pod), podLevel)
+			workloads.add(pod, podLevel, failedChecks)
+
+			for level, aggregator := range violations {
+				levelVersion := psapi.LevelVersion{Level: level, Version: psapi.LatestVersion()}
+
+				var violation Violation
+				for _, check := range failedChecks {
+					if a.checks.appliesToLevel(check, levelVersion) {
+						violation.Checks = append(violation.Checks, check.ID)
+						violation.Reasons = append(violation.Reasons, check.Reason)
+					}
+				}
+				if len(violation.Checks) > 0 {
+					violation.Pod, violation.PodCount = pod.Name, 1
+					aggregator.add(violation)
+				}
+			}
+
+			// the admission runs out of its time budget once checking a pod takes it over the
+			// timeout, the pods here are checked for all levels at once so this is only an estimate
+			if i+1 < result.AdmissionCheckedPods && time.Since(start) > admissionPodCheckTimeout {
+				result.AdmissionCheckedPods = i + 1
+				result.AdmissionCheckedPodsEstimated = true
+			}
+		}
+
+		for level, aggregator := range violations {
+			result.addViolations(level, aggregator.violations())
+		}
+
+		results[ns.Name] = result
+		inspections = append(inspections, workloads.result())
+	}
+
+	return results, inspections, nil
+}
+
+// violationAggregator merges the violations of the same checks into one
+type violationAggregator struct {
+	byChecks map[string]*Violation
+}
+
+func newViolationAggregator() *violationAggregator {
+	return &violationAggregator{byChecks: map[string]*Violation{}}
+}
+
+func (g *violationAggregator) add(v Violation) {
+	key := strings.Join(v.Reasons, ", ")
+	existing, ok := g.byChecks[key]
+	if !ok {
+		g.byChecks[key] = &v
+		return
+	}
+
+	existing.PodCount += v.PodCount
+	if v.Pod < existing.Pod {
+		existing.Pod = v.Pod
+	}
+}
+
+// violations returns the aggregated violations sorted by their pod names
+func (g *violationAggregator) violations() []Violation {
+	ret := make([]Violation, 0, len(g.byChecks))
+	for _, v := range g.byChecks {
+		ret = append(ret, *v)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Pod != ret[j].Pod {
+			return ret[i].Pod < ret[j].Pod
+		}
+		return strings.Join(ret[i].Reasons, ", ") < strings.Join(ret[j].Reasons, ", ")
+	})
+	return ret
+}
+
+// prioritizePods orders the pods the same way the PodSecurity admission does before it
+// checks them: the first pod of each controller goes first, followed by the rest of the
+// pods of the controllers. The pods slice is not modified.
+func prioritizePods(pods []*corev1.Pod) []*corev1.Pod {
+	prioritized := make([]*corev1.Pod, 0, len(pods))
+	var duplicateReplicatedPods []*corev1.Pod
+	evaluatedControllers := make(map[types.UID]bool)
+	for _, pod := range pods {
+		owner := metav1.GetControllerOfNoCopy(pod)
+		if owner == nil {
+			prioritized = append(prioritized, pod)
+			continue
+		}
+		if evaluatedControllers[owner.UID] {
+			duplicateReplicatedPods = append(duplicateReplicatedPods, pod)
+			continue
+		}
+		prioritized = append(prioritized, pod)
+		evaluatedControllers[owner.UID] = true
+	}
+	return append(prioritized, duplicateReplicatedPods...)
+}
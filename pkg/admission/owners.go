@@ -0,0 +1,42 @@
+package admission
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+// cronJobsSince is the time before which no CronJob could have scheduled a Job, the Job names
+// with smaller suffixes are not taken for the scheduled times of CronJobs, e.g. "backup-20230101"
+var cronJobsSince = time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// TopLevelOwner returns the kind and the name of the controller that manages the given controller
+// of pods with the given labels, so that the workloads keep their identity across rollouts. The
+// ReplicaSets of a Deployment are named by the hash of the pod template of the revision, the Jobs
+// of a CronJob by their scheduled time in minutes. Other controllers are returned unchanged.
+func TopLevelOwner(kind, name string, podLabels map[string]string) (string, string) {
+	switch kind {
+	case "ReplicaSet":
+		if hash, ok := podLabels[appsv1.DefaultDeploymentUniqueLabelKey]; ok && strings.HasSuffix(name, "-"+hash) {
+			return "Deployment", strings.TrimSuffix(name, "-"+hash)
+		}
+	case "Job":
+		i := strings.LastIndex(name, "-")
+		if i <= 0 {
+			break
+		}
+		if minutes, err := strconv.ParseInt(name[i+1:], 10, 64); err == nil && minutes >= cronJobsSince.Unix()/60 {
+			return "CronJob", name[:i]
+		}
+	}
+	return kind, name
+}
+
+// OwnerKey returns the "Kind/name" identifier of the top-level owner of the workload, it only
+// differs from String for the results stored before the workloads were keyed by their top-level owners
+func (w *WorkloadResult) OwnerKey() string {
+	kind, name := TopLevelOwner(w.Kind, w.Name, w.PodLabels)
+	return kind + "/" + name
+}
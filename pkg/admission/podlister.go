@@ -0,0 +1,76 @@
+package admission
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	psadmission "k8s.io/pod-security-admission/admission"
+)
+
+type namespacePodsKey struct{}
+
+// namespacePods are the pods of a namespace that were already retrieved for its evaluation
+type namespacePods struct {
+	namespace string
+	pods      []*corev1.Pod
+}
+
+// withNamespacePods returns a context that makes the prefetchedPodLister return the given
+// pods when asked for the pods of the namespace
+func withNamespacePods(ctx context.Context, namespace string, pods []*corev1.Pod) context.Context {
+	return context.WithValue(ctx, namespacePodsKey{}, &namespacePods{namespace: namespace, pods: pods})
+}
+
+// prefetchedPodLister returns the pods stored in the context by withNamespacePods so that
+// evaluating a namespace for several levels does not list its pods for each of them.
+// The pods of other namespaces are retrieved from the delegate, without the terminated ones.
+type prefetchedPodLister struct {
+	delegate psadmission.PodLister
+}
+
+var _ psadmission.PodLister = &prefetchedPodLister{}
+
+func (l *prefetchedPodLister) ListPods(ctx context.Context, namespace string) ([]*corev1.Pod, error) {
+	if prefetched, ok := ctx.Value(namespacePodsKey{}).(*namespacePods); ok && prefetched.namespace == namespace {
+		// the admission reorders the list in place, don't let it modify the shared one
+		pods := make([]*corev1.Pod, len(prefetched.pods))
+		copy(pods, prefetched.pods)
+		return pods, nil
+	}
+	pods, err := l.delegate.ListPods(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	return activePods(pods), nil
+}
+
+// activePods drops the pods that terminated, they are not evaluated since they cannot be
+// affected by the namespace labels anymore
+func activePods(pods []*corev1.Pod) []*corev1.Pod {
+	active := make([]*corev1.Pod, 0, len(pods))
+	for _, pod := range pods {
+		if !PodTerminated(pod) {
+			active = append(active, pod)
+		}
+	}
+	return active
+}
+
+// dedupePods removes the pods that appear in the list more than once
+func dedupePods(pods []*corev1.Pod) []*corev1.Pod {
+	seen := make(map[types.UID]bool, len(pods))
+	deduped := make([]*corev1.Pod, 0, len(pods))
+	for _, pod := range pods {
+		key := pod.UID
+		if len(key) == 0 {
+			key = types.UID(pod.Namespace + "/" + pod.Name)
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		deduped = append(deduped, pod)
+	}
+	return deduped
+}
@@ -0,0 +1,297 @@
+package admission
+
+import (
+	"context"
+	"sort"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	psapi "k8s.io/pod-security-admission/api"
+	psmetrics "k8s.io/pod-security-admission/metrics"
+
+	"github.com/stlaz/psachecker/pkg/openshift"
+)
+
+// WorkloadResult describes the least privileged PodSecurity level a single workload
+// is able to run with.
+type WorkloadResult struct {
+	Kind         string        `json:"kind"`
+	Namespace    string        `json:"namespace"`
+	Name         string        `json:"name"`
+	MinimalLevel psapi.Level   `json:"minimalLevel"`
+	FailedChecks []FailedCheck `json:"failedChecks,omitempty"`
+	// SCCs are the OpenShift SecurityContextConstraints that admitted the pods of the workload
+	SCCs []string `json:"sccs,omitempty"`
+	// Images are the container images of the pods of the workload
+	Images []string `json:"images,omitempty"`
+	// RuntimeClass is the runtime class of the pods of the workload
+	RuntimeClass string `json:"runtimeClass,omitempty"`
+	// PodLabels are the labels shared by all the pods of the workload
+	PodLabels map[string]string `json:"podLabels,omitempty"`
+}
+
+// String returns the "Kind/name" identifier of the workload.
+func (w *WorkloadResult) String() string {
+	return w.Kind + "/" + w.Name
+}
+
+// NamespaceResult aggregates the results of the workloads of a single namespace.
+type NamespaceResult struct {
+	Name string `json:"name"`
+	// Labels are the PodSecurity labels the namespace carried at the time of the evaluation
+	Labels map[string]string `json:"labels,omitempty"`
+	// DefaultEnforce is the enforce level the cluster applies to namespaces without the enforce
+	// label, empty if it is not known
+	DefaultEnforce   psapi.Level      `json:"defaultEnforce,omitempty"`
+	RecommendedLevel psapi.Level      `json:"recommendedLevel"`
+	Workloads        []WorkloadResult `json:"workloads,omitempty"`
+	// OpenShift is the SCC configuration of the namespace, nil outside of OpenShift
+	OpenShift *openshift.NamespaceConfig `json:"openshift,omitempty"`
+	// LabelsSynced is set if the PodSecurity labels of the namespace are managed by
+	// the OpenShift label syncer
+	LabelsSynced bool `json:"labelsSynced,omitempty"`
+}
+
+// EnforceLevel returns the level the namespace currently enforces based on its labels. Namespaces
+// without the label enforce the default level, the privileged level if the default is not known.
+func (r *NamespaceResult) EnforceLevel() psapi.Level {
+	label, ok := r.Labels[psapi.EnforceLevelLabel]
+	if !ok && r.DefaultEnforce.Valid() {
+		return r.DefaultEnforce
+	}
+	level, err := psapi.ParseLevel(label)
+	if err != nil {
+		return psapi.LevelPrivileged
+	}
+	return level
+}
+
+// WorkloadsFailingLevel returns the workloads that would be rejected if the namespace
+// enforced the given level.
+func (r *NamespaceResult) WorkloadsFailingLevel(level psapi.Level) []WorkloadResult {
+	var failing []WorkloadResult
+	for _, w := range r.Workloads {
+		if psapi.CompareLevels(w.MinimalLevel, level) < 0 {
+			failing = append(failing, w)
+		}
+	}
+	return failing
+}
+
+// BlockingWorkloads returns the workloads that prevent the namespace from
+// using a more restrictive level than the recommended one.
+func (r *NamespaceResult) BlockingWorkloads() []WorkloadResult {
+	if r.RecommendedLevel == psapi.LevelRestricted {
+		return nil
+	}
+
+	var blocking []WorkloadResult
+	for _, w := range r.Workloads {
+		if w.MinimalLevel == r.RecommendedLevel {
+			blocking = append(blocking, w)
+		}
+	}
+	return blocking
+}
+
+var podGVK = corev1.SchemeGroupVersion.WithKind("Pod")
+
+// ValidatePods runs the admission for each of the pods as if they were just being created.
+func (a *ParallelAdmission) ValidatePods(ctx context.Context, pods ...*corev1.Pod) AdmissionResultsMap {
+	results := AdmissionResultsMap{}
+	for _, pod := range pods {
+		results[AdmissionResultsKey{
+			GVK:       podGVK,
+			Namespace: pod.Namespace,
+			Name:      pod.Name,
+		}] = a.validatePod(ctx, pod)
+	}
+	return results
+}
+
+func (a *ParallelAdmission) validatePod(ctx context.Context, pod *corev1.Pod) *ParallelAdmissionResult {
+	return a.Validate(ctx, &psapi.AttributesRecord{
+		Namespace: pod.Namespace,
+		Name:      pod.Name,
+		Resource:  corev1.SchemeGroupVersion.WithResource("pods"),
+		Operation: admissionv1.Create,
+		Object:    pod,
+	})
+}
+
+// evaluatePod returns the most restrictive level the pod is able to run with along with
+// the checks it fails. Unlike validatePod, it does not create the admission responses.
+func (a *ParallelAdmission) evaluatePod(pod *corev1.Pod) (psapi.Level, []FailedCheck) {
+	attrs := &psapi.AttributesRecord{
+		Namespace: pod.Namespace,
+		Name:      pod.Name,
+		Resource:  corev1.SchemeGroupVersion.WithResource("pods"),
+		Operation: admissionv1.Create,
+		Object:    pod,
+	}
+
+	failedChecks := a.FailedChecks(&pod.ObjectMeta, &pod.Spec)
+	level := psapi.LevelPrivileged
+	for _, l := range []psapi.Level{psapi.LevelPrivileged, psapi.LevelBaseline, psapi.LevelRestricted} {
+		lv := psapi.LevelVersion{Level: l, Version: psapi.LatestVersion()}
+		if !a.checks.passes(lv, failedChecks) {
+			a.metrics.RecordEvaluation(psmetrics.DecisionDeny, lv, psmetrics.ModeEnforce, attrs)
+			continue
+		}
+		a.metrics.RecordEvaluation(psmetrics.DecisionAllow, lv, psmetrics.ModeEnforce, attrs)
+		if psapi.CompareLevels(l, level) > 0 {
+			level = l
+		}
+	}
+	return level, failedChecks
+}
+
+// podLevel returns the most restrictive level the pod is able to run with, unlike evaluatePod,
+// it does not record the evaluations
+func (a *ParallelAdmission) podLevel(pod *corev1.Pod) psapi.Level {
+	failedChecks := a.FailedChecks(&pod.ObjectMeta, &pod.Spec)
+	for _, level := range []psapi.Level{psapi.LevelRestricted, psapi.LevelBaseline} {
+		if a.checks.passes(psapi.LevelVersion{Level: level, Version: psapi.LatestVersion()}, failedChecks) {
+			return level
+		}
+	}
+	return psapi.LevelPrivileged
+}
+
+// InspectNamespace evaluates the pods of the namespace and aggregates the results.
+// Pods that are owned by a controller are reported as that controller, each controller
+// is reported at most once with the most privileged level any of its pods requires.
+// Pods that already terminated are skipped, they don't need to be admitted anymore.
+func (a *ParallelAdmission) InspectNamespace(ctx context.Context, ns *corev1.Namespace, pods ...*corev1.Pod) *NamespaceResult {
+	inspection := newWorkloadAggregator(ns)
+	for _, pod := range pods {
+		if PodTerminated(pod) {
+			continue
+		}
+		level, failedChecks := a.evaluatePod(pod)
+		inspection.add(pod, level, failedChecks)
+	}
+	return inspection.result()
+}
+
+// workloadAggregator aggregates the evaluated pods of a namespace by their workloads
+type workloadAggregator struct {
+	namespace *NamespaceResult
+	workloads map[string]*WorkloadResult
+}
+
+func newWorkloadAggregator(ns *corev1.Namespace) *workloadAggregator {
+	g := &workloadAggregator{
+		namespace: &NamespaceResult{
+			Name:             ns.Name,
+			Labels:           psaLabels(ns.Labels),
+			RecommendedLevel: psapi.LevelRestricted,
+			OpenShift:        openshift.NamespaceConfigFor(ns),
+		},
+		workloads: map[string]*WorkloadResult{},
+	}
+	g.namespace.LabelsSynced = openshift.LabelsSynced(ns)
+	return g
+}
+
+func (g *workloadAggregator) add(pod *corev1.Pod, level psapi.Level, failedChecks []FailedCheck) {
+	kind, name := "Pod", pod.Name
+	if owner := metav1.GetControllerOf(pod); owner != nil {
+		kind, name = TopLevelOwner(owner.Kind, owner.Name, pod.Labels)
+	}
+
+	w, ok := g.workloads[kind+"/"+name]
+	if !ok {
+		w = &WorkloadResult{Kind: kind, Namespace: pod.Namespace, Name: name, MinimalLevel: level}
+		for k, v := range pod.Labels {
+			if w.PodLabels == nil {
+				w.PodLabels = map[string]string{}
+			}
+			w.PodLabels[k] = v
+		}
+		g.workloads[w.String()] = w
+	}
+	for k, v := range w.PodLabels {
+		if podValue, ok := pod.Labels[k]; !ok || podValue != v {
+			delete(w.PodLabels, k)
+		}
+	}
+	w.MinimalLevel = greaterPSAPrivileges(w.MinimalLevel, level)
+	w.FailedChecks = MergeFailedChecks(w.FailedChecks, failedChecks)
+	w.Images = sets.NewString(w.Images...).Insert(podImages(pod)...).List()
+	if pod.Spec.RuntimeClassName != nil {
+		w.RuntimeClass = *pod.Spec.RuntimeClassName
+	}
+	if scc := openshift.PodSCC(pod); len(scc) > 0 {
+		w.SCCs = sets.NewString(w.SCCs...).Insert(scc).List()
+	}
+	g.namespace.RecommendedLevel = greaterPSAPrivileges(g.namespace.RecommendedLevel, w.MinimalLevel)
+}
+
+// result returns the namespace result with the workloads sorted by their identifiers
+func (g *workloadAggregator) result() *NamespaceResult {
+	for _, w := range g.workloads {
+		g.namespace.Workloads = append(g.namespace.Workloads, *w)
+	}
+	sort.Slice(g.namespace.Workloads, func(i, j int) bool {
+		return g.namespace.Workloads[i].String() < g.namespace.Workloads[j].String()
+	})
+	return g.namespace
+}
+
+// PodTerminated returns true for the pods that ran to completion or failed
+func PodTerminated(pod *corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+}
+
+func podImages(pod *corev1.Pod) []string {
+	var images []string
+	for _, c := range pod.Spec.InitContainers {
+		images = append(images, c.Image)
+	}
+	for _, c := range pod.Spec.Containers {
+		images = append(images, c.Image)
+	}
+	for _, c := range pod.Spec.EphemeralContainers {
+		images = append(images, c.Image)
+	}
+	return images
+}
+
+// MergeFailedChecks appends the checks from newChecks that are not yet present in checks
+func MergeFailedChecks(checks, newChecks []FailedCheck) []FailedCheck {
+	for _, nc := range newChecks {
+		found := false
+		for _, c := range checks {
+			if c.ID == nc.ID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			checks = append(checks, nc)
+		}
+	}
+	return checks
+}
+
+var psaLabelKeys = []string{
+	psapi.EnforceLevelLabel,
+	psapi.EnforceVersionLabel,
+	psapi.AuditLevelLabel,
+	psapi.AuditVersionLabel,
+	psapi.WarnLevelLabel,
+	psapi.WarnVersionLabel,
+}
+
+func psaLabels(labels map[string]string) map[string]string {
+	ret := map[string]string{}
+	for _, k := range psaLabelKeys {
+		if v, ok := labels[k]; ok {
+			ret[k] = v
+		}
+	}
+	return ret
+}
@@ -0,0 +1,54 @@
+package admission
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func controlledBy(kind, name string) []metav1.OwnerReference {
+	controller := true
+	return []metav1.OwnerReference{{Kind: kind, Name: name, Controller: &controller}}
+}
+
+func TestInspectNamespaceTopLevelOwners(t *testing.T) {
+	revisionPod := func(name, hash string) *corev1.Pod {
+		return testPod(name, func(pod *corev1.Pod) {
+			pod.Labels = map[string]string{"app": "web", "pod-template-hash": hash}
+			pod.OwnerReferences = controlledBy("ReplicaSet", "web-"+hash)
+		})
+	}
+	pods := []*corev1.Pod{
+		// two revisions of the web Deployment in the middle of a rollout
+		revisionPod("web-5d4f8b9c6-x7k2p", "5d4f8b9c6"),
+		revisionPod("web-7f9c6d8b5-q2w3e", "7f9c6d8b5"),
+		// a ReplicaSet without a Deployment
+		testPod("standalone-abcde", func(pod *corev1.Pod) {
+			pod.Labels = map[string]string{"app": "standalone"}
+			pod.OwnerReferences = controlledBy("ReplicaSet", "standalone")
+		}),
+		testPod("db-0", func(pod *corev1.Pod) { pod.OwnerReferences = controlledBy("StatefulSet", "db") }),
+		testPod("debug", nil),
+	}
+
+	adm, err := NewParallelAdmissionWithPodLister(staticPodLister(nil), NewEvaluationCounter())
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := adm.InspectNamespace(context.Background(), &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test"}}, pods...)
+
+	var got []string
+	for _, w := range result.Workloads {
+		got = append(got, w.String())
+	}
+	expected := []string{"Deployment/web", "Pod/debug", "ReplicaSet/standalone", "StatefulSet/db"}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected workloads %v, got %v", expected, got)
+	}
+	if labels := result.Workloads[0].PodLabels; !reflect.DeepEqual(labels, map[string]string{"app": "web"}) {
+		t.Errorf("expected the labels shared by both revisions, got %v", labels)
+	}
+}
@@ -0,0 +1,246 @@
+package admission
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/pod-security-admission/policy"
+)
+
+// specCacheFormat is bumped whenever the meaning of the cached data changes so that
+// caches persisted by older builds get discarded
+const specCacheFormat = 1
+
+// SpecCache remembers the failed checks of the evaluated pod specs so that pods sharing
+// the same template, e.g. the replicas of a ReplicaSet, only get evaluated once.
+type SpecCache struct {
+	lock    sync.Mutex
+	entries map[string][]FailedCheck
+
+	hits, misses int
+}
+
+func NewSpecCache() *SpecCache {
+	return &SpecCache{entries: map[string][]FailedCheck{}}
+}
+
+// persistedSpecCache is the on-disk form of the cache
+type persistedSpecCache struct {
+	Format int `json:"format"`
+	// ChecksVersion identifies the checks that computed the cached results
+	ChecksVersion string                   `json:"checksVersion"`
+	Entries       map[string][]FailedCheck `json:"entries"`
+}
+
+// LoadSpecCache reads a cache persisted by SpecCache.Save. A missing file or a file written
+// for different checks results in an empty cache.
+func LoadSpecCache(path string) (*SpecCache, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return NewSpecCache(), nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read the spec cache: %w", err)
+	}
+
+	persisted := &persistedSpecCache{}
+	if err := json.Unmarshal(data, persisted); err != nil {
+		return nil, fmt.Errorf("failed to parse the spec cache %q: %w", path, err)
+	}
+
+	cache := NewSpecCache()
+	if persisted.Format == specCacheFormat && persisted.ChecksVersion == checksVersion() && persisted.Entries != nil {
+		cache.entries = persisted.Entries
+	}
+	return cache, nil
+}
+
+// Save writes the cache to the path so that it can be loaded by LoadSpecCache.
+func (c *SpecCache) Save(path string) error {
+	c.lock.Lock()
+	data, err := json.Marshal(&persistedSpecCache{
+		Format:        specCacheFormat,
+		ChecksVersion: checksVersion(),
+		Entries:       c.entries,
+	})
+	c.lock.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to serialize the spec cache: %w", err)
+	}
+
+	// write to a temporary file first so that concurrent readers never see a partial cache
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to write the spec cache: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write the spec cache: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write the spec cache: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write the spec cache: %w", err)
+	}
+	return nil
+}
+
+// HitRate returns the number of lookups served from the cache, the number of all lookups
+// and the ratio of the two.
+func (c *SpecCache) HitRate() (hits, lookups int, rate float64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	lookups = c.hits + c.misses
+	if lookups > 0 {
+		rate = float64(c.hits) / float64(lookups)
+	}
+	return c.hits, lookups, rate
+}
+
+// failedChecks returns the cached failed checks of the pod or evaluates them and caches the result
+func (c *SpecCache) failedChecks(podMeta *metav1.ObjectMeta, podSpec *corev1.PodSpec, evaluate func() []FailedCheck) []FailedCheck {
+	key, err := specHash(podMeta, podSpec)
+	if err != nil {
+		return evaluate()
+	}
+
+	c.lock.Lock()
+	cached, ok := c.entries[key]
+	if ok {
+		c.hits++
+	} else {
+		c.misses++
+	}
+	c.lock.Unlock()
+
+	if !ok {
+		cached = evaluate()
+
+		c.lock.Lock()
+		c.entries[key] = cached
+		c.lock.Unlock()
+	}
+
+	// callers are free to modify the returned slice
+	ret := make([]FailedCheck, len(cached))
+	copy(ret, cached)
+	return ret
+}
+
+// specKey holds the parts of a pod the PodSecurity checks read. Fields that differ between
+// the pods of the same template but that the checks ignore, e.g. the node name or the
+// env variables, are left out so that such pods share the key.
+// IMPORTANT: this must be kept in sync with the checks in k8s.io/pod-security-admission/policy,
+// TestSpecCacheMissesOnCheckedFields lists the fields the checks read.
+type specKey struct {
+	Annotations     map[string]string          `json:"annotations,omitempty"`
+	HostNetwork     bool                       `json:"hostNetwork,omitempty"`
+	HostPID         bool                       `json:"hostPID,omitempty"`
+	HostIPC         bool                       `json:"hostIPC,omitempty"`
+	OS              *corev1.PodOS              `json:"os,omitempty"`
+	SecurityContext *corev1.PodSecurityContext `json:"securityContext,omitempty"`
+	Volumes         []corev1.Volume            `json:"volumes,omitempty"`
+
+	InitContainers      []containerKey `json:"initContainers,omitempty"`
+	Containers          []containerKey `json:"containers,omitempty"`
+	EphemeralContainers []containerKey `json:"ephemeralContainers,omitempty"`
+}
+
+type containerKey struct {
+	Name            string                  `json:"name"`
+	SecurityContext *corev1.SecurityContext `json:"securityContext,omitempty"`
+	Ports           []corev1.ContainerPort  `json:"ports,omitempty"`
+}
+
+// checkedAnnotationPrefixes are the prefixes of the annotations read by the AppArmor
+// and the seccomp checks
+var checkedAnnotationPrefixes = []string{
+	corev1.AppArmorBetaContainerAnnotationKeyPrefix,
+	corev1.SeccompPodAnnotationKey,
+	corev1.SeccompContainerAnnotationKeyPrefix,
+}
+
+// specHash returns a canonical hash of the parts of the pod that the checks read
+func specHash(podMeta *metav1.ObjectMeta, podSpec *corev1.PodSpec) (string, error) {
+	key := specKey{
+		HostNetwork:     podSpec.HostNetwork,
+		HostPID:         podSpec.HostPID,
+		HostIPC:         podSpec.HostIPC,
+		OS:              podSpec.OS,
+		SecurityContext: podSpec.SecurityContext,
+	}
+
+	for k, v := range podMeta.Annotations {
+		for _, prefix := range checkedAnnotationPrefixes {
+			if strings.HasPrefix(k, prefix) {
+				if key.Annotations == nil {
+					key.Annotations = map[string]string{}
+				}
+				key.Annotations[k] = v
+				break
+			}
+		}
+	}
+
+	// projected volumes are allowed by all levels, the service account token volume
+	// that gets injected into each pod has a random name
+	for _, v := range podSpec.Volumes {
+		if v.Projected != nil && strings.HasPrefix(v.Name, "kube-api-access-") {
+			continue
+		}
+		key.Volumes = append(key.Volumes, v)
+	}
+
+	for _, c := range podSpec.InitContainers {
+		key.InitContainers = append(key.InitContainers, containerKey{Name: c.Name, SecurityContext: c.SecurityContext, Ports: c.Ports})
+	}
+	for _, c := range podSpec.Containers {
+		key.Containers = append(key.Containers, containerKey{Name: c.Name, SecurityContext: c.SecurityContext, Ports: c.Ports})
+	}
+	for _, c := range podSpec.EphemeralContainers {
+		key.EphemeralContainers = append(key.EphemeralContainers, containerKey{Name: c.Name, SecurityContext: c.SecurityContext, Ports: c.Ports})
+	}
+
+	// encoding/json sorts the map keys so the encoding is canonical
+	data, err := json.Marshal(&key)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// checksVersion identifies the checks the cached results were computed by, the checks of
+// the "latest" PodSecurity version change with the version of the PodSecurity library
+func checksVersion() string {
+	b := &strings.Builder{}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if dep.Path == "k8s.io/pod-security-admission" {
+				b.WriteString(dep.Version)
+			}
+		}
+	}
+	for _, check := range policy.DefaultChecks() {
+		fmt.Fprintf(b, ";%s", check.ID)
+		for _, v := range check.Versions {
+			fmt.Fprintf(b, ":%s", v.MinimumVersion)
+		}
+	}
+	return b.String()
+}
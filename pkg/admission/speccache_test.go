@@ -0,0 +1,225 @@
+package admission
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// specCacheBasePod has a container of each kind so that the container fields can be mutated in all of them
+func specCacheBasePod() *corev1.Pod {
+	return testPod("base", func(pod *corev1.Pod) {
+		pod.Spec.SecurityContext = &corev1.PodSecurityContext{}
+		pod.Spec.InitContainers = []corev1.Container{restrictedContainer("init")}
+		pod.Spec.EphemeralContainers = []corev1.EphemeralContainer{{EphemeralContainerCommon: corev1.EphemeralContainerCommon(restrictedContainer("debug"))}}
+	})
+}
+
+// podMutations change each of the fields of the pod read by the PodSecurity checks
+var podMutations = map[string]func(pod *corev1.Pod){
+	"hostNetwork": func(pod *corev1.Pod) { pod.Spec.HostNetwork = true },
+	"hostPID":     func(pod *corev1.Pod) { pod.Spec.HostPID = true },
+	"hostIPC":     func(pod *corev1.Pod) { pod.Spec.HostIPC = true },
+	"os":          func(pod *corev1.Pod) { pod.Spec.OS = &corev1.PodOS{Name: corev1.Windows} },
+	"apparmor annotation": func(pod *corev1.Pod) {
+		pod.Annotations = map[string]string{corev1.AppArmorBetaContainerAnnotationKeyPrefix + "app": corev1.AppArmorBetaProfileNameUnconfined}
+	},
+	"seccomp pod annotation": func(pod *corev1.Pod) {
+		pod.Annotations = map[string]string{corev1.SeccompPodAnnotationKey: corev1.SeccompProfileNameUnconfined}
+	},
+	"seccomp container annotation": func(pod *corev1.Pod) {
+		pod.Annotations = map[string]string{corev1.SeccompContainerAnnotationKeyPrefix + "app": corev1.SeccompProfileNameUnconfined}
+	},
+	"pod runAsNonRoot": func(pod *corev1.Pod) { pod.Spec.SecurityContext.RunAsNonRoot = boolPtr(false) },
+	"pod runAsUser":    func(pod *corev1.Pod) { pod.Spec.SecurityContext.RunAsUser = int64Ptr(0) },
+	"pod seLinuxOptions": func(pod *corev1.Pod) {
+		pod.Spec.SecurityContext.SELinuxOptions = &corev1.SELinuxOptions{Type: "spc_t"}
+	},
+	"pod seccompProfile": func(pod *corev1.Pod) {
+		pod.Spec.SecurityContext.SeccompProfile = &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeUnconfined}
+	},
+	"pod sysctls": func(pod *corev1.Pod) {
+		pod.Spec.SecurityContext.Sysctls = []corev1.Sysctl{{Name: "kernel.msgmax", Value: "1"}}
+	},
+	"pod hostProcess": func(pod *corev1.Pod) {
+		pod.Spec.SecurityContext.WindowsOptions = &corev1.WindowsSecurityContextOptions{HostProcess: boolPtr(true)}
+	},
+	"hostPath volume": func(pod *corev1.Pod) {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{Name: "host", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/"}}})
+	},
+	"nfs volume": func(pod *corev1.Pod) {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{Name: "nfs", VolumeSource: corev1.VolumeSource{NFS: &corev1.NFSVolumeSource{Server: "nfs", Path: "/"}}})
+	},
+	"projected volume named like the token volume": func(pod *corev1.Pod) {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{Name: "kube-api-access-abcde", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/"}}})
+	},
+}
+
+// containerMutations change each of the fields of a container read by the PodSecurity checks
+var containerMutations = map[string]func(c *corev1.Container){
+	"privileged":               func(c *corev1.Container) { c.SecurityContext.Privileged = boolPtr(true) },
+	"capabilities add":         func(c *corev1.Container) { c.SecurityContext.Capabilities.Add = []corev1.Capability{"NET_ADMIN"} },
+	"capabilities drop":        func(c *corev1.Container) { c.SecurityContext.Capabilities.Drop = nil },
+	"allowPrivilegeEscalation": func(c *corev1.Container) { c.SecurityContext.AllowPrivilegeEscalation = boolPtr(true) },
+	"runAsNonRoot":             func(c *corev1.Container) { c.SecurityContext.RunAsNonRoot = boolPtr(false) },
+	"runAsUser":                func(c *corev1.Container) { c.SecurityContext.RunAsUser = int64Ptr(0) },
+	"procMount": func(c *corev1.Container) {
+		procMount := corev1.UnmaskedProcMount
+		c.SecurityContext.ProcMount = &procMount
+	},
+	"seccompProfile": func(c *corev1.Container) {
+		c.SecurityContext.SeccompProfile = &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeUnconfined}
+	},
+	"seLinuxOptions": func(c *corev1.Container) { c.SecurityContext.SELinuxOptions = &corev1.SELinuxOptions{User: "root"} },
+	"hostProcess": func(c *corev1.Container) {
+		c.SecurityContext.WindowsOptions = &corev1.WindowsSecurityContextOptions{HostProcess: boolPtr(true)}
+	},
+	"securityContext": func(c *corev1.Container) { c.SecurityContext = nil },
+	"hostPort":        func(c *corev1.Container) { c.Ports = []corev1.ContainerPort{{ContainerPort: 80, HostPort: 80}} },
+	// the AppArmor annotations refer to the containers by their names
+	"name": func(c *corev1.Container) { c.Name = "renamed" },
+}
+
+// checkedFieldMutations returns all the pod mutations along with the container mutations
+// applied to each kind of container
+func checkedFieldMutations() map[string]func(pod *corev1.Pod) {
+	mutations := map[string]func(pod *corev1.Pod){}
+	for name, mutate := range podMutations {
+		mutations[name] = mutate
+	}
+	for name, mutate := range containerMutations {
+		mutate := mutate
+		mutations["containers "+name] = func(pod *corev1.Pod) { mutate(&pod.Spec.Containers[0]) }
+		mutations["initContainers "+name] = func(pod *corev1.Pod) { mutate(&pod.Spec.InitContainers[0]) }
+		mutations["ephemeralContainers "+name] = func(pod *corev1.Pod) {
+			mutate((*corev1.Container)(&pod.Spec.EphemeralContainers[0].EphemeralContainerCommon))
+		}
+	}
+	return mutations
+}
+
+func TestSpecCacheMissesOnCheckedFields(t *testing.T) {
+	for name, mutate := range checkedFieldMutations() {
+		t.Run(name, func(t *testing.T) {
+			cache := NewSpecCache()
+			evaluations := 0
+			evaluate := func() []FailedCheck {
+				evaluations++
+				return nil
+			}
+
+			base := specCacheBasePod()
+			cache.failedChecks(&base.ObjectMeta, &base.Spec, evaluate)
+
+			mutated := specCacheBasePod()
+			mutate(mutated)
+			cache.failedChecks(&mutated.ObjectMeta, &mutated.Spec, evaluate)
+
+			if evaluations != 2 {
+				t.Errorf("expected a cache miss for the mutated pod")
+			}
+		})
+	}
+}
+
+func TestSpecCacheHitsOnIgnoredFields(t *testing.T) {
+	ignored := map[string]func(pod *corev1.Pod){
+		"name":        func(pod *corev1.Pod) { pod.Name = "other" },
+		"labels":      func(pod *corev1.Pod) { pod.Labels = map[string]string{"pod-template-hash": "abc"} },
+		"annotations": func(pod *corev1.Pod) { pod.Annotations = map[string]string{"kubectl.kubernetes.io/restartedAt": "now"} },
+		"nodeName":    func(pod *corev1.Pod) { pod.Spec.NodeName = "node-1" },
+		"image":       func(pod *corev1.Pod) { pod.Spec.Containers[0].Image = "registry.example.com/app:v2" },
+		"env": func(pod *corev1.Pod) {
+			pod.Spec.Containers[0].Env = []corev1.EnvVar{{Name: "FOO", Value: "bar"}}
+		},
+		"token volume": func(pod *corev1.Pod) {
+			pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{Name: "kube-api-access-x7k2p", VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{}}})
+		},
+	}
+
+	for name, mutate := range ignored {
+		t.Run(name, func(t *testing.T) {
+			base := specCacheBasePod()
+			mutated := specCacheBasePod()
+			mutate(mutated)
+
+			baseHash, err := specHash(&base.ObjectMeta, &base.Spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mutatedHash, err := specHash(&mutated.ObjectMeta, &mutated.Spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if baseHash != mutatedHash {
+				t.Errorf("expected the pods to share the spec hash")
+			}
+		})
+	}
+}
+
+// TestSpecCacheMatchesEvaluation makes sure that the cached results are the results of the
+// evaluation of each of the pods, no matter which of the pods sharing a key was evaluated first
+func TestSpecCacheMatchesEvaluation(t *testing.T) {
+	pods := testPods()
+	for name, mutate := range checkedFieldMutations() {
+		pod := specCacheBasePod()
+		mutate(pod)
+		pod.Name = name
+		pods = append(pods, pod)
+	}
+
+	uncached, err := NewParallelAdmissionWithPodLister(staticPodLister(nil), NewEvaluationCounter())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached, err := NewParallelAdmissionWithPodLister(staticPodLister(nil), NewEvaluationCounter())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached.WithSpecCache(NewSpecCache())
+
+	// evaluate each pod twice so that the second round is served from the cache
+	for round := 0; round < 2; round++ {
+		for _, pod := range pods {
+			expected := uncached.FailedChecks(&pod.ObjectMeta, &pod.Spec)
+			got := cached.FailedChecks(&pod.ObjectMeta, &pod.Spec)
+			if (len(expected) > 0 || len(got) > 0) && !reflect.DeepEqual(expected, got) {
+				t.Errorf("round %d, pod %s: expected failed checks %v, got %v", round, pod.Name, expected, got)
+			}
+		}
+	}
+}
+
+// BenchmarkSpecCache evaluates the replicas of a few pod templates with and without the spec cache
+func BenchmarkSpecCache(b *testing.B) {
+	templates := testPods()
+	var pods []*corev1.Pod
+	for i := 0; i < 5000; i++ {
+		pod := templates[i%len(templates)].DeepCopy()
+		pod.ObjectMeta = metav1.ObjectMeta{Namespace: pod.Namespace, Name: fmt.Sprintf("%s-%d", pod.Name, i), Annotations: pod.Annotations}
+		pod.Spec.NodeName = fmt.Sprintf("node-%d", i%10)
+		pods = append(pods, pod)
+	}
+
+	for _, useCache := range []bool{false, true} {
+		b.Run(fmt.Sprintf("cache=%v", useCache), func(b *testing.B) {
+			adm, err := NewParallelAdmissionWithPodLister(staticPodLister(nil), NewEvaluationCounter())
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if useCache {
+					adm.WithSpecCache(NewSpecCache())
+				}
+				for _, pod := range pods {
+					adm.FailedChecks(&pod.ObjectMeta, &pod.Spec)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package admission
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	psapi "k8s.io/pod-security-admission/api"
+	"k8s.io/pod-security-admission/policy"
+)
+
+var (
+	// the formats of the warnings the PodSecurity admission returns when the pods of
+	// a namespace violate its new enforce level
+	truncatedPodsWarningRegexp = regexp.MustCompile(`^new PodSecurity enforce level only checked against the first (\d+) of (\d+) existing pods$`)
+	violatingPodsWarningRegexp = regexp.MustCompile(`^existing pods in namespace ".*" violate the new PodSecurity enforce level ".*"$`)
+	podWarningRegexp           = regexp.MustCompile(`^([^ :]+)(?: \(and (\d+) other pods?\))?: (.+)$`)
+)
+
+// forbiddenReasonChecks maps the forbidden reasons of the checks to their IDs. Some reasons
+// are shared by the baseline and the restricted version of a check.
+var forbiddenReasonChecks = map[string][]policy.CheckID{
+	"allowPrivilegeEscalation != false": {"allowPrivilegeEscalation"},
+	"forbidden AppArmor profile":        {"appArmorProfile"},
+	"forbidden AppArmor profiles":       {"appArmorProfile"},
+	"non-default capabilities":          {"capabilities_baseline"},
+	"unrestricted capabilities":         {"capabilities_restricted"},
+	"host namespaces":                   {"hostNamespaces"},
+	"hostPath volumes":                  {"hostPathVolumes"},
+	"hostPort":                          {"hostPorts"},
+	"privileged":                        {"privileged"},
+	"procMount":                         {"procMount"},
+	"restricted volume types":           {"restrictedVolumes"},
+	"runAsNonRoot != true":              {"runAsNonRoot"},
+	"runAsUser=0":                       {"runAsUser"},
+	"seLinuxOptions":                    {"seLinuxOptions"},
+	"seccompProfile":                    {"seccompProfile_baseline", "seccompProfile_restricted"},
+	"forbidden sysctls":                 {"sysctls"},
+	"hostProcess":                       {"windowsHostProcess"},
+}
+
+// parseNamespaceWarnings turns the warnings of a namespace enforce label update into
+// violations. It also returns the number of pods the admission checked or -1 if it
+// checked all of them. Warnings of an unknown format are returned as violations
+// with no pods and checks.
+func (e *checkEvaluator) parseNamespaceWarnings(lv psapi.LevelVersion, warnings []string) ([]Violation, int) {
+	var violations []Violation
+	checkedPods := -1
+
+	for _, warning := range warnings {
+		if match := truncatedPodsWarningRegexp.FindStringSubmatch(warning); match != nil {
+			checkedPods, _ = strconv.Atoi(match[1])
+			continue
+		}
+		if violatingPodsWarningRegexp.MatchString(warning) {
+			continue
+		}
+
+		match := podWarningRegexp.FindStringSubmatch(warning)
+		if match == nil {
+			violations = append(violations, Violation{Reasons: []string{warning}})
+			continue
+		}
+
+		violation := Violation{Pod: match[1], PodCount: 1}
+		if len(match[2]) > 0 {
+			otherPods, _ := strconv.Atoi(match[2])
+			violation.PodCount += otherPods
+		}
+		violation.Reasons = strings.Split(match[3], ", ")
+		for _, reason := range violation.Reasons {
+			violation.Checks = append(violation.Checks, e.checksForReason(reason, lv)...)
+		}
+		violations = append(violations, violation)
+	}
+
+	return violations, checkedPods
+}
+
+// checksForReason returns the IDs of the checks of the level that fail with the reason
+func (e *checkEvaluator) checksForReason(reason string, lv psapi.LevelVersion) []policy.CheckID {
+	candidates := forbiddenReasonChecks[reason]
+	if len(candidates) < 2 {
+		return candidates
+	}
+
+	var ids []policy.CheckID
+	for _, check := range e.checks {
+		for _, id := range candidates {
+			if check.ID == id && e.appliesToLevel(FailedCheck{ID: check.ID, Level: check.Level}, lv) {
+				ids = append(ids, id)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return candidates
+	}
+	return ids
+}
@@ -0,0 +1,48 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const GroupName = "psachecker.io"
+
+var (
+	SchemeGroupVersion = schema.GroupVersion{Group: GroupName, Version: "v1alpha1"}
+
+	PodSecurityRecommendationsResource = SchemeGroupVersion.WithResource("podsecurityrecommendations")
+)
+
+const PodSecurityRecommendationKind = "PodSecurityRecommendation"
+
+// PodSecurityRecommendation holds the PodSecurity labels that the namespace it lives in
+// should use in order to keep its current workloads running.
+type PodSecurityRecommendation struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status PodSecurityRecommendationStatus `json:"status,omitempty"`
+}
+
+type PodSecurityRecommendationStatus struct {
+	// Enforce is the recommended value of the pod-security.kubernetes.io/enforce label
+	Enforce string `json:"enforce,omitempty"`
+	// Audit is the recommended value of the pod-security.kubernetes.io/audit label
+	Audit string `json:"audit,omitempty"`
+	// Warn is the recommended value of the pod-security.kubernetes.io/warn label
+	Warn string `json:"warn,omitempty"`
+
+	// Workloads lists the workloads of the namespace along with the least privileged
+	// level each of them is able to run with
+	Workloads []WorkloadRecommendation `json:"workloads,omitempty"`
+
+	LastEvaluationTime metav1.Time `json:"lastEvaluationTime,omitempty"`
+}
+
+type WorkloadRecommendation struct {
+	Kind         string `json:"kind"`
+	Name         string `json:"name"`
+	MinimalLevel string `json:"minimalLevel"`
+	// FailedChecks are the IDs of the PodSecurity checks the workload fails
+	FailedChecks []string `json:"failedChecks,omitempty"`
+}
@@ -0,0 +1,73 @@
+package clusterinspect
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+
+	"github.com/stlaz/psachecker/pkg/collect"
+)
+
+// NewAnalyzeCommand runs the cluster inspection against an archive created by the collect command
+func NewAnalyzeCommand(clientConfigOptions *genericclioptions.ConfigFlags) *cobra.Command {
+	o := NewClusterInspectOptions()
+
+	cmd := &cobra.Command{
+		Use:          "analyze <archive.tar.gz> [flags]",
+		Short:        "inspect a cluster offline from an archive created by the collect command",
+		Long:         "Inspect a cluster offline from an archive created by the collect command. Accepts the same flags as inspect-cluster.",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			metadata, err := collect.ReadMetadata(args[0])
+			if err != nil {
+				return err
+			}
+			printMetadata(c.ErrOrStderr(), metadata)
+
+			o.fromDump = args[0]
+			if err := o.Complete(c, clientConfigOptions); err != nil {
+				return err
+			}
+			errs := o.Validate()
+			if len(errs) > 0 {
+				return fmt.Errorf("there were errors while setting up the command: %v", errs)
+			}
+
+			return o.print(c.OutOrStdout(), c.ErrOrStderr())
+		},
+	}
+
+	o.AddFlags(cmd)
+	// the archive is the dump
+	cmd.Flags().MarkHidden("from-dump")
+	// the defaults are read from the archive
+	cmd.Flags().MarkHidden("discover-defaults")
+	return cmd
+}
+
+func printMetadata(out io.Writer, metadata *collect.Metadata) {
+	fmt.Fprintf(out, "collected at: %s\n", metadata.CollectedAt.Format("2006-01-02 15:04:05 MST"))
+	if metadata.ServerVersion != nil {
+		fmt.Fprintf(out, "server version: %s\n", metadata.ServerVersion.GitVersion)
+	}
+	if d := metadata.PodSecurityDefaults; d != nil && len(d.Error) == 0 {
+		fmt.Fprintf(out, "default PodSecurity levels: enforce=%s warn=%s\n", levelVersion(d.Enforce, d.EnforceVersion), levelVersion(d.Warn, d.WarnVersion))
+	}
+	for _, e := range metadata.Errors {
+		fmt.Fprintf(out, "warning: the archive is incomplete: %s\n", e)
+	}
+	fmt.Fprintln(out)
+}
+
+func levelVersion(level, version string) string {
+	if len(level) == 0 {
+		return "unknown"
+	}
+	if len(version) == 0 {
+		return level
+	}
+	return level + ":" + version
+}
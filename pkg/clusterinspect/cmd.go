@@ -2,33 +2,170 @@ package clusterinspect
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"sort"
+	"strings"
 
 	"github.com/spf13/cobra"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
+	psapi "k8s.io/pod-security-admission/api"
+
+	"github.com/stlaz/psachecker/pkg/admission"
+	"github.com/stlaz/psachecker/pkg/snapshot"
 )
 
 func NewClusterInspectCommand(clientConfigOptions *genericclioptions.ConfigFlags) *cobra.Command {
-	o := newClusterInspectOptions()
+	o := NewClusterInspectOptions()
 
 	cmd := &cobra.Command{
 		Use:          "inspect-cluster [flags]",
 		Short:        "get the least privileged PodSecurity level for your workload/namespace to keep current workloads running successfully",
 		SilenceUsage: true,
 		RunE: func(c *cobra.Command, args []string) error {
-			o.Complete(c, clientConfigOptions)
-
-			nsAggregatedResults, err := o.Run(context.Background())
-			if err != nil {
+			if err := o.Complete(c, clientConfigOptions); err != nil {
 				return err
 			}
-
-			for _, ns := range nsAggregatedResults.Keys() {
-				fmt.Fprintf(c.OutOrStdout(), "%s: %s\n", ns, nsAggregatedResults.Get(ns))
+			errs := o.Validate()
+			if len(errs) > 0 {
+				return fmt.Errorf("there were errors while setting up the command: %v", errs)
 			}
-			return nil
+
+			return o.print(c.OutOrStdout(), c.ErrOrStderr())
 		},
 	}
 
+	o.AddFlags(cmd)
 	return cmd
 }
+
+// print runs the inspection and prints its results in the selected output format
+func (o *ClusterInspectOptions) print(out, errOut io.Writer) error {
+	if o.evaluationSummary {
+		defer func() {
+			counts := o.EvaluationCounts()
+			fmt.Fprintf(errOut, "\n%s", counts.String())
+		}()
+	}
+
+	if o.output == "json" {
+		return o.printJSON(context.Background(), out)
+	}
+
+	var (
+		nsAggregatedResults admission.NamespaceEvaluations
+		err                 error
+	)
+	if o.multiContext() {
+		nsAggregatedResults, err = o.RunContexts(context.Background())
+	} else {
+		nsAggregatedResults, err = o.Run(context.Background())
+	}
+	levels := admission.NewOrderedStringToPSALevelMap(nsAggregatedResults.Levels())
+	// the labels of the synced namespaces are not supposed to be set manually
+	var synced []string
+	for _, ns := range levels.Keys() {
+		if nsAggregatedResults[ns].LabelsSynced {
+			synced = append(synced, ns)
+			continue
+		}
+		o.printNamespace(out, ns, levels.Get(ns), nsAggregatedResults[ns])
+	}
+	if len(synced) > 0 {
+		fmt.Fprintf(out, "\nnamespaces with PodSecurity labels managed by the OpenShift label syncer:\n")
+		for _, ns := range synced {
+			o.printNamespace(out, ns, levels.Get(ns), nsAggregatedResults[ns])
+		}
+	}
+	printSCCLevels(out, nsAggregatedResults.SCCLevels())
+	return err
+}
+
+func (o *ClusterInspectOptions) printNamespace(out io.Writer, ns string, level psapi.Level, evaluation *admission.NamespaceEvaluation) {
+	fmt.Fprintf(out, "%s: %s\n", ns, level)
+	if o.explain {
+		printViolations(out, evaluation)
+	}
+}
+
+// printSCCLevels prints the OpenShift SecurityContextConstraints along with the numbers of the
+// pods they admitted by the most restrictive levels the pods are able to run with
+func printSCCLevels(out io.Writer, sccLevels map[string]map[psapi.Level]int) {
+	if len(sccLevels) == 0 {
+		return
+	}
+
+	sccs := make([]string, 0, len(sccLevels))
+	for scc := range sccLevels {
+		sccs = append(sccs, scc)
+	}
+	sort.Strings(sccs)
+
+	fmt.Fprintf(out, "\nPodSecurity levels of the pods by their SecurityContextConstraints:\n")
+	for _, scc := range sccs {
+		var counts []string
+		for _, level := range []psapi.Level{psapi.LevelPrivileged, psapi.LevelBaseline, psapi.LevelRestricted} {
+			switch count := sccLevels[scc][level]; {
+			case count == 1:
+				counts = append(counts, fmt.Sprintf("%s (1 pod)", level))
+			case count > 1:
+				counts = append(counts, fmt.Sprintf("%s (%d pods)", level, count))
+			}
+		}
+		fmt.Fprintf(out, "%s: %s\n", scc, strings.Join(counts, ", "))
+	}
+}
+
+// printViolations prints the checks that prevent the namespace from using the levels
+// more restrictive than its recommended one
+func printViolations(out io.Writer, evaluation *admission.NamespaceEvaluation) {
+	for _, level := range []psapi.Level{psapi.LevelBaseline, psapi.LevelRestricted} {
+		for _, violation := range evaluation.Violations[level] {
+			fmt.Fprintf(out, "  %s: %s\n", level, violation)
+		}
+	}
+}
+
+func (o *ClusterInspectOptions) printJSON(ctx context.Context, out io.Writer) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+
+	// multiple clusters are keyed by their context names
+	if o.multiContext() {
+		snapshots, err := o.SnapshotContexts(ctx)
+		for _, snap := range snapshots {
+			o.filterUpdatesOnly(snap)
+		}
+		if encodeErr := encoder.Encode(snapshots); encodeErr != nil {
+			return encodeErr
+		}
+		return err
+	}
+
+	snap, err := o.Snapshot(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := o.SaveSnapshot(snap); err != nil {
+		return err
+	}
+
+	o.filterUpdatesOnly(snap)
+	return encoder.Encode(snap)
+}
+
+func (o *ClusterInspectOptions) filterUpdatesOnly(snap *snapshot.Snapshot) {
+	if !o.updatesOnly {
+		return
+	}
+
+	needsUpdate := snap.Namespaces[:0]
+	for _, ns := range snap.Namespaces {
+		if string(ns.RecommendedLevel) != o.defaults.Apply(ns.Labels)[psapi.EnforceLevelLabel] {
+			needsUpdate = append(needsUpdate, ns)
+		}
+	}
+	snap.Namespaces = needsUpdate
+}
@@ -0,0 +1,201 @@
+package clusterinspect
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/stlaz/psachecker/pkg/admission"
+	"github.com/stlaz/psachecker/pkg/snapshot"
+)
+
+func (o *ClusterInspectOptions) multiContext() bool {
+	return o.allContexts || len(o.contexts) > 0
+}
+
+// completeContexts creates a kube client for each of the selected contexts of the kubeconfig
+func (o *ClusterInspectOptions) completeContexts() error {
+	rawConfig, err := o.clientConfigOptions.ToRawKubeConfigLoader().RawConfig()
+	if err != nil {
+		return fmt.Errorf("failed to read the kubeconfig: %w", err)
+	}
+
+	contextNames := o.contexts
+	if o.allContexts {
+		contextNames = make([]string, 0, len(rawConfig.Contexts))
+		for name := range rawConfig.Contexts {
+			contextNames = append(contextNames, name)
+		}
+	}
+
+	overrides := contextOverrides(o.clientConfigOptions)
+	o.contextClients = make(map[string]kubernetes.Interface, len(contextNames))
+	o.contextConfigs = make(map[string]*rest.Config, len(contextNames))
+	for _, name := range contextNames {
+		if _, ok := rawConfig.Contexts[name]; !ok {
+			return fmt.Errorf("context %q not found in the kubeconfig", name)
+		}
+
+		clientConfig, err := clientcmd.NewNonInteractiveClientConfig(rawConfig, name, overrides, nil).ClientConfig()
+		if err != nil {
+			return fmt.Errorf("failed to read kube client configuration of context %q: %w", name, err)
+		}
+		o.configureClient(clientConfig)
+		o.contextConfigs[name] = clientConfig
+
+		o.contextClients[name], err = kubernetes.NewForConfig(clientConfig)
+		if err != nil {
+			return fmt.Errorf("failed to create kube client for context %q: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// contextOverrides returns the overrides of the kubeconfig set by the global flags except for
+// those selecting the context and its cluster, those are applied to each of the contexts
+func contextOverrides(flags *genericclioptions.ConfigFlags) *clientcmd.ConfigOverrides {
+	overrides := &clientcmd.ConfigOverrides{ClusterDefaults: clientcmd.ClusterDefaults}
+
+	overrides.AuthInfo.ClientCertificate = stringValue(flags.CertFile)
+	overrides.AuthInfo.ClientKey = stringValue(flags.KeyFile)
+	overrides.AuthInfo.Token = stringValue(flags.BearerToken)
+	overrides.AuthInfo.Impersonate = stringValue(flags.Impersonate)
+	overrides.AuthInfo.ImpersonateUID = stringValue(flags.ImpersonateUID)
+	if flags.ImpersonateGroup != nil {
+		overrides.AuthInfo.ImpersonateGroups = *flags.ImpersonateGroup
+	}
+	overrides.AuthInfo.Username = stringValue(flags.Username)
+	overrides.AuthInfo.Password = stringValue(flags.Password)
+
+	overrides.ClusterInfo.Server = stringValue(flags.APIServer)
+	overrides.ClusterInfo.TLSServerName = stringValue(flags.TLSServerName)
+	overrides.ClusterInfo.CertificateAuthority = stringValue(flags.CAFile)
+	if flags.Insecure != nil {
+		overrides.ClusterInfo.InsecureSkipTLSVerify = *flags.Insecure
+	}
+	if flags.DisableCompression != nil {
+		overrides.ClusterInfo.DisableCompression = *flags.DisableCompression
+	}
+
+	overrides.Context.AuthInfo = stringValue(flags.AuthInfoName)
+	overrides.Context.Namespace = stringValue(flags.Namespace)
+	overrides.Timeout = stringValue(flags.Timeout)
+
+	return overrides
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// forContext returns a copy of the options that inspects the cluster of the given context
+func (o *ClusterInspectOptions) forContext(contextName string) *ClusterInspectOptions {
+	contextOpts := *o
+	contextOpts.kubeClient = o.contextClients[contextName]
+	contextOpts.clientConfig = o.contextConfigs[contextName]
+	contextOpts.contextClients = nil
+	contextOpts.contextConfigs = nil
+	contextOpts.contexts = nil
+	contextOpts.allContexts = false
+	contextOpts.progressPrefix = contextName + ": "
+	if len(o.historyDir) > 0 {
+		contextOpts.historyDir = filepath.Join(o.historyDir, contextDirName(contextName))
+	}
+	return &contextOpts
+}
+
+// contextDirName escapes the context name so that it can be used as the name of a directory,
+// the context names often contain slashes and colons, e.g. "arn:aws:eks:eu-west-1:1234:cluster/prod"
+func contextDirName(contextName string) string {
+	switch contextName {
+	case ".", "..":
+		return strings.ReplaceAll(contextName, ".", "%2E")
+	}
+	return url.QueryEscape(contextName)
+}
+
+// forEachContext concurrently calls f for each of the selected contexts and aggregates the errors
+func (o *ClusterInspectOptions) forEachContext(f func(contextName string, contextOpts *ClusterInspectOptions) error) error {
+	var (
+		wg   sync.WaitGroup
+		lock sync.Mutex
+		errs []error
+	)
+
+	for name := range o.contextClients {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			if err := f(name, o.forContext(name)); err != nil {
+				lock.Lock()
+				errs = append(errs, fmt.Errorf("context %q: %w", name, err))
+				lock.Unlock()
+			}
+		}(name)
+	}
+	wg.Wait()
+
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return utilerrors.NewAggregate(errs)
+}
+
+// RunContexts runs the inspection in the clusters of all the selected contexts. The keys
+// of the returned map are in the "<context>/<namespace>" format. Results of the clusters
+// that were inspected successfully are returned even if inspecting other clusters failed.
+func (o *ClusterInspectOptions) RunContexts(ctx context.Context) (admission.NamespaceEvaluations, error) {
+	var lock sync.Mutex
+	merged := admission.NamespaceEvaluations{}
+
+	err := o.forEachContext(func(contextName string, contextOpts *ClusterInspectOptions) error {
+		results, err := contextOpts.Run(ctx)
+		if err != nil {
+			return err
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		for ns, evaluation := range results {
+			merged[contextName+"/"+ns] = evaluation
+		}
+		return nil
+	})
+
+	return merged, err
+}
+
+// SnapshotContexts returns the detailed results for each of the selected contexts
+func (o *ClusterInspectOptions) SnapshotContexts(ctx context.Context) (map[string]*snapshot.Snapshot, error) {
+	var lock sync.Mutex
+	snapshots := map[string]*snapshot.Snapshot{}
+
+	err := o.forEachContext(func(contextName string, contextOpts *ClusterInspectOptions) error {
+		snap, err := contextOpts.Snapshot(ctx)
+		if err != nil {
+			return err
+		}
+		if err := contextOpts.SaveSnapshot(snap); err != nil {
+			return err
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		snapshots[contextName] = snap
+		return nil
+	})
+
+	return snapshots, err
+}
@@ -0,0 +1,108 @@
+package clusterinspect
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	psapi "k8s.io/pod-security-admission/api"
+
+	"github.com/stlaz/psachecker/pkg/psaconfig"
+)
+
+const (
+	eventSourceComponent = "psachecker"
+
+	reasonEnforceTooStrict   = "PodSecurityEnforceTooStrict"
+	reasonEnforceTightenable = "PodSecurityEnforceCanBeTightened"
+)
+
+// emitNamespaceEvents records a Warning event for each namespace whose enforce label does
+// not match the level its workloads require. The namespaces without the label are compared
+// by the default level, they are skipped if the defaults are not known.
+// The events are created in the namespaces they are about rather than in the "default"
+// namespace so that they are visible to the namespace owners.
+func emitNamespaceEvents(ctx context.Context, kubeClient kubernetes.Interface, namespaces []corev1.Namespace, recommendedLevels map[string]psapi.Level, defaults *psaconfig.Defaults) error {
+	for i := range namespaces {
+		ns := &namespaces[i]
+
+		recommended, ok := recommendedLevels[ns.Name]
+		if !ok || !recommended.Valid() {
+			continue
+		}
+
+		label, ok := defaults.Apply(ns.Labels)[psapi.EnforceLevelLabel]
+		if !ok {
+			continue
+		}
+		current := psapi.Level(label)
+		if !current.Valid() {
+			continue
+		}
+
+		var reason, message string
+		switch psapi.CompareLevels(current, recommended) {
+		case 1:
+			reason = reasonEnforceTooStrict
+			message = fmt.Sprintf("The namespace enforces the %q PodSecurity level but some of its workloads require %q.", current, recommended)
+		case -1:
+			reason = reasonEnforceTightenable
+			message = fmt.Sprintf("The namespace enforces the %q PodSecurity level but all of its workloads would run at %q.", current, recommended)
+		default:
+			continue
+		}
+
+		if err := recordNamespaceEvent(ctx, kubeClient, ns, reason, message); err != nil {
+			return fmt.Errorf("failed to record event for namespace %q: %w", ns.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// recordNamespaceEvent creates the event of the namespace with the given reason or, if the previous
+// runs already created it, bumps its count the way the client-go event recorder does for the
+// repeated events
+func recordNamespaceEvent(ctx context.Context, kubeClient kubernetes.Interface, ns *corev1.Namespace, reason, message string) error {
+	events := kubeClient.CoreV1().Events(ns.Name)
+	name := ns.Name + "." + eventSourceComponent + "." + strings.ToLower(reason)
+	now := metav1.NewTime(time.Now())
+
+	event, err := events.Get(ctx, name, metav1.GetOptions{})
+	if err == nil {
+		event.Message = message
+		event.LastTimestamp = now
+		event.Count++
+		_, err = events.Update(ctx, event, metav1.UpdateOptions{})
+		return err
+	}
+	if !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	_, err = events.Create(ctx, &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ns.Name,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "Namespace",
+			Name:       ns.Name,
+			UID:        ns.UID,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           corev1.EventTypeWarning,
+		Source:         corev1.EventSource{Component: eventSourceComponent},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}, metav1.CreateOptions{})
+	return err
+}
@@ -0,0 +1,39 @@
+package clusterinspect
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	psapi "k8s.io/pod-security-admission/api"
+)
+
+func TestEmitNamespaceEvents(t *testing.T) {
+	namespaces := []corev1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "strict", Labels: map[string]string{psapi.EnforceLevelLabel: string(psapi.LevelRestricted)}}},
+		// the default of the cluster is not known
+		{ObjectMeta: metav1.ObjectMeta{Name: "unlabeled"}},
+	}
+	recommended := map[string]psapi.Level{"strict": psapi.LevelBaseline, "unlabeled": psapi.LevelRestricted}
+	client := fake.NewSimpleClientset()
+
+	// repeated runs update the same event
+	for i := 0; i < 2; i++ {
+		if err := emitNamespaceEvents(context.Background(), client, namespaces, recommended, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events, err := client.CoreV1().Events("").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events.Items) != 1 {
+		t.Fatalf("expected a single event, got %v", events.Items)
+	}
+	if event := events.Items[0]; event.Namespace != "strict" || event.Reason != reasonEnforceTooStrict || event.Count != 2 {
+		t.Errorf("expected the %s event of the strict namespace recorded twice, got %s/%s %s with count %d", reasonEnforceTooStrict, event.Namespace, event.Name, event.Reason, event.Count)
+	}
+}
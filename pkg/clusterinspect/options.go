@@ -3,39 +3,167 @@ package clusterinspect
 import (
 	"context"
 	"fmt"
+	"io"
+	"sync"
+	"time"
 
 	"github.com/spf13/cobra"
 
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/fields"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
 	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+	psadmission "k8s.io/pod-security-admission/admission"
 	psapi "k8s.io/pod-security-admission/api"
 
 	"github.com/stlaz/psachecker/pkg/admission"
+	"github.com/stlaz/psachecker/pkg/collect"
+	"github.com/stlaz/psachecker/pkg/dump"
+	"github.com/stlaz/psachecker/pkg/history"
+	"github.com/stlaz/psachecker/pkg/psaconfig"
+	"github.com/stlaz/psachecker/pkg/snapshot"
+)
+
+const (
+	// evaluationModeComplete evaluates every pod of the namespaces
+	evaluationModeComplete = "complete"
+	// evaluationModeAdmission evaluates the namespaces the same way the PodSecurity admission
+	// does on their enforce label update, i.e. only within its pod count and time limits
+	evaluationModeAdmission = "admission"
 )
 
 type ClusterInspectOptions struct {
 	clientConfigOptions *genericclioptions.ConfigFlags
 
-	updatesOnly bool
+	updatesOnly       bool
+	evaluationSummary bool
+	emitEvents        bool
+	historyDir        string
+	output            string
+	fromDump          string
+	discoverDefaults  bool
+	evaluationMode    string
+	explain           bool
+
+	allContexts bool
+	contexts    []string
+
+	chunkSize      int64
+	workers        int
+	cachePods      bool
+	useSpecCache   bool
+	specCacheFile  string
+	qps            float32
+	burst          int
+	showProgress   bool
+	progressPrefix string
+	errOut         io.Writer
 
 	kubeClient kubernetes.Interface
+	// clientConfig is the configuration of kubeClient, nil when reading a dump
+	clientConfig *rest.Config
+	// contextClients and contextConfigs map the names of the kubeconfig contexts to their
+	// clients and client configurations when inspecting multiple clusters
+	contextClients map[string]kubernetes.Interface
+	contextConfigs map[string]*rest.Config
+
+	// defaults are the default PodSecurity levels of the cluster, nil if they are not known
+	defaults *psaconfig.Defaults
+
+	// specCache is shared by all the runs and contexts, it is nil if disabled
+	specCache *admission.SpecCache
+
+	// evaluationCounter accumulates the admission evaluations across all runs
+	evaluationCounter *admission.EvaluationCounter
 }
 
-func newClusterInspectOptions() *ClusterInspectOptions {
-	return &ClusterInspectOptions{}
+func NewClusterInspectOptions() *ClusterInspectOptions {
+	return &ClusterInspectOptions{
+		evaluationCounter: admission.NewEvaluationCounter(),
+		evaluationMode:    evaluationModeComplete,
+
+		chunkSize:    500,
+		workers:      4,
+		cachePods:    true,
+		useSpecCache: true,
+		qps:          20,
+		burst:        40,
+	}
+}
+
+func (o *ClusterInspectOptions) AddFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+
+	flags.BoolVar(&o.emitEvents, "emit-events", false, "Create a Warning event in each namespace whose enforce label is stricter than its workloads allow or that could be safely tightened.")
+	history.AddHistoryDirFlag(cmd, &o.historyDir)
+	o.AddInputFlags(cmd)
+	flags.StringVarP(&o.output, "output", "o", "", "Output format. One of: (json). The json output contains the detailed per-workload results and can be compared by the diff command.")
+	flags.BoolVar(&o.allContexts, "all-contexts", false, "Inspect the clusters of all the contexts in the kubeconfig.")
+	flags.StringSliceVar(&o.contexts, "contexts", nil, "Comma-separated list of kubeconfig contexts whose clusters should be inspected.")
+	flags.StringVar(&o.evaluationMode, "evaluation-mode", o.evaluationMode, "How the namespaces get evaluated. One of: (complete, admission). \"complete\" checks every pod and reports the namespaces where the PodSecurity admission would only check some of them, the time limit is estimated. \"admission\" evaluates the namespaces exactly as the admission does when their enforce label gets updated, including its pod count and time limits, and reports the namespaces whose pods were not all checked. The admission reports a single pod per distinct violation, --explain shows the number of the other pods sharing it.")
+	flags.BoolVar(&o.explain, "explain", false, "List the pods and the checks that prevent each namespace from using a more restrictive level.")
+	flags.BoolVar(&o.evaluationSummary, "evaluation-summary", false, "Print the counts of the performed PodSecurity evaluations and errors to stderr.")
+	o.AddScanFlags(cmd)
+}
+
+// AddInputFlags adds the flags that select where the inspected objects are read from
+func (o *ClusterInspectOptions) AddInputFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&o.fromDump, "from-dump", "", "Inspect the objects of a dump instead of a live cluster. The dump is a YAML or JSON file produced by e.g. \"kubectl get ns,pods,deploy -A -o yaml\", a directory of such files or a .tar/.tar.gz/.tgz archive of them. The default PodSecurity levels stored in an archive created by the collect command apply to the namespaces without PodSecurity labels.")
+	cmd.Flags().BoolVar(&o.discoverDefaults, "discover-defaults", false, "Discover the default PodSecurity levels of the cluster by creating dry-run probe pods in a namespace without PodSecurity labels, which requires the permission to create pods in that namespace, and apply them to the namespaces without the labels. Otherwise, such namespaces are considered privileged.")
+}
+
+// AddScanFlags adds the flags that tune how the cluster gets scanned
+func (o *ClusterInspectOptions) AddScanFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+
+	flags.Int64Var(&o.chunkSize, "chunk-size", o.chunkSize, "Return large lists in chunks rather than all at once. Pass 0 to disable.")
+	flags.IntVar(&o.workers, "workers", o.workers, "Number of namespaces to evaluate in parallel.")
+	flags.BoolVar(&o.cachePods, "cache-pods", o.cachePods, "List the pods of all the inspected namespaces at once and keep them in memory for the duration of the scan. Disable to list the pods namespace by namespace instead, which uses less memory but more API calls.")
+	flags.BoolVar(&o.useSpecCache, "spec-cache", o.useSpecCache, "Evaluate each distinct pod spec only once and reuse the result for the pods sharing it, e.g. the replicas of a ReplicaSet. The hit rate of the cache is logged with -v=2.")
+	flags.StringVar(&o.specCacheFile, "spec-cache-file", "", "Load the pod spec cache from this file and store it back after the inspection so that it is reused across runs.")
+	flags.Float32Var(&o.qps, "qps", o.qps, "Maximum queries per second to the API server.")
+	flags.IntVar(&o.burst, "burst", o.burst, "Maximum burst of queries to the API server.")
+	flags.BoolVar(&o.showProgress, "progress", false, "Periodically print the number of inspected namespaces to stderr.")
 }
 
 func (o *ClusterInspectOptions) Complete(cmd *cobra.Command, clientConfigOptions *genericclioptions.ConfigFlags) error {
 	o.updatesOnly = cmdutil.GetFlagBool(cmd, "updates-only")
 	o.clientConfigOptions = clientConfigOptions
+	o.errOut = cmd.ErrOrStderr()
+
+	if o.useSpecCache {
+		var err error
+		if len(o.specCacheFile) > 0 {
+			o.specCache, err = admission.LoadSpecCache(o.specCacheFile)
+		} else {
+			o.specCache = admission.NewSpecCache()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if o.multiContext() {
+		return o.completeContexts()
+	}
+
+	if len(o.fromDump) > 0 {
+		var err error
+		if o.defaults, err = collect.PodSecurityDefaults(o.fromDump); err != nil {
+			return err
+		}
+		o.kubeClient, err = dump.NewClientset(o.fromDump)
+		return err
+	}
 
 	clientConfig, err := o.clientConfigOptions.ToRawKubeConfigLoader().ClientConfig()
 	if err != nil {
 		return fmt.Errorf("failed to read kube client configuration")
 	}
+	o.configureClient(clientConfig)
+	o.clientConfig = clientConfig
 
 	o.kubeClient, err = kubernetes.NewForConfig(clientConfig)
 	if err != nil {
@@ -45,35 +173,318 @@ func (o *ClusterInspectOptions) Complete(cmd *cobra.Command, clientConfigOptions
 	return nil
 }
 
-func (o *ClusterInspectOptions) Run(ctx context.Context) (*admission.OrderedStringToPSALevelMap, error) {
-	adm, err := admission.NewParallelAdmission(o.kubeClient)
-	if err != nil {
-		return nil, fmt.Errorf("failed to set up admission: %w", err)
+func (o *ClusterInspectOptions) Validate() []error {
+	errs := []error{}
+
+	if o.workers < 1 {
+		errs = append(errs, fmt.Errorf("--workers must be a positive number"))
 	}
 
-	listOpts := metav1.ListOptions{}
-	if o.clientConfigOptions.Namespace != nil && *o.clientConfigOptions.Namespace != "" {
-		listOpts.FieldSelector = fields.OneTermEqualSelector("metadata.name", *o.clientConfigOptions.Namespace).String()
+	if o.chunkSize < 0 {
+		errs = append(errs, fmt.Errorf("--chunk-size must not be negative"))
 	}
-	namespacesList, err := o.kubeClient.CoreV1().Namespaces().List(ctx, listOpts)
-	if err != nil {
-		return nil, fmt.Errorf("failed to list namespaces: %w", err)
+
+	if len(o.specCacheFile) > 0 && !o.useSpecCache {
+		errs = append(errs, fmt.Errorf("--spec-cache-file cannot be used with --spec-cache=false"))
 	}
 
-	nsAggregatedResults, err := adm.ValidateNamespaces(ctx, namespacesList.Items...)
+	if len(o.fromDump) > 0 {
+		if o.multiContext() {
+			errs = append(errs, fmt.Errorf("--from-dump cannot be used with --all-contexts or --contexts"))
+		}
+		if o.emitEvents {
+			errs = append(errs, fmt.Errorf("--from-dump cannot be used with --emit-events"))
+		}
+		if o.discoverDefaults {
+			errs = append(errs, fmt.Errorf("--from-dump cannot be used with --discover-defaults, the defaults are read from the archives created by the collect command"))
+		}
+	}
+
+	if o.allContexts && len(o.contexts) > 0 {
+		errs = append(errs, fmt.Errorf("--all-contexts and --contexts are mutually exclusive"))
+	}
+
+	if o.multiContext() {
+		if len(o.contextClients) == 0 {
+			errs = append(errs, fmt.Errorf("no kube clients for the selected contexts"))
+		}
+		// a single API server cannot serve the clusters of multiple contexts
+		if len(o.contextClients) > 1 {
+			if len(stringValue(o.clientConfigOptions.APIServer)) > 0 {
+				errs = append(errs, fmt.Errorf("--server cannot be used with multiple contexts"))
+			}
+			if len(stringValue(o.clientConfigOptions.TLSServerName)) > 0 {
+				errs = append(errs, fmt.Errorf("--tls-server-name cannot be used with multiple contexts"))
+			}
+		}
+	} else if o.kubeClient == nil {
+		errs = append(errs, fmt.Errorf("missing kube client"))
+	}
+
+	switch o.evaluationMode {
+	case evaluationModeComplete, evaluationModeAdmission:
+	default:
+		errs = append(errs, fmt.Errorf("unsupported evaluation mode %q", o.evaluationMode))
+	}
+
+	switch o.output {
+	case "":
+	case "json":
+		if o.emitEvents {
+			errs = append(errs, fmt.Errorf("--emit-events cannot be used with --output=json"))
+		}
+		if o.explain {
+			errs = append(errs, fmt.Errorf("--explain cannot be used with --output=json"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unsupported output format %q", o.output))
+	}
+
+	return errs
+}
+
+// Run evaluates the inspected namespaces and returns the most restrictive level for each of them
+// along with the checks that prevent them from using the more restrictive levels.
+func (o *ClusterInspectOptions) Run(ctx context.Context) (admission.NamespaceEvaluations, error) {
+	inspection, err := o.scan(ctx, len(o.historyDir) > 0)
 	if err != nil {
 		return nil, err
 	}
+	nsAggregatedResults := inspection.evaluations
+
+	if o.emitEvents {
+		if err := emitNamespaceEvents(ctx, o.kubeClient, inspection.namespaces, recommendedLevels(nsAggregatedResults), o.defaults); err != nil {
+			return nil, err
+		}
+	}
+	if err := o.SaveSnapshot(inspection.snapshot()); err != nil {
+		return nil, err
+	}
 	if o.updatesOnly {
-		for _, origNS := range namespacesList.Items {
-			suggestedLevel := nsAggregatedResults[origNS.Name]
-			// FIXME: we need to take the global config into account during the validation otherwise
-			//        this is going to include NSes that don't need updating
-			if string(suggestedLevel) == origNS.Labels[psapi.EnforceLevelLabel] {
+		for _, origNS := range inspection.namespaces {
+			evaluation, ok := nsAggregatedResults[origNS.Name]
+			if ok && string(evaluation.Level) == o.defaults.Apply(origNS.Labels)[psapi.EnforceLevelLabel] {
 				delete(nsAggregatedResults, origNS.Name)
 			}
 		}
 	}
 
-	return admission.NewOrderedStringToPSALevelMap(nsAggregatedResults), nil
+	return nsAggregatedResults, nil
+}
+
+// scanResults are the results of a single scan of the inspected namespaces
+type scanResults struct {
+	start       time.Time
+	namespaces  []corev1.Namespace
+	evaluations admission.NamespaceEvaluations
+	// workloads are the per-workload results of the namespaces, they are only collected on request
+	workloads []*admission.NamespaceResult
+}
+
+// snapshot returns the per-workload results of the scan, nil if they were not collected
+func (r *scanResults) snapshot() *snapshot.Snapshot {
+	if r.workloads == nil {
+		return nil
+	}
+	return snapshot.New(r.start, r.workloads)
+}
+
+// scan evaluates the inspected namespaces based on the evaluation mode. With withWorkloads, the
+// per-workload results are collected as well, their recommended levels are the evaluated ones.
+func (o *ClusterInspectOptions) scan(ctx context.Context, withWorkloads bool) (*scanResults, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	adm, podLister, err := o.newAdmission(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer o.saveSpecCache()
+
+	// the admission mode evaluates the namespaces by the upstream admission, the workloads
+	// need to be evaluated separately, don't count those evaluations
+	var workloadsAdm *admission.ParallelAdmission
+	if withWorkloads && o.evaluationMode == evaluationModeAdmission {
+		workloadsAdm, err = admission.NewParallelAdmissionWithPodLister(podLister, admission.NewEvaluationCounter())
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up admission: %w", err)
+		}
+		workloadsAdm.WithSpecCache(o.specCache)
+	}
+
+	results := &scanResults{start: time.Now()}
+	results.namespaces, err = o.listNamespaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+	o.discoverPodSecurityDefaults(ctx, results.namespaces)
+
+	var resultsLock sync.Mutex
+	results.evaluations = make(admission.NamespaceEvaluations, len(results.namespaces))
+	if withWorkloads {
+		results.workloads = make([]*admission.NamespaceResult, 0, len(results.namespaces))
+	}
+	err = o.forEachNamespace(ctx, results.namespaces, func(ns *corev1.Namespace) error {
+		nsResults, workloads, err := o.evaluateNamespace(ctx, adm, ns, withWorkloads)
+		if err != nil {
+			return err
+		}
+		if workloadsAdm != nil {
+			pods, err := podLister.ListPods(ctx, ns.Name)
+			if err != nil {
+				return fmt.Errorf("failed to list pods in namespace %q: %w", ns.Name, err)
+			}
+			workloads = []*admission.NamespaceResult{workloadsAdm.InspectNamespace(ctx, ns, pods...)}
+		}
+
+		resultsLock.Lock()
+		defer resultsLock.Unlock()
+		for name, evaluation := range nsResults {
+			results.evaluations[name] = evaluation
+		}
+		for _, w := range workloads {
+			if evaluation, ok := nsResults[w.Name]; ok {
+				w.RecommendedLevel = evaluation.Level
+			}
+			w.DefaultEnforce = o.defaults.EnforceLevel()
+			results.workloads = append(results.workloads, w)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// discoverPodSecurityDefaults discovers the default PodSecurity levels of the cluster once if
+// requested, failures are reported and the defaults remain unknown then
+func (o *ClusterInspectOptions) discoverPodSecurityDefaults(ctx context.Context, namespaces []corev1.Namespace) {
+	if !o.discoverDefaults || o.defaults != nil || o.clientConfig == nil {
+		return
+	}
+
+	defaults := psaconfig.Discover(ctx, o.clientConfig, namespaces)
+	if len(defaults.Error) > 0 {
+		fmt.Fprintf(o.errOut, "%sfailed to discover the default PodSecurity levels: %s\n", o.progressPrefix, defaults.Error)
+		return
+	}
+	o.defaults = defaults
+}
+
+// recommendedLevels returns the levels of the evaluated namespaces except for those whose
+// labels are managed by the OpenShift label syncer
+func recommendedLevels(evaluations admission.NamespaceEvaluations) map[string]psapi.Level {
+	levels := evaluations.Levels()
+	for name, evaluation := range evaluations {
+		if evaluation.LabelsSynced {
+			delete(levels, name)
+		}
+	}
+	return levels
+}
+
+// newAdmission sets up the admission along with the pod lister it uses, the lister
+// stops watching the pods once ctx is done
+func (o *ClusterInspectOptions) newAdmission(ctx context.Context) (*admission.ParallelAdmission, psadmission.PodLister, error) {
+	podLister, err := o.newPodLister(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	adm, err := admission.NewParallelAdmissionWithPodLister(podLister, o.evaluationCounter)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to set up admission: %w", err)
+	}
+	return adm.WithSpecCache(o.specCache), podLister, nil
+}
+
+// saveSpecCache logs the hit rate of the spec cache and persists it if requested
+func (o *ClusterInspectOptions) saveSpecCache() {
+	if o.specCache == nil {
+		return
+	}
+
+	hits, lookups, rate := o.specCache.HitRate()
+	klog.V(2).Infof("%spod spec cache: %d hits out of %d lookups (%.1f%%)", o.progressPrefix, hits, lookups, rate*100)
+
+	if len(o.specCacheFile) == 0 {
+		return
+	}
+	if err := o.specCache.Save(o.specCacheFile); err != nil {
+		klog.Errorf("%v", err)
+	}
+}
+
+// evaluateNamespace evaluates the namespace based on the evaluation mode and reports
+// if the PodSecurity admission would not check all of its pods. With withWorkloads, the
+// complete evaluation also returns the per-workload results.
+func (o *ClusterInspectOptions) evaluateNamespace(ctx context.Context, adm *admission.ParallelAdmission, ns *corev1.Namespace, withWorkloads bool) (admission.NamespaceEvaluations, []*admission.NamespaceResult, error) {
+	var (
+		evaluations admission.NamespaceEvaluations
+		workloads   []*admission.NamespaceResult
+		err         error
+	)
+	switch {
+	case o.evaluationMode == evaluationModeAdmission:
+		evaluations, err = adm.ValidateNamespaces(ctx, *ns)
+	case withWorkloads:
+		evaluations, workloads, err = adm.InspectNamespaces(ctx, *ns)
+	default:
+		evaluations, err = adm.EvaluateNamespaces(ctx, *ns)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for name, evaluation := range evaluations {
+		switch {
+		case !evaluation.Truncated():
+		case o.evaluationMode == evaluationModeAdmission:
+			fmt.Fprintf(o.errOut, "%snamespace %q: the PodSecurity admission only checked %d of its %d pods, the level is based on those pods alone\n",
+				o.progressPrefix, name, evaluation.AdmissionCheckedPods, evaluation.TotalPods)
+		case evaluation.AdmissionCheckedPodsEstimated:
+			fmt.Fprintf(o.errOut, "%snamespace %q: the PodSecurity admission would likely run out of time after checking about %d of its %d pods on an enforce label update (estimate)\n",
+				o.progressPrefix, name, evaluation.AdmissionCheckedPods, evaluation.TotalPods)
+		default:
+			fmt.Fprintf(o.errOut, "%snamespace %q: the PodSecurity admission would only check %d of its %d pods on an enforce label update\n",
+				o.progressPrefix, name, evaluation.AdmissionCheckedPods, evaluation.TotalPods)
+		}
+	}
+	return evaluations, workloads, nil
+}
+
+// Inspect evaluates each workload of the inspected namespaces separately and returns
+// the detailed per-namespace results. The pods are listed once, by the same scan that
+// evaluates the namespaces.
+func (o *ClusterInspectOptions) Inspect(ctx context.Context) ([]*admission.NamespaceResult, error) {
+	inspection, err := o.scan(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	return inspection.workloads, nil
+}
+
+// Snapshot returns the detailed results of the inspected namespaces in their serializable form,
+// the recommended levels of the namespaces are evaluated based on the evaluation mode
+func (o *ClusterInspectOptions) Snapshot(ctx context.Context) (*snapshot.Snapshot, error) {
+	inspection, err := o.scan(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	return inspection.snapshot(), nil
+}
+
+// SaveSnapshot stores the snapshot in the history directory if one was configured
+func (o *ClusterInspectOptions) SaveSnapshot(snap *snapshot.Snapshot) error {
+	if len(o.historyDir) == 0 || snap == nil {
+		return nil
+	}
+	return history.NewStore(o.historyDir).Save(snap)
+}
+
+// EvaluationCounts returns the counts of the admission evaluations of all the runs so far.
+func (o *ClusterInspectOptions) EvaluationCounts() admission.EvaluationCounts {
+	return o.evaluationCounter.Counts()
 }
@@ -0,0 +1,202 @@
+package clusterinspect
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/pager"
+	"k8s.io/client-go/util/workqueue"
+	psadmission "k8s.io/pod-security-admission/admission"
+)
+
+func (o *ClusterInspectOptions) configureClient(clientConfig *rest.Config) {
+	clientConfig.QPS = o.qps
+	clientConfig.Burst = o.burst
+}
+
+// listNamespaces lists the inspected namespaces in chunks of o.chunkSize
+func (o *ClusterInspectOptions) listNamespaces(ctx context.Context) ([]corev1.Namespace, error) {
+	listOpts := metav1.ListOptions{}
+	if ns := o.namespace(); len(ns) > 0 {
+		listOpts.FieldSelector = fields.OneTermEqualSelector("metadata.name", ns).String()
+	}
+
+	var namespaces []corev1.Namespace
+	err := o.pager(func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+		return o.kubeClient.CoreV1().Namespaces().List(ctx, opts)
+	}).EachListItem(ctx, listOpts, func(obj runtime.Object) error {
+		ns := obj.(*corev1.Namespace)
+		// the in-memory clients of dumps ignore field selectors
+		if name := o.namespace(); len(name) > 0 && ns.Name != name {
+			return nil
+		}
+		namespaces = append(namespaces, *ns)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list namespaces: %w", err)
+	}
+	return namespaces, nil
+}
+
+// listPods lists the pods of the namespace in chunks of o.chunkSize
+func (o *ClusterInspectOptions) listPods(ctx context.Context, namespace string) ([]*corev1.Pod, error) {
+	var pods []*corev1.Pod
+	err := o.pager(func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+		return o.kubeClient.CoreV1().Pods(namespace).List(ctx, opts)
+	}).EachListItem(ctx, metav1.ListOptions{}, func(obj runtime.Object) error {
+		pods = append(pods, obj.(*corev1.Pod))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pods, nil
+}
+
+// namespace returns the namespace the inspection is limited to or an empty string
+// if all namespaces are inspected
+func (o *ClusterInspectOptions) namespace() string {
+	if o.clientConfigOptions.Namespace == nil {
+		return ""
+	}
+	return *o.clientConfigOptions.Namespace
+}
+
+// podListerFunc allows using a function as a psadmission.PodLister
+type podListerFunc func(ctx context.Context, namespace string) ([]*corev1.Pod, error)
+
+func (f podListerFunc) ListPods(ctx context.Context, namespace string) ([]*corev1.Pod, error) {
+	return f(ctx, namespace)
+}
+
+// newPodLister returns the lister the pods of the inspected namespaces are retrieved from.
+// Unless the pod cache is disabled, all the pods are listed just once by a shared informer
+// that runs until ctx is done, otherwise the pods get listed per namespace.
+func (o *ClusterInspectOptions) newPodLister(ctx context.Context) (psadmission.PodLister, error) {
+	if !o.cachePods {
+		return podListerFunc(o.listPods), nil
+	}
+
+	var factoryOpts []informers.SharedInformerOption
+	if ns := o.namespace(); len(ns) > 0 {
+		factoryOpts = append(factoryOpts, informers.WithNamespace(ns))
+	}
+	informerFactory := informers.NewSharedInformerFactoryWithOptions(o.kubeClient, 0, factoryOpts...)
+
+	podInformer := informerFactory.Core().V1().Pods()
+	// the managed fields are of no use to the evaluation, don't keep them in memory
+	if err := podInformer.Informer().SetTransform(stripManagedFields); err != nil {
+		return nil, fmt.Errorf("failed to set up the pod informer: %w", err)
+	}
+
+	informerFactory.Start(ctx.Done())
+	for informerType, synced := range informerFactory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return nil, fmt.Errorf("failed to sync the %v informer", informerType)
+		}
+	}
+
+	return psadmission.PodListerFromInformer(podInformer.Lister()), nil
+}
+
+func stripManagedFields(obj interface{}) (interface{}, error) {
+	if accessor, ok := obj.(metav1.ObjectMetaAccessor); ok {
+		accessor.GetObjectMeta().SetManagedFields(nil)
+	}
+	return obj, nil
+}
+
+func (o *ClusterInspectOptions) pager(listFunc pager.ListPageFunc) *pager.ListPager {
+	p := pager.New(listFunc)
+	p.PageSize = o.chunkSize
+	return p
+}
+
+// forEachNamespace calls f for each of the namespaces using o.workers workers and
+// reports the progress if requested
+func (o *ClusterInspectOptions) forEachNamespace(ctx context.Context, namespaces []corev1.Namespace, f func(ns *corev1.Namespace) error) error {
+	progress := o.newProgressReporter(len(namespaces))
+
+	var (
+		lock sync.Mutex
+		errs []error
+	)
+	workqueue.ParallelizeUntil(ctx, o.workers, len(namespaces), func(i int) {
+		if err := f(&namespaces[i]); err != nil {
+			lock.Lock()
+			errs = append(errs, err)
+			lock.Unlock()
+		}
+		progress.inc()
+	})
+	progress.finish()
+
+	if err := ctx.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// progressReporter writes the number of processed namespaces at most once per progressInterval
+type progressReporter struct {
+	out    io.Writer
+	prefix string
+	total  int
+
+	lock       sync.Mutex
+	done       int
+	lastReport time.Time
+}
+
+const progressInterval = time.Second
+
+func (o *ClusterInspectOptions) newProgressReporter(total int) *progressReporter {
+	if !o.showProgress {
+		return nil
+	}
+	return &progressReporter{
+		out:    o.errOut,
+		prefix: o.progressPrefix,
+		total:  total,
+	}
+}
+
+func (p *progressReporter) inc() {
+	if p == nil {
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.done++
+	if time.Since(p.lastReport) >= progressInterval {
+		p.report()
+	}
+}
+
+func (p *progressReporter) finish() {
+	if p == nil {
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.report()
+}
+
+func (p *progressReporter) report() {
+	p.lastReport = time.Now()
+	fmt.Fprintf(p.out, "%sinspected %d/%d namespaces\n", p.prefix, p.done, p.total)
+}
@@ -0,0 +1,124 @@
+package collect
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/version"
+
+	"github.com/stlaz/psachecker/pkg/psaconfig"
+)
+
+// MetadataFile is the name of the archive member describing the collection
+const MetadataFile = "metadata.json"
+
+var errNotCollected = errors.New("it was not created by the collect command")
+
+// Metadata describes the cluster and the collection the archive was created by
+type Metadata struct {
+	CollectedAt         time.Time           `json:"collectedAt"`
+	ServerVersion       *version.Info       `json:"serverVersion,omitempty"`
+	PodSecurityDefaults *psaconfig.Defaults `json:"podSecurityDefaults,omitempty"`
+	// Resources are the numbers of the collected objects by their resource
+	Resources map[string]int `json:"resources"`
+	// Errors describe the resources that could not be collected
+	Errors []string `json:"errors,omitempty"`
+}
+
+// archiveWriter writes the files of a gzipped tarball
+type archiveWriter struct {
+	f  *os.File
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func newArchiveWriter(path string) (*archiveWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the archive: %w", err)
+	}
+	gz := gzip.NewWriter(f)
+	return &archiveWriter{f: f, gz: gz, tw: tar.NewWriter(gz)}, nil
+}
+
+func (w *archiveWriter) writeJSON(name string, obj interface{}) error {
+	data, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize %s: %w", name, err)
+	}
+
+	if err := w.tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}); err != nil {
+		return fmt.Errorf("failed to write %s to the archive: %w", name, err)
+	}
+	if _, err := w.tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s to the archive: %w", name, err)
+	}
+	return nil
+}
+
+func (w *archiveWriter) Close() error {
+	return utilerrors.NewAggregate([]error{w.tw.Close(), w.gz.Close(), w.f.Close()})
+}
+
+// ReadMetadata returns the metadata of an archive created by the collect command
+func ReadMetadata(path string) (*Metadata, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress %q: %w", path, err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%q does not contain %s, %w", path, MetadataFile, errNotCollected)
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read %q: %w", path, err)
+		}
+		if hdr.Name != MetadataFile {
+			continue
+		}
+
+		metadata := &Metadata{}
+		if err := json.NewDecoder(tr).Decode(metadata); err != nil {
+			return nil, fmt.Errorf("failed to decode %s in %q: %w", MetadataFile, path, err)
+		}
+		return metadata, nil
+	}
+}
+
+// PodSecurityDefaults returns the default PodSecurity levels stored in the archive created by the
+// collect command, nil if the dump at the path is not such an archive or they were not discovered
+func PodSecurityDefaults(path string) (*psaconfig.Defaults, error) {
+	if !strings.HasSuffix(path, ".tar.gz") && !strings.HasSuffix(path, ".tgz") {
+		return nil, nil
+	}
+
+	metadata, err := ReadMetadata(path)
+	if errors.Is(err, errNotCollected) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return metadata.PodSecurityDefaults, nil
+}
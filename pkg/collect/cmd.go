@@ -0,0 +1,35 @@
+package collect
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+func NewCollectCommand(clientConfigOptions *genericclioptions.ConfigFlags) *cobra.Command {
+	o := newCollectOptions()
+
+	cmd := &cobra.Command{
+		Use:          "collect <archive.tar.gz> [flags]",
+		Short:        "export the cluster objects the inspections need into an archive that can be analyzed offline",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(args, clientConfigOptions); err != nil {
+				return err
+			}
+			errs := o.Validate()
+			if len(errs) > 0 {
+				return fmt.Errorf("there were errors while setting up the command: %v", errs)
+			}
+
+			return o.Run(context.Background(), c.ErrOrStderr())
+		},
+	}
+
+	o.AddFlags(cmd)
+	return cmd
+}
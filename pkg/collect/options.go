@@ -0,0 +1,261 @@
+package collect
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/pager"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/stlaz/psachecker/pkg/psaconfig"
+)
+
+type CollectOptions struct {
+	archivePath      string
+	namespace        string
+	chunkSize        int64
+	discoverDefaults bool
+
+	clientConfig *rest.Config
+	kubeClient   kubernetes.Interface
+}
+
+func newCollectOptions() *CollectOptions {
+	return &CollectOptions{
+		chunkSize: 500,
+	}
+}
+
+// collectedResource is a resource whose objects are exported to the archive
+type collectedResource struct {
+	name string
+	// required resources fail the collection if they cannot be listed
+	required bool
+	list     func(ctx context.Context, client kubernetes.Interface, namespace string, opts metav1.ListOptions) (runtime.Object, error)
+}
+
+var collectedResources = []collectedResource{
+	{name: "pods", required: true, list: func(ctx context.Context, client kubernetes.Interface, namespace string, opts metav1.ListOptions) (runtime.Object, error) {
+		return client.CoreV1().Pods(namespace).List(ctx, opts)
+	}},
+	{name: "replicationcontrollers", list: func(ctx context.Context, client kubernetes.Interface, namespace string, opts metav1.ListOptions) (runtime.Object, error) {
+		return client.CoreV1().ReplicationControllers(namespace).List(ctx, opts)
+	}},
+	{name: "deployments", list: func(ctx context.Context, client kubernetes.Interface, namespace string, opts metav1.ListOptions) (runtime.Object, error) {
+		return client.AppsV1().Deployments(namespace).List(ctx, opts)
+	}},
+	{name: "replicasets", list: func(ctx context.Context, client kubernetes.Interface, namespace string, opts metav1.ListOptions) (runtime.Object, error) {
+		return client.AppsV1().ReplicaSets(namespace).List(ctx, opts)
+	}},
+	{name: "statefulsets", list: func(ctx context.Context, client kubernetes.Interface, namespace string, opts metav1.ListOptions) (runtime.Object, error) {
+		return client.AppsV1().StatefulSets(namespace).List(ctx, opts)
+	}},
+	{name: "daemonsets", list: func(ctx context.Context, client kubernetes.Interface, namespace string, opts metav1.ListOptions) (runtime.Object, error) {
+		return client.AppsV1().DaemonSets(namespace).List(ctx, opts)
+	}},
+	{name: "jobs", list: func(ctx context.Context, client kubernetes.Interface, namespace string, opts metav1.ListOptions) (runtime.Object, error) {
+		return client.BatchV1().Jobs(namespace).List(ctx, opts)
+	}},
+	{name: "cronjobs", list: func(ctx context.Context, client kubernetes.Interface, namespace string, opts metav1.ListOptions) (runtime.Object, error) {
+		return client.BatchV1().CronJobs(namespace).List(ctx, opts)
+	}},
+	{name: "events", list: func(ctx context.Context, client kubernetes.Interface, namespace string, opts metav1.ListOptions) (runtime.Object, error) {
+		return client.CoreV1().Events(namespace).List(ctx, opts)
+	}},
+}
+
+func (o *CollectOptions) AddFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+
+	flags.Int64Var(&o.chunkSize, "chunk-size", o.chunkSize, "Return large lists in chunks rather than all at once. Pass 0 to disable.")
+	flags.BoolVar(&o.discoverDefaults, "discover-defaults", o.discoverDefaults, "Discover the default PodSecurity levels of the cluster by creating dry-run probe pods in a namespace without PodSecurity labels. Requires the permission to create pods in that namespace.")
+}
+
+func (o *CollectOptions) Complete(args []string, clientConfigOptions *genericclioptions.ConfigFlags) error {
+	o.archivePath = args[0]
+	if clientConfigOptions.Namespace != nil {
+		o.namespace = *clientConfigOptions.Namespace
+	}
+
+	var err error
+	o.clientConfig, err = clientConfigOptions.ToRawKubeConfigLoader().ClientConfig()
+	if err != nil {
+		return fmt.Errorf("failed to read kube client configuration")
+	}
+
+	o.kubeClient, err = kubernetes.NewForConfig(o.clientConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create kube client: %w", err)
+	}
+
+	return nil
+}
+
+func (o *CollectOptions) Validate() []error {
+	errs := []error{}
+
+	if !strings.HasSuffix(o.archivePath, ".tar.gz") && !strings.HasSuffix(o.archivePath, ".tgz") {
+		errs = append(errs, fmt.Errorf("the archive name must end with .tar.gz or .tgz"))
+	}
+
+	if o.chunkSize < 0 {
+		errs = append(errs, fmt.Errorf("--chunk-size must not be negative"))
+	}
+
+	return errs
+}
+
+func (o *CollectOptions) Run(ctx context.Context, errOut io.Writer) (err error) {
+	archive, err := newArchiveWriter(o.archivePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := archive.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(o.archivePath)
+		}
+	}()
+
+	metadata := &Metadata{
+		CollectedAt: time.Now().UTC(),
+		Resources:   map[string]int{},
+	}
+
+	namespaceListOpts := metav1.ListOptions{}
+	if len(o.namespace) > 0 {
+		namespaceListOpts.FieldSelector = fields.OneTermEqualSelector("metadata.name", o.namespace).String()
+	}
+	namespaces, err := o.list(ctx, namespaceListOpts, func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+		return o.kubeClient.CoreV1().Namespaces().List(ctx, opts)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list namespaces: %w", err)
+	}
+	if err := o.writeList(archive, metadata, "namespaces", namespaces); err != nil {
+		return err
+	}
+
+	for _, r := range collectedResources {
+		r := r
+		objs, err := o.list(ctx, metav1.ListOptions{}, func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+			return r.list(ctx, o.kubeClient, o.namespace, opts)
+		})
+		if err != nil {
+			if r.required {
+				return fmt.Errorf("failed to list %s: %w", r.name, err)
+			}
+			metadata.Errors = append(metadata.Errors, fmt.Sprintf("failed to list %s: %v", r.name, err))
+			continue
+		}
+		if err := o.writeList(archive, metadata, r.name, objs); err != nil {
+			return err
+		}
+	}
+
+	if metadata.ServerVersion, err = o.kubeClient.Discovery().ServerVersion(); err != nil {
+		metadata.Errors = append(metadata.Errors, fmt.Sprintf("failed to get the server version: %v", err))
+	}
+
+	if o.discoverDefaults {
+		nsObjs := make([]corev1.Namespace, 0, len(namespaces))
+		for _, obj := range namespaces {
+			nsObjs = append(nsObjs, *obj.(*corev1.Namespace))
+		}
+		metadata.PodSecurityDefaults = psaconfig.Discover(ctx, o.clientConfig, nsObjs)
+	}
+
+	if err := archive.writeJSON(MetadataFile, metadata); err != nil {
+		return err
+	}
+
+	printSummary(errOut, o.archivePath, metadata)
+	return nil
+}
+
+// list returns all the objects of the list in chunks of o.chunkSize
+func (o *CollectOptions) list(ctx context.Context, opts metav1.ListOptions, listFunc pager.ListPageFunc) ([]runtime.Object, error) {
+	p := pager.New(listFunc)
+	p.PageSize = o.chunkSize
+
+	var objs []runtime.Object
+	err := p.EachListItem(ctx, opts, func(obj runtime.Object) error {
+		objs = append(objs, obj)
+		return nil
+	})
+	return objs, err
+}
+
+// writeList writes the objects as a v1 List in the same form as `kubectl get -o json` does
+func (o *CollectOptions) writeList(archive *archiveWriter, metadata *Metadata, resource string, objs []runtime.Object) error {
+	for _, obj := range objs {
+		// the items of typed lists are missing their kind
+		gvks, _, err := scheme.Scheme.ObjectKinds(obj)
+		if err != nil {
+			return fmt.Errorf("failed to determine the kind of %s: %w", resource, err)
+		}
+		obj.GetObjectKind().SetGroupVersionKind(gvks[0])
+
+		// the managed fields of the namespaces tell who owns their PodSecurity labels, e.g.
+		// whether the OpenShift label syncer manages them
+		if _, isNamespace := obj.(*corev1.Namespace); isNamespace {
+			continue
+		}
+		if accessor, err := meta.Accessor(obj); err == nil {
+			accessor.SetManagedFields(nil)
+		}
+	}
+
+	metadata.Resources[resource] = len(objs)
+	return archive.writeJSON(resource+".json", &metav1.List{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "List"},
+		Items:    rawExtensions(objs),
+	})
+}
+
+func rawExtensions(objs []runtime.Object) []runtime.RawExtension {
+	ret := make([]runtime.RawExtension, 0, len(objs))
+	for _, obj := range objs {
+		ret = append(ret, runtime.RawExtension{Object: obj})
+	}
+	return ret
+}
+
+func printSummary(out io.Writer, path string, metadata *Metadata) {
+	resources := make([]string, 0, len(metadata.Resources))
+	for r := range metadata.Resources {
+		resources = append(resources, r)
+	}
+	sort.Strings(resources)
+
+	counts := make([]string, 0, len(resources))
+	for _, r := range resources {
+		counts = append(counts, fmt.Sprintf("%d %s", metadata.Resources[r], r))
+	}
+	fmt.Fprintf(out, "collected %s into %s\n", strings.Join(counts, ", "), path)
+
+	for _, e := range metadata.Errors {
+		fmt.Fprintf(out, "warning: %s\n", e)
+	}
+	if d := metadata.PodSecurityDefaults; d != nil && len(d.Error) > 0 {
+		fmt.Fprintf(out, "warning: failed to discover the default PodSecurity levels: %s\n", d.Error)
+	}
+}
@@ -0,0 +1,62 @@
+package collect
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/stlaz/psachecker/pkg/dump"
+	"github.com/stlaz/psachecker/pkg/openshift"
+)
+
+func TestCollectKeepsNamespaceLabelOwners(t *testing.T) {
+	syncedLabels := metav1.ManagedFieldsEntry{
+		Manager:    "pod-security-admission-label-synchronization-controller",
+		Operation:  metav1.ManagedFieldsOperationApply,
+		FieldsType: "FieldsV1",
+		FieldsV1:   &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:labels":{"f:pod-security.kubernetes.io/warn":{}}}}`)},
+	}
+	client := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:          "app",
+			Labels:        map[string]string{"pod-security.kubernetes.io/warn": "restricted"},
+			Annotations:   map[string]string{openshift.UIDRangeAnnotation: "1000660000/10000"},
+			ManagedFields: []metav1.ManagedFieldsEntry{syncedLabels},
+		}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "app", Name: "web", ManagedFields: []metav1.ManagedFieldsEntry{syncedLabels}}},
+	)
+
+	o := newCollectOptions()
+	o.archivePath = filepath.Join(t.TempDir(), "cluster.tar.gz")
+	o.kubeClient = client
+	if err := o.Run(context.Background(), io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	objs, err := dump.Load(o.archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var namespaces int
+	for _, obj := range objs {
+		switch obj := obj.(type) {
+		case *corev1.Namespace:
+			namespaces++
+			if !openshift.LabelsSynced(obj) {
+				t.Errorf("expected the labels of the collected namespace to be synced, managed fields: %v", obj.ManagedFields)
+			}
+		case *corev1.Pod:
+			if len(obj.ManagedFields) > 0 {
+				t.Errorf("expected the managed fields of the pods to be dropped")
+			}
+		}
+	}
+	if namespaces != 1 {
+		t.Errorf("expected a single namespace in the archive, got %d", namespaces)
+	}
+}
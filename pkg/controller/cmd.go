@@ -0,0 +1,34 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+func NewControllerCommand(clientConfigOptions *genericclioptions.ConfigFlags) *cobra.Command {
+	o := newControllerOptions()
+
+	cmd := &cobra.Command{
+		Use:          "run-controller [flags]",
+		Short:        "continuously publish the least privileged PodSecurity level of each namespace as namespace annotations",
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(c, clientConfigOptions); err != nil {
+				return err
+			}
+			errs := o.Validate()
+			if len(errs) > 0 {
+				return fmt.Errorf("there were errors while setting up the command: %v", errs)
+			}
+
+			return o.Run(context.Background())
+		},
+	}
+
+	o.AddFlags(cmd)
+	return cmd
+}
@@ -0,0 +1,223 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	"github.com/stlaz/psachecker/pkg/admission"
+)
+
+const (
+	annotationPrefix = "psachecker.io/"
+
+	RecommendedEnforceAnnotation = annotationPrefix + "recommended-enforce"
+	BlockingWorkloadsAnnotation  = annotationPrefix + "blocking-workloads"
+	EvaluatedAtAnnotation        = annotationPrefix + "evaluated-at"
+)
+
+// NamespaceRecommendationController evaluates the pods of each namespace whenever
+// the namespace or any of its pods change and publishes the recommended PodSecurity
+// level as annotations of the namespace and, optionally, as the status of
+// a PodSecurityRecommendation object in that namespace.
+type NamespaceRecommendationController struct {
+	kubeClient kubernetes.Interface
+	// dynamicClient is only set when PodSecurityRecommendation objects should be published
+	dynamicClient dynamic.Interface
+	adm           *admission.ParallelAdmission
+
+	nsLister     corev1listers.NamespaceLister
+	podLister    corev1listers.PodLister
+	cachesSynced []cache.InformerSynced
+
+	queue workqueue.RateLimitingInterface
+
+	// refreshInterval is the maximum age of the evaluation timestamp of a namespace
+	// whose recommendation did not change
+	refreshInterval time.Duration
+}
+
+func NewNamespaceRecommendationController(
+	kubeClient kubernetes.Interface,
+	dynamicClient dynamic.Interface,
+	adm *admission.ParallelAdmission,
+	informerFactory informers.SharedInformerFactory,
+	refreshInterval time.Duration,
+) *NamespaceRecommendationController {
+	nsInformer := informerFactory.Core().V1().Namespaces()
+	podInformer := informerFactory.Core().V1().Pods()
+
+	c := &NamespaceRecommendationController{
+		kubeClient:    kubeClient,
+		dynamicClient: dynamicClient,
+		adm:           adm,
+
+		nsLister:  nsInformer.Lister(),
+		podLister: podInformer.Lister(),
+		cachesSynced: []cache.InformerSynced{
+			nsInformer.Informer().HasSynced,
+			podInformer.Informer().HasSynced,
+		},
+
+		queue: workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "psachecker-namespaces"),
+
+		refreshInterval: refreshInterval,
+	}
+
+	nsInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueueNamespace,
+		UpdateFunc: func(_, newObj interface{}) { c.enqueueNamespace(newObj) },
+	})
+	podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueuePodNamespace,
+		UpdateFunc: func(_, newObj interface{}) { c.enqueuePodNamespace(newObj) },
+		DeleteFunc: c.enqueuePodNamespace,
+	})
+
+	return c
+}
+
+func (c *NamespaceRecommendationController) enqueueNamespace(obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	c.queue.Add(key)
+}
+
+func (c *NamespaceRecommendationController) enqueuePodNamespace(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		utilruntime.HandleError(fmt.Errorf("unexpected object type: %T", obj))
+		return
+	}
+	c.queue.Add(pod.Namespace)
+}
+
+func (c *NamespaceRecommendationController) Run(ctx context.Context, workers int) {
+	defer utilruntime.HandleCrash()
+	defer c.queue.ShutDown()
+
+	klog.Info("starting the namespace recommendation controller")
+	defer klog.Info("shutting down the namespace recommendation controller")
+
+	if !cache.WaitForNamedCacheSync("psachecker", ctx.Done(), c.cachesSynced...) {
+		return
+	}
+
+	for i := 0; i < workers; i++ {
+		go wait.UntilWithContext(ctx, c.runWorker, time.Second)
+	}
+
+	<-ctx.Done()
+}
+
+func (c *NamespaceRecommendationController) runWorker(ctx context.Context) {
+	for c.processNextItem(ctx) {
+	}
+}
+
+func (c *NamespaceRecommendationController) processNextItem(ctx context.Context) bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	if err := c.sync(ctx, key.(string)); err != nil {
+		utilruntime.HandleError(fmt.Errorf("failed to sync namespace %q: %w", key, err))
+		c.queue.AddRateLimited(key)
+		return true
+	}
+
+	c.queue.Forget(key)
+	return true
+}
+
+func (c *NamespaceRecommendationController) sync(ctx context.Context, nsName string) error {
+	ns, err := c.nsLister.Get(nsName)
+	if apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if ns.DeletionTimestamp != nil {
+		return nil
+	}
+
+	pods, err := c.podLister.Pods(nsName).List(labels.Everything())
+	if err != nil {
+		return err
+	}
+
+	result := c.adm.InspectNamespace(ctx, ns, pods...)
+
+	if err := c.syncAnnotations(ctx, ns, result); err != nil {
+		return err
+	}
+
+	if c.dynamicClient != nil {
+		return c.syncRecommendation(ctx, result)
+	}
+	return nil
+}
+
+func (c *NamespaceRecommendationController) syncAnnotations(ctx context.Context, ns *corev1.Namespace, result *admission.NamespaceResult) error {
+	blocking := make([]string, 0)
+	for _, w := range result.BlockingWorkloads() {
+		blocking = append(blocking, w.String())
+	}
+	blockingValue := strings.Join(blocking, ",")
+
+	// only update the namespace when the recommendation changed or the evaluation timestamp
+	// got stale, otherwise each update would trigger yet another evaluation
+	evaluatedAt, _ := time.Parse(time.RFC3339, ns.Annotations[EvaluatedAtAnnotation])
+	if ns.Annotations[RecommendedEnforceAnnotation] == string(result.RecommendedLevel) &&
+		ns.Annotations[BlockingWorkloadsAnnotation] == blockingValue &&
+		!c.evaluationExpired(evaluatedAt) {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				RecommendedEnforceAnnotation: string(result.RecommendedLevel),
+				BlockingWorkloadsAnnotation:  blockingValue,
+				EvaluatedAtAnnotation:        time.Now().UTC().Format(time.RFC3339),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.kubeClient.CoreV1().Namespaces().Patch(ctx, ns.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+func (c *NamespaceRecommendationController) evaluationExpired(evaluatedAt time.Time) bool {
+	return time.Since(evaluatedAt) >= c.refreshInterval
+}
@@ -0,0 +1,162 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+
+	"github.com/stlaz/psachecker/pkg/admission"
+)
+
+type ControllerOptions struct {
+	clientConfigOptions *genericclioptions.ConfigFlags
+
+	workers                int
+	resyncPeriod           time.Duration
+	publishRecommendations bool
+
+	leaderElect            bool
+	leaderElectionID       string
+	leaderElectionNS       string
+	leaseDuration          time.Duration
+	renewDeadline          time.Duration
+	leaderElectRetryPeriod time.Duration
+
+	kubeClient    kubernetes.Interface
+	dynamicClient dynamic.Interface
+}
+
+func newControllerOptions() *ControllerOptions {
+	return &ControllerOptions{}
+}
+
+func (o *ControllerOptions) AddFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+
+	flags.IntVar(&o.workers, "workers", 2, "Number of namespaces to evaluate in parallel.")
+	flags.DurationVar(&o.resyncPeriod, "resync-period", 10*time.Minute, "How often all namespaces get re-evaluated even if nothing changed.")
+	flags.BoolVar(&o.publishRecommendations, "publish-recommendations", false, "Also maintain a PodSecurityRecommendation object in each namespace. Requires the CRD from the manifests directory to be installed.")
+
+	flags.BoolVar(&o.leaderElect, "leader-elect", false, "Use leader election so that only a single replica of the controller is active.")
+	flags.StringVar(&o.leaderElectionID, "leader-election-id", "psachecker-controller", "Name of the Lease object used for leader election.")
+	flags.StringVar(&o.leaderElectionNS, "leader-election-namespace", "", "Namespace of the Lease object used for leader election. Defaults to the --namespace value.")
+	flags.DurationVar(&o.leaseDuration, "leader-elect-lease-duration", 15*time.Second, "How long non-leader candidates wait before attempting to acquire the leadership.")
+	flags.DurationVar(&o.renewDeadline, "leader-elect-renew-deadline", 10*time.Second, "How long the leader tries to renew its leadership before giving it up.")
+	flags.DurationVar(&o.leaderElectRetryPeriod, "leader-elect-retry-period", 2*time.Second, "How long the candidates wait between attempts to acquire or renew the leadership.")
+}
+
+func (o *ControllerOptions) Complete(cmd *cobra.Command, clientConfigOptions *genericclioptions.ConfigFlags) error {
+	o.clientConfigOptions = clientConfigOptions
+
+	clientConfig, err := o.clientConfigOptions.ToRawKubeConfigLoader().ClientConfig()
+	if err != nil {
+		return fmt.Errorf("failed to read kube client configuration: %w", err)
+	}
+
+	o.kubeClient, err = kubernetes.NewForConfig(clientConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create kube client: %w", err)
+	}
+
+	if o.publishRecommendations {
+		o.dynamicClient, err = dynamic.NewForConfig(clientConfig)
+		if err != nil {
+			return fmt.Errorf("failed to create dynamic client: %w", err)
+		}
+	}
+
+	if o.leaderElect && len(o.leaderElectionNS) == 0 {
+		o.leaderElectionNS, _, err = o.clientConfigOptions.ToRawKubeConfigLoader().Namespace()
+		if err != nil {
+			return fmt.Errorf("failed to determine the leader election namespace: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (o *ControllerOptions) Validate() []error {
+	errs := []error{}
+
+	if o.kubeClient == nil {
+		errs = append(errs, fmt.Errorf("missing kube client"))
+	}
+
+	if o.workers < 1 {
+		errs = append(errs, fmt.Errorf("--workers must be a positive number"))
+	}
+
+	if o.leaderElect && len(o.leaderElectionNS) == 0 {
+		errs = append(errs, fmt.Errorf("leader election requires a namespace for its Lease object"))
+	}
+
+	return errs
+}
+
+func (o *ControllerOptions) Run(ctx context.Context) error {
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	adm, err := admission.NewParallelAdmission(o.kubeClient)
+	if err != nil {
+		return fmt.Errorf("failed to set up admission: %w", err)
+	}
+
+	run := func(ctx context.Context) {
+		informerFactory := informers.NewSharedInformerFactory(o.kubeClient, o.resyncPeriod)
+		controller := NewNamespaceRecommendationController(o.kubeClient, o.dynamicClient, adm, informerFactory, o.resyncPeriod)
+
+		informerFactory.Start(ctx.Done())
+		controller.Run(ctx, o.workers)
+	}
+
+	if !o.leaderElect {
+		run(ctx)
+		return nil
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("failed to retrieve hostname for the leader election identity: %w", err)
+	}
+
+	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta: metav1.ObjectMeta{
+				Namespace: o.leaderElectionNS,
+				Name:      o.leaderElectionID,
+			},
+			Client: o.kubeClient.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{
+				Identity: hostname,
+			},
+		},
+		LeaseDuration:   o.leaseDuration,
+		RenewDeadline:   o.renewDeadline,
+		RetryPeriod:     o.leaderElectRetryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: run,
+			OnStoppedLeading: func() {
+				klog.Info("leadership lost, stopping")
+				cancel()
+			},
+		},
+	})
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	psapi "k8s.io/pod-security-admission/api"
+
+	"github.com/stlaz/psachecker/pkg/admission"
+	"github.com/stlaz/psachecker/pkg/apis/psachecker/v1alpha1"
+)
+
+// RecommendationName is the name of the PodSecurityRecommendation object maintained in each namespace
+const RecommendationName = "psachecker"
+
+func (c *NamespaceRecommendationController) syncRecommendation(ctx context.Context, result *admission.NamespaceResult) error {
+	client := c.dynamicClient.Resource(v1alpha1.PodSecurityRecommendationsResource).Namespace(result.Name)
+
+	existing, err := client.Get(ctx, RecommendationName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		existing, err = client.Create(ctx, &unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"apiVersion": v1alpha1.SchemeGroupVersion.String(),
+				"kind":       v1alpha1.PodSecurityRecommendationKind,
+				"metadata": map[string]interface{}{
+					"name":      RecommendationName,
+					"namespace": result.Name,
+				},
+			},
+		}, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return err
+	}
+
+	recommendation := &v1alpha1.PodSecurityRecommendation{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(existing.Object, recommendation); err != nil {
+		return fmt.Errorf("failed to decode %s %s/%s: %w", v1alpha1.PodSecurityRecommendationKind, result.Name, RecommendationName, err)
+	}
+
+	newStatus := recommendationStatus(result)
+	newStatus.LastEvaluationTime = recommendation.Status.LastEvaluationTime
+	if equality.Semantic.DeepEqual(recommendation.Status, newStatus) && !c.evaluationExpired(newStatus.LastEvaluationTime.Time) {
+		return nil
+	}
+
+	newStatus.LastEvaluationTime = metav1.Now()
+	recommendation.Status = newStatus
+
+	updated, err := runtime.DefaultUnstructuredConverter.ToUnstructured(recommendation)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.UpdateStatus(ctx, &unstructured.Unstructured{Object: updated}, metav1.UpdateOptions{})
+	return err
+}
+
+func recommendationStatus(result *admission.NamespaceResult) v1alpha1.PodSecurityRecommendationStatus {
+	status := v1alpha1.PodSecurityRecommendationStatus{
+		Enforce: string(result.RecommendedLevel),
+		// audit and warn at the most restrictive level so that the workloads that
+		// keep the namespace from being restricted are still visible
+		Audit: string(psapi.LevelRestricted),
+		Warn:  string(psapi.LevelRestricted),
+	}
+
+	for _, w := range result.Workloads {
+		workload := v1alpha1.WorkloadRecommendation{
+			Kind:         w.Kind,
+			Name:         w.Name,
+			MinimalLevel: string(w.MinimalLevel),
+		}
+		for _, check := range w.FailedChecks {
+			workload.FailedChecks = append(workload.FailedChecks, string(check.ID))
+		}
+		status.Workloads = append(status.Workloads, workload)
+	}
+
+	return status
+}
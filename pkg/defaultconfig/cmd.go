@@ -0,0 +1,34 @@
+package defaultconfig
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+func NewRecommendDefaultsCommand(clientConfigOptions *genericclioptions.ConfigFlags) *cobra.Command {
+	o := newRecommendDefaultsOptions()
+
+	cmd := &cobra.Command{
+		Use:          "recommend-defaults [flags]",
+		Short:        "recommend the cluster-wide default PodSecurity level and print the AdmissionConfiguration enforcing it",
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(c, clientConfigOptions); err != nil {
+				return err
+			}
+			errs := o.Validate()
+			if len(errs) > 0 {
+				return fmt.Errorf("there were errors while setting up the command: %v", errs)
+			}
+
+			return o.Run(context.Background(), c.OutOrStdout(), c.ErrOrStderr())
+		},
+	}
+
+	o.AddFlags(cmd)
+	return cmd
+}
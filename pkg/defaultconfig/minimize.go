@@ -0,0 +1,240 @@
+package defaultconfig
+
+import (
+	"sort"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	podsecurityv1 "k8s.io/pod-security-admission/admission/api/v1"
+	psapi "k8s.io/pod-security-admission/api"
+
+	"github.com/stlaz/psachecker/pkg/admission"
+)
+
+// ChangeKind is the kind of a configuration change that lets workloads run under a stricter default
+type ChangeKind string
+
+const (
+	// ChangeNamespaceLabel sets the enforce label of a namespace to a less restrictive level
+	ChangeNamespaceLabel ChangeKind = "NamespaceLabel"
+	// ChangeNamespaceExemption exempts a namespace whose labels cannot be set from the admission
+	ChangeNamespaceExemption ChangeKind = "NamespaceExemption"
+	// ChangeRuntimeClassExemption exempts the pods of a runtime class from the admission
+	ChangeRuntimeClassExemption ChangeKind = "RuntimeClassExemption"
+)
+
+// Change is a single step of an ExemptionPlan
+type Change struct {
+	Kind ChangeKind `json:"kind"`
+	// Target is the namespace or the runtime class the change applies to
+	Target string `json:"target"`
+	// Level is the enforce level of a ChangeNamespaceLabel
+	Level psapi.Level `json:"level,omitempty"`
+	// Workloads are the "namespace/Kind/name" identifiers of the workloads failing the default
+	// that the change keeps running
+	Workloads []string `json:"workloads"`
+	// Loosened is the number of the workloads the change subjects to a less restrictive level
+	// than the default, including those that would pass the default
+	Loosened int `json:"loosened"`
+}
+
+// ExemptionPlan is the set of changes that keeps all the workloads running under the default
+type ExemptionPlan struct {
+	Default psapi.Level `json:"default"`
+	// Changes are ranked by the number of the workloads they keep running
+	Changes []Change `json:"changes,omitempty"`
+	// Passing is the number of workloads that pass the default without any change
+	Passing int `json:"passing"`
+	// ExemptedRuntimeClass is the number of workloads not subject to the default because their
+	// runtime class already is exempted
+	ExemptedRuntimeClass int `json:"exemptedRuntimeClass"`
+	// Skipped are the namespaces that are not subject to the default, i.e. the ones that
+	// already are exempted or whose labels are managed by the OpenShift label syncer
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+// candidate is a change along with the workloads failing the default it covers
+type candidate struct {
+	change  Change
+	covers  sets.String
+	loosens int
+}
+
+// Minimize finds a small set of namespace labels, namespace exemptions and runtime class exemptions
+// that keeps all the workloads running once the cluster enforces the given default. The namespaces
+// with an enforce label keep it if their workloads pass it, the namespaces whose labels cannot be
+// changed get exempted instead of labeled. It's a greedy set cover: the change that keeps the most
+// of the remaining failing workloads running wins, ties go to the change loosening fewer workloads.
+func Minimize(results []*admission.NamespaceResult, defaultLevel psapi.Level, exemptedNamespaces, exemptedRuntimeClasses, fixedLabels sets.String) *ExemptionPlan {
+	plan := &ExemptionPlan{Default: defaultLevel}
+
+	failing := sets.NewString()
+	// levels are the levels the failing workloads need
+	levels := map[string]psapi.Level{}
+	namespaces := map[string]*candidate{}
+	runtimeClasses := map[string]*candidate{}
+	for _, ns := range results {
+		if exemptedNamespaces.Has(ns.Name) || ns.LabelsSynced {
+			plan.Skipped = append(plan.Skipped, ns.Name)
+			continue
+		}
+
+		level := defaultLevel
+		if _, ok := ns.Labels[psapi.EnforceLevelLabel]; ok {
+			level = ns.EnforceLevel()
+		}
+
+		kind := ChangeNamespaceLabel
+		if fixedLabels.Has(ns.Name) {
+			kind = ChangeNamespaceExemption
+		}
+		nsCandidate := &candidate{
+			change:  Change{Kind: kind, Target: ns.Name},
+			covers:  sets.NewString(),
+			loosens: len(ns.Workloads),
+		}
+		for _, w := range ns.Workloads {
+			if len(w.RuntimeClass) > 0 && exemptedRuntimeClasses.Has(w.RuntimeClass) {
+				plan.ExemptedRuntimeClass++
+				nsCandidate.loosens--
+				continue
+			}
+
+			id := ns.Name + "/" + w.String()
+			if len(w.RuntimeClass) > 0 {
+				rc, ok := runtimeClasses[w.RuntimeClass]
+				if !ok {
+					rc = &candidate{
+						change: Change{Kind: ChangeRuntimeClassExemption, Target: w.RuntimeClass},
+						covers: sets.NewString(),
+					}
+					runtimeClasses[w.RuntimeClass] = rc
+				}
+				rc.loosens++
+				if psapi.CompareLevels(w.MinimalLevel, level) < 0 {
+					rc.covers.Insert(id)
+				}
+			}
+
+			if psapi.CompareLevels(w.MinimalLevel, level) >= 0 {
+				plan.Passing++
+				continue
+			}
+			failing.Insert(id)
+			levels[id] = w.MinimalLevel
+			nsCandidate.covers.Insert(id)
+		}
+
+		if nsCandidate.covers.Len() > 0 {
+			namespaces[ns.Name] = nsCandidate
+		}
+	}
+
+	var candidates []*candidate
+	for _, c := range namespaces {
+		candidates = append(candidates, c)
+	}
+	for _, c := range runtimeClasses {
+		if c.covers.Len() > 0 {
+			candidates = append(candidates, c)
+		}
+	}
+
+	var chosen []*candidate
+	uncovered := failing
+	for uncovered.Len() > 0 {
+		var best *candidate
+		bestCount := 0
+		for _, c := range candidates {
+			count := c.covers.Intersection(uncovered).Len()
+			if count > bestCount || (count == bestCount && count > 0 && better(c, best)) {
+				best, bestCount = c, count
+			}
+		}
+		chosen = append(chosen, best)
+		uncovered = uncovered.Difference(best.covers)
+	}
+
+	plan.Changes = finalize(chosen, levels)
+	sort.Strings(plan.Skipped)
+	return plan
+}
+
+// better breaks the ties between the candidates covering the same number of workloads
+func better(c, other *candidate) bool {
+	if c.loosens != other.loosens {
+		return c.loosens < other.loosens
+	}
+	if c.change.Kind != other.change.Kind {
+		return c.change.Kind < other.change.Kind
+	}
+	return c.change.Target < other.change.Target
+}
+
+// finalize turns the chosen candidates into changes. The workloads are attributed to the runtime
+// class exemptions first so that the namespace changes only need to cover the remaining workloads,
+// the changes left without workloads are dropped.
+func finalize(chosen []*candidate, levels map[string]psapi.Level) []Change {
+	runtimeClassCovered := sets.NewString()
+	for _, c := range chosen {
+		if c.change.Kind == ChangeRuntimeClassExemption {
+			runtimeClassCovered = runtimeClassCovered.Union(c.covers)
+		}
+	}
+
+	var changes []Change
+	for _, c := range chosen {
+		change := c.change
+		change.Loosened = c.loosens
+		covers := c.covers
+		if change.Kind != ChangeRuntimeClassExemption {
+			covers = covers.Difference(runtimeClassCovered)
+		}
+		if change.Kind == ChangeNamespaceLabel {
+			change.Level = psapi.LevelRestricted
+			for id := range covers {
+				if psapi.CompareLevels(levels[id], change.Level) < 0 {
+					change.Level = levels[id]
+				}
+			}
+		}
+		if covers.Len() == 0 {
+			continue
+		}
+		change.Workloads = covers.List()
+		changes = append(changes, change)
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if len(changes[i].Workloads) != len(changes[j].Workloads) {
+			return len(changes[i].Workloads) > len(changes[j].Workloads)
+		}
+		if changes[i].Loosened != changes[j].Loosened {
+			return changes[i].Loosened < changes[j].Loosened
+		}
+		if changes[i].Kind != changes[j].Kind {
+			return changes[i].Kind < changes[j].Kind
+		}
+		return changes[i].Target < changes[j].Target
+	})
+	return changes
+}
+
+// PodSecurityConfiguration returns the configuration of the PodSecurity admission plugin enforcing
+// the default with the exemptions of the plan on top of the already exempted namespaces and runtime classes
+func (p *ExemptionPlan) PodSecurityConfiguration(version string, exemptedNamespaces, exemptedRuntimeClasses sets.String) *podsecurityv1.PodSecurityConfiguration {
+	namespaces := sets.NewString(exemptedNamespaces.UnsortedList()...)
+	runtimeClasses := sets.NewString(exemptedRuntimeClasses.UnsortedList()...)
+	for _, c := range p.Changes {
+		switch c.Kind {
+		case ChangeNamespaceExemption:
+			namespaces.Insert(c.Target)
+		case ChangeRuntimeClassExemption:
+			runtimeClasses.Insert(c.Target)
+		}
+	}
+
+	r := &Recommendation{Level: p.Default, Exempted: namespaces.List()}
+	config := r.PodSecurityConfiguration(version)
+	config.Exemptions.RuntimeClasses = runtimeClasses.List()
+	return config
+}
@@ -0,0 +1,115 @@
+package defaultconfig
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	psapi "k8s.io/pod-security-admission/api"
+
+	"github.com/stlaz/psachecker/pkg/admission"
+)
+
+func workload(name string, level psapi.Level, runtimeClass string) admission.WorkloadResult {
+	return admission.WorkloadResult{Kind: "Deployment", Name: name, MinimalLevel: level, RuntimeClass: runtimeClass}
+}
+
+func namespaceResult(name string, labels map[string]string, workloads ...admission.WorkloadResult) *admission.NamespaceResult {
+	return &admission.NamespaceResult{Name: name, Labels: labels, Workloads: workloads}
+}
+
+func TestMinimize(t *testing.T) {
+	tests := map[string]struct {
+		results                []*admission.NamespaceResult
+		exemptedNamespaces     []string
+		exemptedRuntimeClasses []string
+		fixedLabels            []string
+		expected               *ExemptionPlan
+	}{
+		"namespaces with fixed labels get exempted": {
+			results: []*admission.NamespaceResult{
+				namespaceResult("app", nil, workload("web", psapi.LevelBaseline, ""), workload("api", psapi.LevelRestricted, "")),
+				namespaceResult("infra", nil, workload("agent", psapi.LevelPrivileged, "")),
+			},
+			fixedLabels: []string{"infra"},
+			expected: &ExemptionPlan{
+				Default: psapi.LevelRestricted,
+				Changes: []Change{
+					{Kind: ChangeNamespaceExemption, Target: "infra", Workloads: []string{"infra/Deployment/agent"}, Loosened: 1},
+					{Kind: ChangeNamespaceLabel, Target: "app", Level: psapi.LevelBaseline, Workloads: []string{"app/Deployment/web"}, Loosened: 2},
+				},
+				Passing: 1,
+			},
+		},
+		"runtime class spanning namespaces": {
+			results: []*admission.NamespaceResult{
+				namespaceResult("a", nil, workload("vm", psapi.LevelPrivileged, "kata"), workload("web", psapi.LevelRestricted, "")),
+				namespaceResult("b", nil, workload("vm", psapi.LevelPrivileged, "kata"), workload("web", psapi.LevelRestricted, "")),
+			},
+			expected: &ExemptionPlan{
+				Default: psapi.LevelRestricted,
+				Changes: []Change{
+					{Kind: ChangeRuntimeClassExemption, Target: "kata", Workloads: []string{"a/Deployment/vm", "b/Deployment/vm"}, Loosened: 2},
+				},
+				Passing: 2,
+			},
+		},
+		"labeled namespace stricter than the default": {
+			results: []*admission.NamespaceResult{
+				namespaceResult("strict", map[string]string{psapi.EnforceLevelLabel: string(psapi.LevelRestricted)},
+					workload("web", psapi.LevelBaseline, ""), workload("api", psapi.LevelRestricted, "")),
+				namespaceResult("app", nil, workload("web", psapi.LevelBaseline, "")),
+			},
+			expected: &ExemptionPlan{
+				Default: psapi.LevelBaseline,
+				Changes: []Change{
+					{Kind: ChangeNamespaceLabel, Target: "strict", Level: psapi.LevelBaseline, Workloads: []string{"strict/Deployment/web"}, Loosened: 2},
+				},
+				Passing: 2,
+			},
+		},
+		"workloads of exempted runtime classes and namespaces": {
+			results: []*admission.NamespaceResult{
+				namespaceResult("app", nil, workload("vm", psapi.LevelPrivileged, "kata"), workload("web", psapi.LevelBaseline, "")),
+				namespaceResult("kube-system", nil, workload("proxy", psapi.LevelPrivileged, "")),
+			},
+			exemptedNamespaces:     []string{"kube-system"},
+			exemptedRuntimeClasses: []string{"kata"},
+			expected: &ExemptionPlan{
+				Default: psapi.LevelRestricted,
+				Changes: []Change{
+					{Kind: ChangeNamespaceLabel, Target: "app", Level: psapi.LevelBaseline, Workloads: []string{"app/Deployment/web"}, Loosened: 1},
+				},
+				ExemptedRuntimeClass: 1,
+				Skipped:              []string{"kube-system"},
+			},
+		},
+		"ties are ranked by the target": {
+			results: []*admission.NamespaceResult{
+				namespaceResult("c", nil, workload("web", psapi.LevelBaseline, "")),
+				namespaceResult("a", nil, workload("web", psapi.LevelBaseline, "")),
+				namespaceResult("b", nil, workload("web", psapi.LevelPrivileged, "")),
+			},
+			expected: &ExemptionPlan{
+				Default: psapi.LevelRestricted,
+				Changes: []Change{
+					{Kind: ChangeNamespaceLabel, Target: "a", Level: psapi.LevelBaseline, Workloads: []string{"a/Deployment/web"}, Loosened: 1},
+					{Kind: ChangeNamespaceLabel, Target: "b", Level: psapi.LevelPrivileged, Workloads: []string{"b/Deployment/web"}, Loosened: 1},
+					{Kind: ChangeNamespaceLabel, Target: "c", Level: psapi.LevelBaseline, Workloads: []string{"c/Deployment/web"}, Loosened: 1},
+				},
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			// the candidates are kept in maps, the plan must not depend on their iteration order
+			for i := 0; i < 10; i++ {
+				plan := Minimize(tt.results, tt.expected.Default, sets.NewString(tt.exemptedNamespaces...), sets.NewString(tt.exemptedRuntimeClasses...), sets.NewString(tt.fixedLabels...))
+				if !reflect.DeepEqual(tt.expected, plan) {
+					t.Fatalf("expected plan %+v, got %+v", tt.expected, plan)
+				}
+			}
+		})
+	}
+}
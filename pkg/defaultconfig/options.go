@@ -0,0 +1,108 @@
+package defaultconfig
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	psapi "k8s.io/pod-security-admission/api"
+	"sigs.k8s.io/yaml"
+
+	"github.com/stlaz/psachecker/pkg/clusterinspect"
+)
+
+type RecommendDefaultsOptions struct {
+	inspectOptions *clusterinspect.ClusterInspectOptions
+
+	labeledNamespaces  []string
+	exemptedNamespaces []string
+	keepLabels         bool
+	version            string
+}
+
+func newRecommendDefaultsOptions() *RecommendDefaultsOptions {
+	return &RecommendDefaultsOptions{
+		inspectOptions: clusterinspect.NewClusterInspectOptions(),
+		keepLabels:     true,
+		version:        psapi.VersionLatest,
+	}
+}
+
+func (o *RecommendDefaultsOptions) AddFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+
+	flags.StringSliceVar(&o.labeledNamespaces, "labeled-namespaces", nil, "Comma-separated list of namespaces that will carry an explicit enforce label, they do not limit the default level.")
+	flags.StringSliceVar(&o.exemptedNamespaces, "exempt-namespaces", nil, "Comma-separated list of namespaces to exempt from the PodSecurity admission in the configuration.")
+	flags.BoolVar(&o.keepLabels, "keep-labels", o.keepLabels, "Consider the namespaces that already have an enforce label as labeled. With --keep-labels=false, only the namespaces from --labeled-namespaces are.")
+	flags.StringVar(&o.version, "version", o.version, "The PodSecurity version to pin the defaults to.")
+
+	o.inspectOptions.AddInputFlags(cmd)
+	o.inspectOptions.AddScanFlags(cmd)
+}
+
+func (o *RecommendDefaultsOptions) Complete(cmd *cobra.Command, clientConfigOptions *genericclioptions.ConfigFlags) error {
+	return o.inspectOptions.Complete(cmd, clientConfigOptions)
+}
+
+func (o *RecommendDefaultsOptions) Validate() []error {
+	errs := []error{}
+
+	if _, err := psapi.ParseVersion(o.version); err != nil {
+		errs = append(errs, fmt.Errorf("invalid --version: %w", err))
+	}
+
+	if both := sets.NewString(o.labeledNamespaces...).Intersection(sets.NewString(o.exemptedNamespaces...)); both.Len() > 0 {
+		errs = append(errs, fmt.Errorf("namespaces cannot be both labeled and exempted: %s", strings.Join(both.List(), ", ")))
+	}
+
+	errs = append(errs, o.inspectOptions.Validate()...)
+
+	return errs
+}
+
+func (o *RecommendDefaultsOptions) Run(ctx context.Context, out, errOut io.Writer) error {
+	results, err := o.inspectOptions.Inspect(ctx)
+	if err != nil {
+		return err
+	}
+
+	r := Recommend(results, sets.NewString(o.labeledNamespaces...), sets.NewString(o.exemptedNamespaces...), o.keepLabels)
+	printRecommendation(errOut, r)
+
+	data, err := yaml.Marshal(AdmissionConfiguration(r.PodSecurityConfiguration(o.version)))
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(data)
+	return err
+}
+
+func printRecommendation(out io.Writer, r *Recommendation) {
+	fmt.Fprintf(out, "recommended default level: %s\n", r.Level)
+	if len(r.Limiting) > 0 {
+		fmt.Fprintf(out, "limited by the workloads of: %s\n", strings.Join(r.Limiting, ", "))
+	}
+	if len(r.Exempted) > 0 {
+		fmt.Fprintf(out, "exempted namespaces: %s\n", strings.Join(r.Exempted, ", "))
+	}
+
+	if len(r.Labels) > 0 {
+		fmt.Fprintln(out, "enforce labels of the labeled namespaces:")
+	}
+	for _, l := range r.Labels {
+		switch {
+		case len(l.Current) == 0:
+			fmt.Fprintf(out, "\t%s: set %s\n", l.Namespace, l.Level)
+		case psapi.CompareLevels(l.Current, l.Level) > 0:
+			fmt.Fprintf(out, "\t%s: %s is too restrictive, set %s\n", l.Namespace, l.Current, l.Level)
+		default:
+			fmt.Fprintf(out, "\t%s: keep %s\n", l.Namespace, l.Current)
+		}
+	}
+	fmt.Fprintln(out)
+}
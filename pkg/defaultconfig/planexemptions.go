@@ -0,0 +1,167 @@
+package defaultconfig
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	psapi "k8s.io/pod-security-admission/api"
+	"sigs.k8s.io/yaml"
+
+	"github.com/stlaz/psachecker/pkg/clusterinspect"
+)
+
+// NewPlanExemptionsCommand plans the changes needed to keep the workloads running under a stricter default
+func NewPlanExemptionsCommand(clientConfigOptions *genericclioptions.ConfigFlags) *cobra.Command {
+	o := newPlanExemptionsOptions()
+
+	cmd := &cobra.Command{
+		Use:          "plan-exemptions --default <level> [flags]",
+		Short:        "find the fewest namespace labels and exemptions that keep the workloads running under a stricter default level",
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(c, clientConfigOptions); err != nil {
+				return err
+			}
+			errs := o.Validate()
+			if len(errs) > 0 {
+				return fmt.Errorf("there were errors while setting up the command: %v", errs)
+			}
+
+			return o.Run(context.Background(), c.OutOrStdout())
+		},
+	}
+
+	o.AddFlags(cmd)
+	return cmd
+}
+
+type PlanExemptionsOptions struct {
+	inspectOptions *clusterinspect.ClusterInspectOptions
+
+	defaultLevel           string
+	exemptedNamespaces     []string
+	exemptedRuntimeClasses []string
+	fixedLabels            []string
+	version                string
+	output                 string
+}
+
+func newPlanExemptionsOptions() *PlanExemptionsOptions {
+	return &PlanExemptionsOptions{
+		inspectOptions: clusterinspect.NewClusterInspectOptions(),
+		defaultLevel:   string(psapi.LevelRestricted),
+		version:        psapi.VersionLatest,
+	}
+}
+
+func (o *PlanExemptionsOptions) AddFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+
+	flags.StringVar(&o.defaultLevel, "default", o.defaultLevel, "The default enforce level the cluster is going to use.")
+	flags.StringSliceVar(&o.exemptedNamespaces, "exempt-namespaces", nil, "Comma-separated list of namespaces that are already exempted from the PodSecurity admission.")
+	flags.StringSliceVar(&o.exemptedRuntimeClasses, "exempt-runtime-classes", nil, "Comma-separated list of runtime classes that are already exempted from the PodSecurity admission.")
+	flags.StringSliceVar(&o.fixedLabels, "fixed-label-namespaces", nil, "Comma-separated list of namespaces whose labels cannot be changed, e.g. because they are managed by a GitOps tool. They get exempted rather than labeled.")
+	flags.StringVar(&o.version, "version", o.version, "The PodSecurity version to pin the defaults to in the AdmissionConfiguration.")
+	flags.StringVarP(&o.output, "output", "o", "", "Output format. One of: (yaml, admission-config). \"yaml\" prints the plan, \"admission-config\" prints the AdmissionConfiguration with the exemptions of the plan.")
+
+	o.inspectOptions.AddInputFlags(cmd)
+	o.inspectOptions.AddScanFlags(cmd)
+}
+
+func (o *PlanExemptionsOptions) Complete(cmd *cobra.Command, clientConfigOptions *genericclioptions.ConfigFlags) error {
+	return o.inspectOptions.Complete(cmd, clientConfigOptions)
+}
+
+func (o *PlanExemptionsOptions) Validate() []error {
+	errs := []error{}
+
+	if _, err := psapi.ParseLevel(o.defaultLevel); err != nil {
+		errs = append(errs, fmt.Errorf("invalid --default: %w", err))
+	}
+
+	if _, err := psapi.ParseVersion(o.version); err != nil {
+		errs = append(errs, fmt.Errorf("invalid --version: %w", err))
+	}
+
+	switch o.output {
+	case "", "yaml", "admission-config":
+	default:
+		errs = append(errs, fmt.Errorf("unsupported output format %q", o.output))
+	}
+
+	errs = append(errs, o.inspectOptions.Validate()...)
+
+	return errs
+}
+
+func (o *PlanExemptionsOptions) Run(ctx context.Context, out io.Writer) error {
+	results, err := o.inspectOptions.Inspect(ctx)
+	if err != nil {
+		return err
+	}
+
+	exemptedNamespaces := sets.NewString(o.exemptedNamespaces...)
+	exemptedRuntimeClasses := sets.NewString(o.exemptedRuntimeClasses...)
+	plan := Minimize(results, psapi.Level(o.defaultLevel), exemptedNamespaces, exemptedRuntimeClasses, sets.NewString(o.fixedLabels...))
+
+	var data []byte
+	switch o.output {
+	case "":
+		printPlan(out, plan)
+		return nil
+	case "yaml":
+		data, err = yaml.Marshal(plan)
+	case "admission-config":
+		data, err = yaml.Marshal(AdmissionConfiguration(plan.PodSecurityConfiguration(o.version, exemptedNamespaces, exemptedRuntimeClasses)))
+	}
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(data)
+	return err
+}
+
+func printPlan(out io.Writer, plan *ExemptionPlan) {
+	fmt.Fprintf(out, "default level: %s\n", plan.Default)
+	fmt.Fprintf(out, "workloads passing without changes: %d\n", plan.Passing)
+	if plan.ExemptedRuntimeClass > 0 {
+		fmt.Fprintf(out, "workloads of exempted runtime classes: %d\n", plan.ExemptedRuntimeClass)
+	}
+	if len(plan.Skipped) > 0 {
+		fmt.Fprintf(out, "namespaces not subject to the default: %s\n", strings.Join(plan.Skipped, ", "))
+	}
+	if len(plan.Changes) == 0 {
+		fmt.Fprintln(out, "no changes needed")
+		return
+	}
+
+	fmt.Fprintln(out, "changes ranked by the number of workloads they keep running:")
+	for i, c := range plan.Changes {
+		var change string
+		switch c.Kind {
+		case ChangeNamespaceLabel:
+			change = fmt.Sprintf("label namespace %s with %s=%s", c.Target, psapi.EnforceLevelLabel, c.Level)
+		case ChangeNamespaceExemption:
+			change = fmt.Sprintf("exempt namespace %s", c.Target)
+		case ChangeRuntimeClassExemption:
+			change = fmt.Sprintf("exempt runtime class %s", c.Target)
+		}
+		fmt.Fprintf(out, "%d. %s: keeps %s running, loosens %d\n", i+1, change, workloadCount(len(c.Workloads)), c.Loosened)
+		for _, w := range c.Workloads {
+			fmt.Fprintf(out, "\t%s\n", w)
+		}
+	}
+}
+
+func workloadCount(n int) string {
+	if n == 1 {
+		return "1 workload"
+	}
+	return fmt.Sprintf("%d workloads", n)
+}
@@ -0,0 +1,111 @@
+package defaultconfig
+
+import (
+	"sort"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	podsecurityv1 "k8s.io/pod-security-admission/admission/api/v1"
+	psapi "k8s.io/pod-security-admission/api"
+
+	"github.com/stlaz/psachecker/pkg/admission"
+)
+
+// NamespaceLabel is the enforce label a namespace needs so that its workloads keep running
+// with the recommended default
+type NamespaceLabel struct {
+	Namespace string
+	Level     psapi.Level
+	// Current is the level of the enforce label the namespace already has, if any
+	Current psapi.Level
+}
+
+// Recommendation is the most restrictive cluster-wide default level that keeps all the workloads running
+type Recommendation struct {
+	Level psapi.Level
+	// Limiting are the namespaces that keep the default from being more restrictive
+	Limiting []string
+	// Labels are the enforce labels of the namespaces that are not subject to the default
+	Labels []NamespaceLabel
+	// Exempted are the namespaces exempted from the PodSecurity admission
+	Exempted []string
+}
+
+// Recommend computes the default level from the namespaces that neither carry an explicit enforce
+// label nor are exempted. The namespaces with an enforce label keep it while the labeled ones
+// without the label get their recommended level. The namespaces whose labels are managed by the
+// OpenShift label syncer are considered labeled.
+func Recommend(results []*admission.NamespaceResult, labeled, exempted sets.String, keepLabels bool) *Recommendation {
+	r := &Recommendation{Level: psapi.LevelRestricted}
+	byLevel := map[psapi.Level][]string{}
+	for _, ns := range results {
+		if exempted.Has(ns.Name) {
+			continue
+		}
+
+		_, hasLabel := ns.Labels[psapi.EnforceLevelLabel]
+		if labeled.Has(ns.Name) || ns.LabelsSynced || (keepLabels && hasLabel) {
+			label := NamespaceLabel{Namespace: ns.Name, Level: ns.RecommendedLevel}
+			if hasLabel {
+				label.Current = ns.EnforceLevel()
+			}
+			r.Labels = append(r.Labels, label)
+			continue
+		}
+
+		byLevel[ns.RecommendedLevel] = append(byLevel[ns.RecommendedLevel], ns.Name)
+		if psapi.CompareLevels(ns.RecommendedLevel, r.Level) < 0 {
+			r.Level = ns.RecommendedLevel
+		}
+	}
+
+	if r.Level != psapi.LevelRestricted {
+		r.Limiting = byLevel[r.Level]
+	}
+	sort.Strings(r.Limiting)
+	// all the requested namespaces get exempted, including the ones that were not inspected
+	// with -n or that do not exist yet
+	r.Exempted = exempted.List()
+	sort.Slice(r.Labels, func(i, j int) bool {
+		return r.Labels[i].Namespace < r.Labels[j].Namespace
+	})
+	return r
+}
+
+// PodSecurityConfiguration returns the configuration of the PodSecurity admission plugin enforcing
+// the recommended default. Audit and warn use the restricted level so that the violations of the
+// most restrictive level get surfaced everywhere.
+func (r *Recommendation) PodSecurityConfiguration(version string) *podsecurityv1.PodSecurityConfiguration {
+	return &podsecurityv1.PodSecurityConfiguration{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: podsecurityv1.SchemeGroupVersion.String(),
+			Kind:       "PodSecurityConfiguration",
+		},
+		Defaults: podsecurityv1.PodSecurityDefaults{
+			Enforce:        string(r.Level),
+			EnforceVersion: version,
+			Audit:          string(psapi.LevelRestricted),
+			AuditVersion:   version,
+			Warn:           string(psapi.LevelRestricted),
+			WarnVersion:    version,
+		},
+		Exemptions: podsecurityv1.PodSecurityExemptions{
+			Namespaces: r.Exempted,
+		},
+	}
+}
+
+// AdmissionConfiguration wraps the PodSecurity configuration in the configuration of the
+// admission plugins the kube-apiserver reads from its --admission-control-config-file
+func AdmissionConfiguration(config *podsecurityv1.PodSecurityConfiguration) map[string]interface{} {
+	return map[string]interface{}{
+		"apiVersion": "apiserver.config.k8s.io/v1",
+		"kind":       "AdmissionConfiguration",
+		"plugins": []interface{}{
+			map[string]interface{}{
+				"name":          "PodSecurity",
+				"configuration": config,
+			},
+		},
+	}
+}
@@ -0,0 +1,140 @@
+package defaultconfig
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	podsecurityapi "k8s.io/pod-security-admission/admission/api"
+	"k8s.io/pod-security-admission/admission/api/scheme"
+	"k8s.io/pod-security-admission/admission/api/validation"
+	psapi "k8s.io/pod-security-admission/api"
+	"sigs.k8s.io/yaml"
+
+	"github.com/stlaz/psachecker/pkg/admission"
+)
+
+func recommended(name string, level psapi.Level, labels map[string]string) *admission.NamespaceResult {
+	return &admission.NamespaceResult{Name: name, Labels: labels, RecommendedLevel: level}
+}
+
+func TestRecommend(t *testing.T) {
+	baselineLabel := map[string]string{psapi.EnforceLevelLabel: string(psapi.LevelBaseline)}
+	tests := map[string]struct {
+		results    []*admission.NamespaceResult
+		labeled    []string
+		exempted   []string
+		keepLabels bool
+		expected   *Recommendation
+	}{
+		"empty cluster": {
+			expected: &Recommendation{Level: psapi.LevelRestricted, Exempted: []string{}},
+		},
+		"enforce labels are ignored without --keep-labels": {
+			results: []*admission.NamespaceResult{
+				recommended("app", psapi.LevelRestricted, baselineLabel),
+				recommended("infra", psapi.LevelBaseline, nil),
+			},
+			expected: &Recommendation{Level: psapi.LevelBaseline, Limiting: []string{"infra"}, Exempted: []string{}},
+		},
+		"--keep-labels": {
+			results: []*admission.NamespaceResult{
+				recommended("app", psapi.LevelPrivileged, baselineLabel),
+				recommended("web", psapi.LevelRestricted, nil),
+			},
+			keepLabels: true,
+			expected: &Recommendation{
+				Level:    psapi.LevelRestricted,
+				Labels:   []NamespaceLabel{{Namespace: "app", Level: psapi.LevelPrivileged, Current: psapi.LevelBaseline}},
+				Exempted: []string{},
+			},
+		},
+		"labeled and synced namespaces": {
+			results: []*admission.NamespaceResult{
+				{Name: "synced", RecommendedLevel: psapi.LevelPrivileged, LabelsSynced: true},
+				recommended("infra", psapi.LevelPrivileged, nil),
+				recommended("web", psapi.LevelBaseline, nil),
+			},
+			labeled: []string{"infra"},
+			expected: &Recommendation{
+				Level:    psapi.LevelBaseline,
+				Limiting: []string{"web"},
+				Labels: []NamespaceLabel{
+					{Namespace: "infra", Level: psapi.LevelPrivileged},
+					{Namespace: "synced", Level: psapi.LevelPrivileged},
+				},
+				Exempted: []string{},
+			},
+		},
+		"exempted namespaces that were not inspected": {
+			results: []*admission.NamespaceResult{
+				recommended("kube-system", psapi.LevelPrivileged, nil),
+				recommended("web", psapi.LevelRestricted, nil),
+			},
+			exempted: []string{"kube-system", "not-created-yet"},
+			expected: &Recommendation{Level: psapi.LevelRestricted, Exempted: []string{"kube-system", "not-created-yet"}},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := Recommend(tt.results, sets.NewString(tt.labeled...), sets.NewString(tt.exempted...), tt.keepLabels)
+			if !reflect.DeepEqual(tt.expected, r) {
+				t.Errorf("expected recommendation %+v, got %+v", tt.expected, r)
+			}
+		})
+	}
+}
+
+func TestAdmissionConfigurationDecodes(t *testing.T) {
+	r := &Recommendation{Level: psapi.LevelBaseline, Exempted: []string{"kube-system", "monitoring"}}
+	data, err := yaml.Marshal(AdmissionConfiguration(r.PodSecurityConfiguration("v1.27")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var admissionConfig struct {
+		APIVersion string `json:"apiVersion"`
+		Kind       string `json:"kind"`
+		Plugins    []struct {
+			Name          string          `json:"name"`
+			Configuration json.RawMessage `json:"configuration"`
+		} `json:"plugins"`
+	}
+	if err := yaml.UnmarshalStrict(data, &admissionConfig); err != nil {
+		t.Fatal(err)
+	}
+	if admissionConfig.APIVersion != "apiserver.config.k8s.io/v1" || admissionConfig.Kind != "AdmissionConfiguration" {
+		t.Errorf("unexpected admission configuration type %s/%s", admissionConfig.APIVersion, admissionConfig.Kind)
+	}
+	if len(admissionConfig.Plugins) != 1 || admissionConfig.Plugins[0].Name != "PodSecurity" {
+		t.Fatalf("expected the PodSecurity plugin configuration, got %s", data)
+	}
+
+	// the strict decoder of the PodSecurity scheme rejects unknown fields
+	obj, err := runtime.Decode(scheme.Codecs.UniversalDecoder(), admissionConfig.Plugins[0].Configuration)
+	if err != nil {
+		t.Fatalf("failed to decode the PodSecurity configuration: %v", err)
+	}
+	config, ok := obj.(*podsecurityapi.PodSecurityConfiguration)
+	if !ok {
+		t.Fatalf("expected a PodSecurityConfiguration, got %T", obj)
+	}
+	if errs := validation.ValidatePodSecurityConfiguration(config); len(errs) > 0 {
+		t.Errorf("invalid PodSecurity configuration: %v", errs.ToAggregate())
+	}
+
+	expected := podsecurityapi.PodSecurityDefaults{
+		Enforce: string(psapi.LevelBaseline), EnforceVersion: "v1.27",
+		Audit: string(psapi.LevelRestricted), AuditVersion: "v1.27",
+		Warn: string(psapi.LevelRestricted), WarnVersion: "v1.27",
+	}
+	if config.Defaults != expected {
+		t.Errorf("expected defaults %+v, got %+v", expected, config.Defaults)
+	}
+	if !reflect.DeepEqual(config.Exemptions.Namespaces, r.Exempted) {
+		t.Errorf("expected exempted namespaces %v, got %v", r.Exempted, config.Exemptions.Namespaces)
+	}
+}
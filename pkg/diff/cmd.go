@@ -0,0 +1,29 @@
+package diff
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func NewDiffCommand() *cobra.Command {
+	o := newDiffOptions()
+
+	cmd := &cobra.Command{
+		Use:          "diff <old.json> <new.json>",
+		Short:        "compare the results of two inspect-cluster runs",
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(2),
+		RunE: func(c *cobra.Command, args []string) error {
+			o.Complete(args)
+			errs := o.Validate()
+			if len(errs) > 0 {
+				return fmt.Errorf("there were errors while setting up the command: %v", errs)
+			}
+
+			return o.Run(c.OutOrStdout())
+		},
+	}
+
+	return cmd
+}
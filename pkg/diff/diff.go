@@ -0,0 +1,108 @@
+package diff
+
+import (
+	"sort"
+
+	psapi "k8s.io/pod-security-admission/api"
+
+	"github.com/stlaz/psachecker/pkg/admission"
+	"github.com/stlaz/psachecker/pkg/snapshot"
+)
+
+// NamespaceDiff describes how a single namespace changed between two inspections
+type NamespaceDiff struct {
+	Name string
+
+	Added, Removed bool
+
+	// OldLevel and NewLevel are set when the recommended level changed
+	OldLevel, NewLevel psapi.Level
+
+	// ChangedLabels maps the PodSecurity labels that changed to their old and new values
+	ChangedLabels map[string][2]string
+
+	// NewViolations are the workloads that either appeared or require a less restrictive
+	// level than before and that cannot run at the restricted level
+	NewViolations []admission.WorkloadResult
+}
+
+// Empty returns true if nothing of interest changed in the namespace
+func (d *NamespaceDiff) Empty() bool {
+	return !d.Added && !d.Removed &&
+		d.OldLevel == d.NewLevel &&
+		len(d.ChangedLabels) == 0 &&
+		len(d.NewViolations) == 0
+}
+
+// Snapshots compares the namespaces of two inspections and returns the namespaces
+// that changed, sorted by their name
+func Snapshots(oldSnap, newSnap *snapshot.Snapshot) []NamespaceDiff {
+	var diffs []NamespaceDiff
+
+	for _, newNS := range newSnap.Namespaces {
+		d := Namespaces(oldSnap.Namespace(newNS.Name), newNS)
+		if !d.Empty() {
+			diffs = append(diffs, d)
+		}
+	}
+	for _, oldNS := range oldSnap.Namespaces {
+		if newSnap.Namespace(oldNS.Name) == nil {
+			diffs = append(diffs, NamespaceDiff{Name: oldNS.Name, Removed: true})
+		}
+	}
+
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Name < diffs[j].Name })
+	return diffs
+}
+
+// Namespaces compares two results of the same namespace, oldNS is nil if the namespace
+// did not exist previously
+func Namespaces(oldNS, newNS *admission.NamespaceResult) NamespaceDiff {
+	d := NamespaceDiff{Name: newNS.Name}
+	if oldNS == nil {
+		d.Added = true
+		oldNS = &admission.NamespaceResult{Name: newNS.Name}
+	} else if oldNS.RecommendedLevel != newNS.RecommendedLevel {
+		d.OldLevel, d.NewLevel = oldNS.RecommendedLevel, newNS.RecommendedLevel
+	}
+
+	for _, k := range labelKeys(oldNS.Labels, newNS.Labels) {
+		if oldNS.Labels[k] != newNS.Labels[k] {
+			if d.ChangedLabels == nil {
+				d.ChangedLabels = map[string][2]string{}
+			}
+			d.ChangedLabels[k] = [2]string{oldNS.Labels[k], newNS.Labels[k]}
+		}
+	}
+
+	// the workloads are matched by their top-level owners so that the rollouts of the workloads
+	// of the results stored with their ReplicaSets do not show up as new violations
+	oldWorkloads := map[string]admission.WorkloadResult{}
+	for _, w := range oldNS.Workloads {
+		oldWorkloads[w.OwnerKey()] = w
+	}
+	for _, w := range newNS.WorkloadsFailingLevel(psapi.LevelRestricted) {
+		if oldW, ok := oldWorkloads[w.OwnerKey()]; ok && psapi.CompareLevels(w.MinimalLevel, oldW.MinimalLevel) >= 0 {
+			continue
+		}
+		d.NewViolations = append(d.NewViolations, w)
+	}
+
+	return d
+}
+
+func labelKeys(maps ...map[string]string) []string {
+	keySet := map[string]bool{}
+	for _, m := range maps {
+		for k := range m {
+			keySet[k] = true
+		}
+	}
+
+	keys := make([]string, 0, len(keySet))
+	for k := range keySet {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
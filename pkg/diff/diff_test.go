@@ -0,0 +1,48 @@
+package diff
+
+import (
+	"testing"
+	"time"
+
+	psapi "k8s.io/pod-security-admission/api"
+
+	"github.com/stlaz/psachecker/pkg/admission"
+	"github.com/stlaz/psachecker/pkg/snapshot"
+)
+
+func snapshotWithWorkloads(workloads ...admission.WorkloadResult) *snapshot.Snapshot {
+	return snapshot.New(time.Now(), []*admission.NamespaceResult{{
+		Name:             "app",
+		RecommendedLevel: psapi.LevelBaseline,
+		Workloads:        workloads,
+	}})
+}
+
+func webRevision(hash string, level psapi.Level) admission.WorkloadResult {
+	return admission.WorkloadResult{
+		Kind:         "ReplicaSet",
+		Namespace:    "app",
+		Name:         "web-" + hash,
+		MinimalLevel: level,
+		PodLabels:    map[string]string{"app": "web", "pod-template-hash": hash},
+	}
+}
+
+func TestSnapshotsRollout(t *testing.T) {
+	// the results stored before the workloads were keyed by their top-level owners
+	oldSnap := snapshotWithWorkloads(webRevision("5d4f8b9c6", psapi.LevelBaseline))
+
+	if diffs := Snapshots(oldSnap, snapshotWithWorkloads(webRevision("7f9c6d8b5", psapi.LevelBaseline))); len(diffs) != 0 {
+		t.Errorf("expected no changes after a rollout, got %+v", diffs)
+	}
+	if diffs := Snapshots(oldSnap, snapshotWithWorkloads(admission.WorkloadResult{
+		Kind: "Deployment", Namespace: "app", Name: "web", MinimalLevel: psapi.LevelBaseline,
+	})); len(diffs) != 0 {
+		t.Errorf("expected no changes for the workload keyed by its Deployment, got %+v", diffs)
+	}
+
+	diffs := Snapshots(oldSnap, snapshotWithWorkloads(webRevision("7f9c6d8b5", psapi.LevelPrivileged)))
+	if len(diffs) != 1 || len(diffs[0].NewViolations) != 1 {
+		t.Errorf("expected a new violation of the rolled out revision requiring the privileged level, got %+v", diffs)
+	}
+}
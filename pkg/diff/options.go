@@ -0,0 +1,82 @@
+package diff
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/stlaz/psachecker/pkg/snapshot"
+)
+
+type DiffOptions struct {
+	oldPath, newPath string
+}
+
+func newDiffOptions() *DiffOptions {
+	return &DiffOptions{}
+}
+
+func (o *DiffOptions) Complete(args []string) {
+	o.oldPath, o.newPath = args[0], args[1]
+}
+
+func (o *DiffOptions) Validate() []error {
+	errs := []error{}
+
+	if o.oldPath == o.newPath {
+		errs = append(errs, fmt.Errorf("cannot compare %q with itself", o.oldPath))
+	}
+
+	return errs
+}
+
+func (o *DiffOptions) Run(out io.Writer) error {
+	oldSnap, err := snapshot.ReadFile(o.oldPath)
+	if err != nil {
+		return err
+	}
+	newSnap, err := snapshot.ReadFile(o.newPath)
+	if err != nil {
+		return err
+	}
+
+	diffs := Snapshots(oldSnap, newSnap)
+	if len(diffs) == 0 {
+		fmt.Fprintln(out, "no changes")
+		return nil
+	}
+
+	for _, d := range diffs {
+		printNamespaceDiff(out, &d)
+	}
+	return nil
+}
+
+func printNamespaceDiff(out io.Writer, d *NamespaceDiff) {
+	switch {
+	case d.Removed:
+		fmt.Fprintf(out, "%s: removed\n", d.Name)
+		return
+	case d.Added:
+		fmt.Fprintf(out, "%s: added\n", d.Name)
+	default:
+		fmt.Fprintf(out, "%s:\n", d.Name)
+	}
+
+	if d.OldLevel != d.NewLevel {
+		fmt.Fprintf(out, "  recommended level: %s -> %s\n", d.OldLevel, d.NewLevel)
+	}
+
+	labels := make([]string, 0, len(d.ChangedLabels))
+	for k := range d.ChangedLabels {
+		labels = append(labels, k)
+	}
+	sort.Strings(labels)
+	for _, k := range labels {
+		fmt.Fprintf(out, "  label %s: %q -> %q\n", k, d.ChangedLabels[k][0], d.ChangedLabels[k][1])
+	}
+
+	for _, w := range d.NewViolations {
+		fmt.Fprintf(out, "  new violation: %s requires %s\n", w.String(), w.MinimalLevel)
+	}
+}
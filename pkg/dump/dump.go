@@ -0,0 +1,328 @@
+package dump
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/klog/v2"
+)
+
+// NewClientset returns an in-memory clientset serving the objects of the dump at the path
+// so that the inspections can run without access to the cluster.
+func NewClientset(path string) (kubernetes.Interface, error) {
+	objs, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return fake.NewSimpleClientset(objs...), nil
+}
+
+// Load reads the objects of a dump. The path can point to a YAML or JSON file, e.g. one
+// produced by `kubectl get ns,pods,deploy -A -o yaml`, a directory of such files or a tarball
+// of them (.tar, .tar.gz or .tgz). OpenShift must-gather directories are recognized by having both
+// the cluster-scoped-resources and the namespaces subdirectories, only the files the inspections
+// need are read from them. Lists are flattened into their items, objects of kinds that are not
+// known to the kube client are skipped.
+func Load(path string) ([]runtime.Object, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the dump: %w", err)
+	}
+
+	l := &loader{seen: map[string]bool{}}
+	switch {
+	case info.IsDir():
+		err = l.loadDir(path)
+	case isTarball(path):
+		err = l.loadTarball(path)
+	default:
+		err = l.loadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(l.objs) == 0 {
+		klog.Warningf("the dump %q does not contain any objects the inspections can read", path)
+	}
+	return l.objs, nil
+}
+
+type loader struct {
+	objs []runtime.Object
+	// seen prevents adding an object twice when it appears in several files of the dump
+	seen map[string]bool
+}
+
+func isTarball(path string) bool {
+	for _, ext := range []string{".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(path, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+func isManifest(path string) bool {
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+// mustGatherResources are the files with the namespaced resources the inspections need
+// in the namespaces/<namespace>/<group>/<resource>.yaml layout of an OpenShift must-gather
+var mustGatherResources = map[string]bool{
+	"core/pods.yaml":                   true,
+	"core/replicationcontrollers.yaml": true,
+	"core/events.yaml":                 true,
+	"apps/deployments.yaml":            true,
+	"apps/replicasets.yaml":            true,
+	"apps/statefulsets.yaml":           true,
+	"apps/daemonsets.yaml":             true,
+	"batch/jobs.yaml":                  true,
+	"batch/cronjobs.yaml":              true,
+}
+
+const (
+	mustGatherClusterDir    = "cluster-scoped-resources"
+	mustGatherNamespacesDir = "namespaces"
+)
+
+// mustGatherRoots returns the directories of an OpenShift must-gather (or `oc adm inspect`) among
+// the slash-separated paths of the files of a dump. A must-gather is recognized by having both the
+// cluster-scoped-resources and the namespaces subdirectories, the root of the dump is "".
+func mustGatherRoots(paths []string) sets.String {
+	clusterDirs, namespacesDirs := sets.NewString(), sets.NewString()
+	for _, path := range paths {
+		segments := strings.Split(path, "/")
+		for i, segment := range segments[:len(segments)-1] {
+			root := strings.Join(segments[:i], "/")
+			switch segment {
+			case mustGatherClusterDir:
+				clusterDirs.Insert(root)
+			case mustGatherNamespacesDir:
+				namespacesDirs.Insert(root)
+			}
+		}
+	}
+	return clusterDirs.Intersection(namespacesDirs)
+}
+
+// mustGatherFile tells whether the file at the slash-separated path relative to the root of
+// a must-gather should be loaded. Must-gathers contain lots of other data, including pod logs
+// and resources the inspections don't need.
+func mustGatherFile(path string) bool {
+	segments := strings.Split(path, "/")
+	switch {
+	case segments[0] == mustGatherClusterDir:
+		// cluster-scoped-resources/core/namespaces/<namespace>.yaml
+		return len(segments) == 4 && segments[1] == "core" && segments[2] == "namespaces"
+	case segments[0] == mustGatherNamespacesDir && len(segments) > 2:
+		ns, rest := segments[1], segments[2:]
+		switch len(rest) {
+		case 1:
+			// namespaces/<namespace>/<namespace>.yaml
+			return rest[0] == ns+".yaml"
+		case 2:
+			return mustGatherResources[rest[0]+"/"+rest[1]]
+		case 3:
+			// namespaces/<namespace>/pods/<pod>/<pod>.yaml
+			return rest[0] == "pods" && rest[2] == rest[1]+".yaml"
+		}
+	}
+	return false
+}
+
+// shouldLoad tells whether the file at the slash-separated path relative to the root of the dump
+// should be loaded, mustGatherRoots are the must-gather directories of the dump
+func shouldLoad(path string, mustGatherRoots sets.String) bool {
+	// the innermost must-gather the file belongs to
+	inMustGather, rel := false, path
+	for root := range mustGatherRoots {
+		switch {
+		case len(root) == 0:
+			inMustGather = true
+		case strings.HasPrefix(path, root+"/"):
+			if trimmed := strings.TrimPrefix(path, root+"/"); !inMustGather || len(trimmed) < len(rel) {
+				inMustGather, rel = true, trimmed
+			}
+		}
+	}
+	if inMustGather {
+		return mustGatherFile(rel)
+	}
+	return isManifest(path)
+}
+
+func (l *loader) loadDir(dir string) error {
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	roots := mustGatherRoots(paths)
+	for _, path := range paths {
+		if !shouldLoad(path, roots) {
+			continue
+		}
+		if err := l.loadFile(filepath.Join(dir, filepath.FromSlash(path))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *loader) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read the dump: %w", err)
+	}
+	defer f.Close()
+
+	return l.decode(path, f)
+}
+
+// loadTarball reads the tarball twice, first to find the must-gather directories in it
+func (l *loader) loadTarball(path string) error {
+	var paths []string
+	err := walkTarball(path, func(name string, _ io.Reader) error {
+		paths = append(paths, name)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	roots := mustGatherRoots(paths)
+	return walkTarball(path, func(name string, r io.Reader) error {
+		if !shouldLoad(name, roots) {
+			return nil
+		}
+		return l.decode(path+":"+name, r)
+	})
+}
+
+// walkTarball calls f with the name and the content of each regular file of the tarball
+func walkTarball(path string, f func(name string, r io.Reader) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read the dump: %w", err)
+	}
+	defer file.Close()
+
+	var r io.Reader = bufio.NewReader(file)
+	if !strings.HasSuffix(path, ".tar") {
+		gzr, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("failed to decompress %q: %w", path, err)
+		}
+		defer gzr.Close()
+		r = gzr
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read %q: %w", path, err)
+		}
+
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := f(strings.TrimPrefix(hdr.Name, "./"), tr); err != nil {
+			return err
+		}
+	}
+}
+
+// decode adds all the objects from the YAML or JSON documents of the reader
+func (l *loader) decode(source string, r io.Reader) error {
+	decoder := utilyaml.NewYAMLOrJSONDecoder(r, 4096)
+	for {
+		u := &unstructured.Unstructured{}
+		if err := decoder.Decode(&u.Object); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to decode %q: %w", source, err)
+		}
+		if len(u.Object) == 0 {
+			continue
+		}
+
+		if !u.IsList() {
+			if err := l.add(source, u); err != nil {
+				return err
+			}
+			continue
+		}
+
+		list, err := u.ToList()
+		if err != nil {
+			return fmt.Errorf("failed to decode the list in %q: %w", source, err)
+		}
+		for i := range list.Items {
+			if err := l.add(source, &list.Items[i]); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (l *loader) add(source string, u *unstructured.Unstructured) error {
+	gvk := u.GroupVersionKind()
+	if !scheme.Scheme.Recognizes(gvk) {
+		klog.V(2).Infof("skipping %s %s/%s from %q: unknown kind", gvk, u.GetNamespace(), u.GetName(), source)
+		return nil
+	}
+
+	key := fmt.Sprintf("%s/%s/%s", gvk.GroupKind(), u.GetNamespace(), u.GetName())
+	if l.seen[key] {
+		return nil
+	}
+	l.seen[key] = true
+
+	data, err := u.MarshalJSON()
+	if err != nil {
+		return fmt.Errorf("failed to decode %s %s/%s from %q: %w", gvk.Kind, u.GetNamespace(), u.GetName(), source, err)
+	}
+	obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(data, &gvk, nil)
+	if err != nil {
+		return fmt.Errorf("failed to decode %s %s/%s from %q: %w", gvk.Kind, u.GetNamespace(), u.GetName(), source, err)
+	}
+
+	l.objs = append(l.objs, obj)
+	return nil
+}
@@ -0,0 +1,322 @@
+package dump
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const namespacesList = `apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Namespace
+  metadata:
+    name: app
+- apiVersion: v1
+  kind: Namespace
+  metadata:
+    name: db
+`
+
+const appObjects = `apiVersion: v1
+kind: Pod
+metadata:
+  name: web-1
+  namespace: app
+spec:
+  containers:
+  - name: web
+    image: web
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: app
+spec:
+  selector:
+    matchLabels:
+      app: web
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+      - name: web
+        image: web
+---
+apiVersion: example.com/v1
+kind: Unknown
+metadata:
+  name: skipped
+  namespace: app
+`
+
+// dbObjects repeats the namespace from namespacesList in JSON
+const dbObjects = `{"apiVersion": "v1", "kind": "Namespace", "metadata": {"name": "db"}}
+{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "postgres-0", "namespace": "db"}, "spec": {"containers": [{"name": "postgres", "image": "postgres"}]}}
+`
+
+// testDump are the files of a dump relative to its root
+var testDump = map[string]string{
+	"namespaces.yaml": namespacesList,
+	"app/all.yaml":    appObjects,
+	"db/all.json":     dbObjects,
+	"db/README.md":    "not a manifest",
+}
+
+// expectedObjects are the objects of testDump in the "Kind namespace/name" format
+var expectedObjects = []string{
+	"Deployment app/web",
+	"Namespace /app",
+	"Namespace /db",
+	"Pod app/web-1",
+	"Pod db/postgres-0",
+}
+
+func writeDir(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func writeTarball(t *testing.T, name string, files map[string]string) string {
+	path := filepath.Join(t.TempDir(), name)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var w io.Writer = f
+	if filepath.Ext(name) != ".tar" {
+		gzw := gzip.NewWriter(f)
+		defer gzw.Close()
+		w = gzw
+	}
+
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: "dump/" + name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+func objectNames(t *testing.T, objs []runtime.Object) []string {
+	var names []string
+	for _, obj := range objs {
+		accessor, ok := obj.(metav1.ObjectMetaAccessor)
+		if !ok {
+			t.Fatalf("unexpected object %T", obj)
+		}
+		meta := accessor.GetObjectMeta()
+		kind := obj.GetObjectKind().GroupVersionKind().Kind
+		names = append(names, kind+" "+meta.GetNamespace()+"/"+meta.GetName())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func assertObjects(t *testing.T, expected []string, objs []runtime.Object) {
+	t.Helper()
+	got := objectNames(t, objs)
+	if len(got) != len(expected) {
+		t.Fatalf("expected objects %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("expected objects %v, got %v", expected, got)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := map[string]func(t *testing.T) string{
+		"directory": func(t *testing.T) string { return writeDir(t, testDump) },
+		"tar":       func(t *testing.T) string { return writeTarball(t, "dump.tar", testDump) },
+		"tar.gz":    func(t *testing.T) string { return writeTarball(t, "dump.tar.gz", testDump) },
+		"tgz":       func(t *testing.T) string { return writeTarball(t, "dump.tgz", testDump) },
+	}
+
+	for name, path := range tests {
+		t.Run(name, func(t *testing.T) {
+			objs, err := Load(path(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertObjects(t, expectedObjects, objs)
+		})
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	dir := writeDir(t, testDump)
+
+	objs, err := Load(filepath.Join(dir, "namespaces.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertObjects(t, []string{"Namespace /app", "Namespace /db"}, objs)
+
+	objs, err = Load(filepath.Join(dir, "app", "all.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertObjects(t, []string{"Deployment app/web", "Pod app/web-1"}, objs)
+}
+
+func TestLoadInvalid(t *testing.T) {
+	dir := writeDir(t, map[string]string{"broken.yaml": "kind: [Pod"})
+	if _, err := Load(dir); err == nil {
+		t.Errorf("expected an error for a malformed manifest")
+	}
+	if _, err := Load(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Errorf("expected an error for a missing dump")
+	}
+}
+
+func TestNewClientset(t *testing.T) {
+	client, err := NewClientset(writeDir(t, testDump))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	namespaces, err := client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(namespaces.Items) != 2 {
+		t.Errorf("expected 2 namespaces, got %d", len(namespaces.Items))
+	}
+
+	pods, err := client.CoreV1().Pods("db").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods.Items) != 1 || pods.Items[0].Name != "postgres-0" {
+		t.Errorf("expected the postgres-0 pod in the db namespace, got %v", pods.Items)
+	}
+
+	if _, err := client.AppsV1().Deployments("app").Get(ctx, "web", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected the web deployment: %v", err)
+	}
+}
+
+// mustGather has the layout of an OpenShift must-gather along with the files that should be ignored
+var mustGather = map[string]string{
+	"timestamp": "2026-10-18",
+	"cluster-scoped-resources/core/namespaces/app.yaml": `apiVersion: v1
+kind: Namespace
+metadata:
+  name: app
+`,
+	"cluster-scoped-resources/core/nodes/node-1.yaml": `apiVersion: v1
+kind: Node
+metadata:
+  name: node-1
+`,
+	"namespaces/app/app.yaml": `apiVersion: v1
+kind: Namespace
+metadata:
+  name: app
+`,
+	"namespaces/app/core/pods.yaml": `apiVersion: v1
+kind: PodList
+items:
+- apiVersion: v1
+  kind: Pod
+  metadata:
+    name: web-1
+    namespace: app
+  spec:
+    containers:
+    - name: web
+      image: web
+`,
+	"namespaces/app/core/configmaps.yaml": `apiVersion: v1
+kind: ConfigMapList
+items:
+- apiVersion: v1
+  kind: ConfigMap
+  metadata:
+    name: config
+    namespace: app
+`,
+	"namespaces/app/apps/deployments.yaml": appObjects,
+	"namespaces/app/pods/web-1/web-1.yaml": `apiVersion: v1
+kind: Pod
+metadata:
+  name: web-1
+  namespace: app
+spec:
+  containers:
+  - name: web
+    image: web
+`,
+	"namespaces/app/pods/web-1/web/web/logs/current.log": "not a manifest",
+}
+
+func TestLoadMustGather(t *testing.T) {
+	// the must-gather image directory is nested in the must-gather directory
+	nested := map[string]string{}
+	for name, content := range mustGather {
+		nested["quay-io-openshift-must-gather/"+name] = content
+	}
+	// apps/deployments.yaml holds the web-1 pod as well, it gets deduplicated
+	expected := []string{"Deployment app/web", "Namespace /app", "Pod app/web-1"}
+
+	tests := map[string]func(t *testing.T) string{
+		"directory":        func(t *testing.T) string { return writeDir(t, mustGather) },
+		"nested directory": func(t *testing.T) string { return writeDir(t, nested) },
+		"tar.gz":           func(t *testing.T) string { return writeTarball(t, "must-gather.tar.gz", nested) },
+	}
+
+	for name, path := range tests {
+		t.Run(name, func(t *testing.T) {
+			objs, err := Load(path(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertObjects(t, expected, objs)
+		})
+	}
+}
+
+func TestLoadNamespacesDirWithoutMustGather(t *testing.T) {
+	// without the cluster-scoped-resources directory, the manifests are read as a plain dump
+	dump := map[string]string{
+		"namespaces/app/core/configmaps.yaml": mustGather["namespaces/app/core/configmaps.yaml"],
+		"namespaces/app/workloads.yaml":       appObjects,
+	}
+
+	objs, err := Load(writeDir(t, dump))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertObjects(t, []string{"ConfigMap app/config", "Deployment app/web", "Pod app/web-1"}, objs)
+}
@@ -0,0 +1,34 @@
+package exportpolicies
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+func NewExportPoliciesCommand(clientConfigOptions *genericclioptions.ConfigFlags) *cobra.Command {
+	o := newExportPoliciesOptions()
+
+	cmd := &cobra.Command{
+		Use:          "export-policies --engine kyverno|gatekeeper|vap [flags]",
+		Short:        "turn the recommended PodSecurity levels into Kyverno, Gatekeeper or ValidatingAdmissionPolicy policies",
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(c, clientConfigOptions); err != nil {
+				return err
+			}
+			errs := o.Validate()
+			if len(errs) > 0 {
+				return fmt.Errorf("there were errors while setting up the command: %v", errs)
+			}
+
+			return o.Run(context.Background(), c.OutOrStdout())
+		},
+	}
+
+	o.AddFlags(cmd)
+	return cmd
+}
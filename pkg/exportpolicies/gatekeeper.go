@@ -0,0 +1,197 @@
+package exportpolicies
+
+import (
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/sets"
+	psapi "k8s.io/pod-security-admission/api"
+	"k8s.io/pod-security-admission/policy"
+)
+
+// gatekeeperConstraint is a constraint of a template from the pod-security-policy directory
+// of the Gatekeeper library that implements some of the PodSecurity checks
+type gatekeeperConstraint struct {
+	kind string
+	// levels are the levels that use the constraint
+	levels     []psapi.Level
+	checks     []policy.CheckID
+	parameters map[string]interface{}
+	// exemptImages is set if the template accepts the exemptImages parameter
+	exemptImages bool
+}
+
+var (
+	baseline   = []psapi.Level{psapi.LevelBaseline, psapi.LevelRestricted}
+	restricted = []psapi.Level{psapi.LevelRestricted}
+
+	gatekeeperConstraints = []gatekeeperConstraint{
+		{kind: "K8sPSPPrivilegedContainer", levels: baseline, checks: checkIDs("privileged"), exemptImages: true},
+		{kind: "K8sPSPHostNamespace", levels: baseline, checks: checkIDs("hostNamespaces")},
+		{kind: "K8sPSPHostNetworkingPorts", levels: baseline, checks: checkIDs("hostNamespaces", "hostPorts"), exemptImages: true,
+			parameters: map[string]interface{}{"hostNetworkPorts": false}},
+		{kind: "K8sPSPHostFilesystem", levels: baseline, checks: checkIDs("hostPathVolumes"),
+			parameters: map[string]interface{}{"allowedHostPaths": []interface{}{}}},
+		{kind: "K8sPSPCapabilities", levels: []psapi.Level{psapi.LevelBaseline}, checks: checkIDs("capabilities_baseline"), exemptImages: true,
+			parameters: map[string]interface{}{"allowedCapabilities": toInterfaces(baselineCapabilities)}},
+		{kind: "K8sPSPCapabilities", levels: restricted, checks: checkIDs("capabilities_baseline", "capabilities_restricted"), exemptImages: true,
+			parameters: map[string]interface{}{
+				"allowedCapabilities":      []interface{}{"NET_BIND_SERVICE"},
+				"requiredDropCapabilities": []interface{}{"ALL"},
+			}},
+		{kind: "K8sPSPSELinuxV2", levels: baseline, checks: checkIDs("seLinuxOptions"), exemptImages: true,
+			parameters: map[string]interface{}{"allowedSELinuxOptions": []interface{}{
+				map[string]interface{}{"type": "container_t"},
+				map[string]interface{}{"type": "container_init_t"},
+				map[string]interface{}{"type": "container_kvm_t"},
+			}}},
+		{kind: "K8sPSPProcMount", levels: baseline, checks: checkIDs("procMount"), exemptImages: true,
+			parameters: map[string]interface{}{"procMount": "Default"}},
+		{kind: "K8sPSPForbiddenSysctls", levels: baseline, checks: checkIDs("sysctls"),
+			parameters: map[string]interface{}{
+				"forbiddenSysctls": []interface{}{"*"},
+				"allowedSysctls":   toInterfaces(safeSysctls),
+			}},
+		{kind: "K8sPSPVolumeTypes", levels: restricted, checks: checkIDs("restrictedVolumes"),
+			parameters: map[string]interface{}{"volumes": toInterfaces(restrictedVolumes)}},
+		{kind: "K8sPSPAllowPrivilegeEscalationContainer", levels: restricted, checks: checkIDs("allowPrivilegeEscalation"), exemptImages: true},
+		{kind: "K8sPSPAllowedUsers", levels: restricted, checks: checkIDs("runAsNonRoot", "runAsUser"), exemptImages: true,
+			parameters: map[string]interface{}{"runAsUser": map[string]interface{}{"rule": "MustRunAsNonRoot"}}},
+		{kind: "K8sPSPSeccomp", levels: restricted, checks: checkIDs("seccompProfile_baseline", "seccompProfile_restricted"), exemptImages: true,
+			parameters: map[string]interface{}{"allowedProfiles": []interface{}{"runtime/default", "localhost/*"}}},
+	}
+
+	// gatekeeperUnsupported are the checks the Gatekeeper library has no faithful equivalent of
+	gatekeeperUnsupported = map[psapi.Level][]policy.CheckID{
+		psapi.LevelBaseline:   checkIDs("appArmorProfile", "seccompProfile_baseline", "windowsHostProcess"),
+		psapi.LevelRestricted: checkIDs("appArmorProfile", "windowsHostProcess"),
+	}
+
+	baselineCapabilities = []string{
+		"AUDIT_WRITE", "CHOWN", "DAC_OVERRIDE", "FOWNER", "FSETID", "KILL", "MKNOD", "NET_BIND_SERVICE",
+		"SETFCAP", "SETGID", "SETPCAP", "SETUID", "SYS_CHROOT",
+	}
+	restrictedVolumes = []string{
+		"configMap", "csi", "downwardAPI", "emptyDir", "ephemeral", "persistentVolumeClaim", "projected", "secret",
+	}
+	// safeSysctls are the sysctls allowed by the latest version of the sysctls check (v1.27)
+	// of the bundled PodSecurity library
+	safeSysctls = []string{
+		"kernel.shm_rmid_forced", "net.ipv4.ip_local_port_range", "net.ipv4.tcp_syncookies",
+		"net.ipv4.ping_group_range", "net.ipv4.ip_unprivileged_port_start", "net.ipv4.ip_local_reserved_ports",
+	}
+)
+
+// gatekeeperPolicies creates the constraints of the Gatekeeper library templates for each group
+// of namespaces sharing a level. The namespaces with exceptions get constraints of their own,
+// the images of the exempted workloads are exempted from the constraints of the checks they fail.
+func gatekeeperPolicies(plans []NamespacePolicy, audit bool) ([]*unstructured.Unstructured, []string) {
+	action := "deny"
+	if audit {
+		action = "dryrun"
+	}
+
+	var objs []*unstructured.Unstructured
+	var notes []string
+	groups, withExceptions := groupByLevel(plans)
+	for _, g := range groups {
+		for _, c := range gatekeeperConstraints {
+			if !hasLevel(c.levels, g.Level) {
+				continue
+			}
+			objs = append(objs, c.constraint(objectName("psachecker", string(g.Level), c.kind), g.Namespaces, nil, action))
+		}
+		notes = append(notes, unsupportedNote(g.Level, g.Namespaces))
+	}
+
+	for _, p := range withExceptions {
+		for _, c := range gatekeeperConstraints {
+			if !hasLevel(c.levels, p.Level) {
+				continue
+			}
+
+			images := sets.NewString()
+			for _, e := range p.Exceptions {
+				if !sets.NewString(checkStrings(e.Checks)...).HasAny(checkStrings(c.checks)...) {
+					continue
+				}
+				if !c.exemptImages || len(e.Images) == 0 {
+					notes = append(notes, c.kind+" is left out in namespace "+p.Namespace+" as "+e.Kind+" "+e.Name+" fails it")
+					images = nil
+					break
+				}
+				images.Insert(e.Images...)
+			}
+			if images == nil {
+				continue
+			}
+			objs = append(objs, c.constraint(objectName("psachecker", p.Namespace, c.kind), []string{p.Namespace}, images.List(), action))
+		}
+		notes = append(notes, unsupportedNote(p.Level, []string{p.Namespace}))
+	}
+
+	return objs, notes
+}
+
+func (c *gatekeeperConstraint) constraint(name string, namespaces, exemptImages []string, action string) *unstructured.Unstructured {
+	parameters := map[string]interface{}{}
+	for k, v := range c.parameters {
+		parameters[k] = v
+	}
+	if len(exemptImages) > 0 {
+		parameters["exemptImages"] = toInterfaces(exemptImages)
+	}
+
+	spec := map[string]interface{}{
+		"enforcementAction": action,
+		"match": map[string]interface{}{
+			"kinds": []interface{}{
+				map[string]interface{}{
+					"apiGroups": []interface{}{""},
+					"kinds":     []interface{}{"Pod"},
+				},
+			},
+			"namespaceSelector": namespaceSelector(namespaces),
+		},
+	}
+	if len(parameters) > 0 {
+		spec["parameters"] = parameters
+	}
+
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "constraints.gatekeeper.sh/v1beta1",
+		"kind":       c.kind,
+		"metadata":   map[string]interface{}{"name": name},
+		"spec":       spec,
+	}}
+}
+
+func unsupportedNote(level psapi.Level, namespaces []string) string {
+	return "the " + strings.Join(checkStrings(gatekeeperUnsupported[level]), ", ") +
+		" checks of the " + string(level) + " level are not enforced in " + strings.Join(namespaces, ", ")
+}
+
+func hasLevel(levels []psapi.Level, level psapi.Level) bool {
+	for _, l := range levels {
+		if l == level {
+			return true
+		}
+	}
+	return false
+}
+
+func checkIDs(ids ...string) []policy.CheckID {
+	ret := make([]policy.CheckID, 0, len(ids))
+	for _, id := range ids {
+		ret = append(ret, policy.CheckID(id))
+	}
+	return ret
+}
+
+func checkStrings(ids []policy.CheckID) []string {
+	ret := make([]string, 0, len(ids))
+	for _, id := range ids {
+		ret = append(ret, string(id))
+	}
+	return ret
+}
@@ -0,0 +1,181 @@
+package exportpolicies
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/sets"
+	psapi "k8s.io/pod-security-admission/api"
+	"k8s.io/pod-security-admission/policy"
+)
+
+// kyvernoControl is the Kyverno podSecurity control a PodSecurity check belongs to
+type kyvernoControl struct {
+	name string
+	// the container-level controls are exempted by the container images, the pod-level ones
+	// for the whole pod
+	containerLevel, podLevel bool
+}
+
+var kyvernoControls = map[policy.CheckID]kyvernoControl{
+	"privileged":                {name: "Privileged Containers", containerLevel: true},
+	"hostNamespaces":            {name: "Host Namespaces", podLevel: true},
+	"hostPathVolumes":           {name: "HostPath Volumes", podLevel: true},
+	"hostPorts":                 {name: "Host Ports", containerLevel: true},
+	"capabilities_baseline":     {name: "Capabilities", containerLevel: true},
+	"capabilities_restricted":   {name: "Capabilities", containerLevel: true},
+	"appArmorProfile":           {name: "AppArmor", podLevel: true},
+	"seLinuxOptions":            {name: "SELinux", containerLevel: true, podLevel: true},
+	"procMount":                 {name: "/proc Mount Type", containerLevel: true},
+	"seccompProfile_baseline":   {name: "Seccomp", containerLevel: true, podLevel: true},
+	"seccompProfile_restricted": {name: "Seccomp", containerLevel: true, podLevel: true},
+	"sysctls":                   {name: "Sysctls", podLevel: true},
+	"restrictedVolumes":         {name: "Volume Types", podLevel: true},
+	"allowPrivilegeEscalation":  {name: "Privilege Escalation", containerLevel: true},
+	"runAsNonRoot":              {name: "Running as Non-root", containerLevel: true, podLevel: true},
+	"runAsUser":                 {name: "Running as Non-root user", containerLevel: true, podLevel: true},
+	"windowsHostProcess":        {name: "HostProcess", containerLevel: true, podLevel: true},
+}
+
+// kyvernoPolicy creates a Kyverno ClusterPolicy with a podSecurity rule for each group of
+// namespaces sharing a level. The workloads with exceptions are excluded from the rules of their
+// namespaces and get rules of their own that exclude the controls they fail.
+func kyvernoPolicy(plans []NamespacePolicy, audit bool) (*unstructured.Unstructured, []string) {
+	var rules []interface{}
+	var notes []string
+
+	// rule names are limited to 63 characters and must be unique within the policy
+	usedNames := sets.NewString()
+	groups, withExceptions := groupByLevel(plans)
+	for _, g := range groups {
+		rules = append(rules, kyvernoRule(uniqueName(objectName(string(g.Level)), 63, usedNames), namespaceSelector(g.Namespaces), nil, g.Level, nil))
+	}
+
+	for _, p := range withExceptions {
+		selector := namespaceSelector([]string{p.Namespace})
+		var exempted []interface{}
+		for _, e := range p.Exceptions {
+			e := e
+			exempted = append(exempted, map[string]interface{}{"resources": kyvernoPodResources(&e)})
+
+			exclude, unknown := kyvernoExcludes(&e)
+			for _, id := range unknown {
+				notes = append(notes, "no Kyverno control matches the "+string(id)+" check failed by "+e.Kind+" "+p.Namespace+"/"+e.Name)
+			}
+			rules = append(rules, kyvernoRule(
+				uniqueName(objectName(p.Namespace, e.Kind, e.Name), 63, usedNames),
+				selector, &e, p.Level, exclude,
+			))
+		}
+
+		rule := kyvernoRule(uniqueName(objectName(p.Namespace, string(p.Level)), 63, usedNames), selector, nil, p.Level, nil)
+		rule["exclude"] = map[string]interface{}{"any": exempted}
+		rules = append(rules, rule)
+	}
+
+	action := "Enforce"
+	if audit {
+		action = "Audit"
+	}
+
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "kyverno.io/v1",
+		"kind":       "ClusterPolicy",
+		"metadata": map[string]interface{}{
+			"name": "psachecker-pod-security",
+			"annotations": map[string]interface{}{
+				"policies.kyverno.io/title":       "Pod Security Standards recommended by psachecker",
+				"policies.kyverno.io/subject":     "Pod",
+				"policies.kyverno.io/description": "Enforces the PodSecurity levels the workloads of each namespace are able to run with.",
+			},
+		},
+		"spec": map[string]interface{}{
+			"validationFailureAction": action,
+			"background":              true,
+			"rules":                   rules,
+		},
+	}}, notes
+}
+
+func kyvernoRule(name string, namespaceSelector map[string]interface{}, exception *Exception, level psapi.Level, exclude []interface{}) map[string]interface{} {
+	resources := map[string]interface{}{
+		"kinds": []interface{}{"Pod"},
+	}
+	if exception != nil {
+		resources = kyvernoPodResources(exception)
+	}
+	resources["namespaceSelector"] = namespaceSelector
+
+	podSecurity := map[string]interface{}{
+		"level":   string(level),
+		"version": "latest",
+	}
+	if len(exclude) > 0 {
+		podSecurity["exclude"] = exclude
+	}
+
+	return map[string]interface{}{
+		"name": name,
+		"match": map[string]interface{}{
+			"any": []interface{}{
+				map[string]interface{}{"resources": resources},
+			},
+		},
+		"validate": map[string]interface{}{
+			"podSecurity": podSecurity,
+		},
+	}
+}
+
+// kyvernoPodResources matches the pods of the exempted workload by their labels, or by their
+// names if the workload has no stable labels
+func kyvernoPodResources(e *Exception) map[string]interface{} {
+	resources := map[string]interface{}{
+		"kinds": []interface{}{"Pod"},
+	}
+	if len(e.PodLabels) > 0 {
+		matchLabels := map[string]interface{}{}
+		for k, v := range e.PodLabels {
+			matchLabels[k] = v
+		}
+		resources["selector"] = map[string]interface{}{"matchLabels": matchLabels}
+	} else {
+		resources["names"] = toInterfaces(e.podNames())
+	}
+	return resources
+}
+
+// kyvernoExcludes returns the podSecurity excludes of the controls the workload fails along
+// with the checks that have no matching control
+func kyvernoExcludes(e *Exception) ([]interface{}, []policy.CheckID) {
+	images := e.Images
+	if len(images) == 0 {
+		images = []string{"*"}
+	}
+
+	var excludes []interface{}
+	var unknown []policy.CheckID
+	seen := sets.NewString()
+	for _, id := range e.Checks {
+		control, ok := kyvernoControls[id]
+		if !ok {
+			unknown = append(unknown, id)
+			continue
+		}
+		if seen.Has(control.name) {
+			continue
+		}
+		seen.Insert(control.name)
+
+		if control.containerLevel {
+			excludes = append(excludes, map[string]interface{}{
+				"controlName": control.name,
+				"images":      toInterfaces(images),
+			})
+		}
+		if control.podLevel {
+			excludes = append(excludes, map[string]interface{}{
+				"controlName": control.name,
+			})
+		}
+	}
+	return excludes, unknown
+}
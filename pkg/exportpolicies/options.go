@@ -0,0 +1,117 @@
+package exportpolicies
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	psapi "k8s.io/pod-security-admission/api"
+	"sigs.k8s.io/yaml"
+
+	"github.com/stlaz/psachecker/pkg/clusterinspect"
+)
+
+const (
+	engineKyverno    = "kyverno"
+	engineGatekeeper = "gatekeeper"
+	engineVAP        = "vap"
+)
+
+type ExportPoliciesOptions struct {
+	inspectOptions *clusterinspect.ClusterInspectOptions
+
+	engine        string
+	level         string
+	maxExceptions int
+	audit         bool
+}
+
+func newExportPoliciesOptions() *ExportPoliciesOptions {
+	return &ExportPoliciesOptions{
+		inspectOptions: clusterinspect.NewClusterInspectOptions(),
+	}
+}
+
+func (o *ExportPoliciesOptions) AddFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+
+	flags.StringVar(&o.engine, "engine", "", "The policy engine to generate the policies for. One of: (kyverno, gatekeeper, vap). The vap engine generates ValidatingAdmissionPolicies with CEL expressions of the PodSecurity checks.")
+	flags.StringVar(&o.level, "level", "", "Enforce this level in all the namespaces and add exceptions for the workloads that fail it. By default, each namespace gets its recommended level.")
+	flags.IntVar(&o.maxExceptions, "max-exceptions", 0, "Enforce the most restrictive level that at most this many workloads of a namespace fail and add exceptions for them. Ignored with --level.")
+	flags.BoolVar(&o.audit, "audit", false, "Only audit the violations instead of denying the pods.")
+
+	o.inspectOptions.AddInputFlags(cmd)
+	o.inspectOptions.AddScanFlags(cmd)
+}
+
+func (o *ExportPoliciesOptions) Complete(cmd *cobra.Command, clientConfigOptions *genericclioptions.ConfigFlags) error {
+	return o.inspectOptions.Complete(cmd, clientConfigOptions)
+}
+
+func (o *ExportPoliciesOptions) Validate() []error {
+	errs := []error{}
+
+	if o.engine != engineKyverno && o.engine != engineGatekeeper && o.engine != engineVAP {
+		errs = append(errs, fmt.Errorf("--engine must be one of (kyverno, gatekeeper, vap)"))
+	}
+
+	if len(o.level) > 0 {
+		if _, err := psapi.ParseLevel(o.level); err != nil {
+			errs = append(errs, fmt.Errorf("invalid --level: %w", err))
+		}
+	}
+
+	if o.maxExceptions < 0 {
+		errs = append(errs, fmt.Errorf("--max-exceptions must not be negative"))
+	}
+
+	errs = append(errs, o.inspectOptions.Validate()...)
+
+	return errs
+}
+
+func (o *ExportPoliciesOptions) Run(ctx context.Context, out io.Writer) error {
+	results, err := o.inspectOptions.Inspect(ctx)
+	if err != nil {
+		return err
+	}
+	plans := Plan(results, psapi.Level(o.level), o.maxExceptions)
+
+	var (
+		objs  []*unstructured.Unstructured
+		notes []string
+	)
+	switch o.engine {
+	case engineKyverno:
+		var obj *unstructured.Unstructured
+		obj, notes = kyvernoPolicy(plans, o.audit)
+		objs = append(objs, obj)
+	case engineGatekeeper:
+		objs, notes = gatekeeperPolicies(plans, o.audit)
+		notes = append([]string{"the constraints require the templates from the pod-security-policy directory of the Gatekeeper library"}, notes...)
+	case engineVAP:
+		objs = vapPolicies(plans, o.audit)
+		notes = []string{"the ValidatingAdmissionPolicies require Kubernetes 1.30 or newer"}
+	}
+
+	return printObjects(out, objs, notes)
+}
+
+// printObjects prints the objects as a multi-document YAML preceded by the notes as comments
+func printObjects(out io.Writer, objs []*unstructured.Unstructured, notes []string) error {
+	for _, n := range notes {
+		fmt.Fprintf(out, "# %s\n", n)
+	}
+	for _, obj := range objs {
+		data, err := yaml.Marshal(obj.Object)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "---\n%s", data)
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package exportpolicies
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	psapi "k8s.io/pod-security-admission/api"
+	"k8s.io/pod-security-admission/policy"
+
+	"github.com/stlaz/psachecker/pkg/admission"
+)
+
+// NamespacePolicy is the level to enforce in a namespace along with the workloads
+// that need to be exempted from some of its checks
+type NamespacePolicy struct {
+	Namespace  string
+	Level      psapi.Level
+	Exceptions []Exception
+}
+
+// Exception exempts a workload from the checks of the namespace level it fails
+type Exception struct {
+	// Kind and Name identify the top-level controller of the pods, e.g. the Deployment rather
+	// than its current ReplicaSet, so that the exception survives rollouts
+	Kind string
+	Name string
+	// PodLabels are the labels shared by all the pods of the workload except for those that
+	// change with each rollout, the pods of the workload are matched by them. Bare pods are
+	// matched by their names instead.
+	PodLabels map[string]string
+	Images    []string
+	Checks    []policy.CheckID
+}
+
+// rolloutLabels are the labels the controllers set on the pods that differ between the revisions
+// of the controller or between its pods
+var rolloutLabels = sets.NewString(
+	appsv1.DefaultDeploymentUniqueLabelKey,
+	appsv1.ControllerRevisionHashLabelKey,
+	appsv1.StatefulSetPodNameLabel,
+	"apps.kubernetes.io/pod-index",
+	"pod-template-generation",
+	batchv1.JobCompletionIndexAnnotation,
+	batchv1.JobNameLabel,
+	batchv1.ControllerUidLabel,
+	"job-name",
+	"controller-uid",
+)
+
+func newException(w *admission.WorkloadResult, level psapi.Level) *Exception {
+	e := &Exception{
+		Images: w.Images,
+		Checks: failedChecksAt(w.FailedChecks, level),
+	}
+	e.Kind, e.Name = admission.TopLevelOwner(w.Kind, w.Name, w.PodLabels)
+	if w.Kind != "Pod" {
+		e.PodLabels = map[string]string{}
+		for k, v := range w.PodLabels {
+			if !rolloutLabels.Has(k) {
+				e.PodLabels[k] = v
+			}
+		}
+	}
+	return e
+}
+
+// merge adds the workload of another revision of the same top-level controller to the exception
+func (e *Exception) merge(other *Exception) {
+	e.Images = sets.NewString(e.Images...).Insert(other.Images...).List()
+	e.Checks = checkIDs(sets.NewString(checkStrings(e.Checks)...).Insert(checkStrings(other.Checks)...).List()...)
+	for k, v := range e.PodLabels {
+		if otherValue, ok := other.PodLabels[k]; !ok || otherValue != v {
+			delete(e.PodLabels, k)
+		}
+	}
+}
+
+// String returns the "Kind/name" identifier of the exempted workload
+func (e *Exception) String() string {
+	return e.Kind + "/" + e.Name
+}
+
+// podNames returns the names of the pods of the workload for the workloads without any stable
+// labels, the exact name of a bare pod or the name prefix of the pods of a controller
+func (e *Exception) podNames() []string {
+	if e.Kind == "Pod" {
+		return []string{e.Name}
+	}
+	return []string{e.Name + "-*"}
+}
+
+// Plan decides the level of each of the namespaces. With an empty level, each namespace gets
+// the most restrictive level that at most maxExceptions of its workloads fail, otherwise all
+// the namespaces get the level and all the workloads failing it get an exception.
+func Plan(results []*admission.NamespaceResult, level psapi.Level, maxExceptions int) []NamespacePolicy {
+	plans := make([]NamespacePolicy, 0, len(results))
+	for _, ns := range results {
+		nsLevel := level
+		if len(nsLevel) == 0 {
+			nsLevel = psapi.LevelPrivileged
+			for _, l := range []psapi.Level{psapi.LevelRestricted, psapi.LevelBaseline} {
+				if len(ns.WorkloadsFailingLevel(l)) <= maxExceptions {
+					nsLevel = l
+					break
+				}
+			}
+		}
+
+		plan := NamespacePolicy{Namespace: ns.Name, Level: nsLevel}
+		exceptions := map[string]*Exception{}
+		for _, w := range ns.WorkloadsFailingLevel(nsLevel) {
+			e := newException(&w, nsLevel)
+			if existing, ok := exceptions[e.String()]; ok {
+				existing.merge(e)
+				continue
+			}
+			exceptions[e.String()] = e
+		}
+		for _, e := range exceptions {
+			plan.Exceptions = append(plan.Exceptions, *e)
+		}
+		sort.Slice(plan.Exceptions, func(i, j int) bool {
+			return plan.Exceptions[i].String() < plan.Exceptions[j].String()
+		})
+		plans = append(plans, plan)
+	}
+
+	sort.Slice(plans, func(i, j int) bool {
+		return plans[i].Namespace < plans[j].Namespace
+	})
+	return plans
+}
+
+// failedChecksAt returns the IDs of the checks of the level
+func failedChecksAt(checks []admission.FailedCheck, level psapi.Level) []policy.CheckID {
+	ids := sets.NewString()
+	for _, c := range checks {
+		if psapi.CompareLevels(c.Level, level) <= 0 {
+			ids.Insert(string(c.ID))
+		}
+	}
+
+	ret := make([]policy.CheckID, 0, ids.Len())
+	for _, id := range ids.List() {
+		ret = append(ret, policy.CheckID(id))
+	}
+	return ret
+}
+
+// levelGroup is a set of namespaces that share the level and have no exceptions
+type levelGroup struct {
+	Level      psapi.Level
+	Namespaces []string
+}
+
+// groupByLevel groups the namespaces without exceptions by their levels so that they can share
+// the same policies, the namespaces with exceptions are returned separately. Privileged namespaces
+// are left out as there is nothing to enforce in them.
+func groupByLevel(plans []NamespacePolicy) ([]levelGroup, []NamespacePolicy) {
+	byLevel := map[psapi.Level][]string{}
+	var withExceptions []NamespacePolicy
+	for _, p := range plans {
+		switch {
+		case p.Level == psapi.LevelPrivileged:
+			continue
+		case len(p.Exceptions) > 0:
+			withExceptions = append(withExceptions, p)
+		default:
+			byLevel[p.Level] = append(byLevel[p.Level], p.Namespace)
+		}
+	}
+
+	var groups []levelGroup
+	for _, l := range []psapi.Level{psapi.LevelBaseline, psapi.LevelRestricted} {
+		if len(byLevel[l]) > 0 {
+			groups = append(groups, levelGroup{Level: l, Namespaces: byLevel[l]})
+		}
+	}
+	return groups, withExceptions
+}
+
+// namespaceSelector selects the namespaces by their names
+func namespaceSelector(namespaces []string) map[string]interface{} {
+	return map[string]interface{}{
+		"matchExpressions": []interface{}{
+			map[string]interface{}{
+				"key":      "kubernetes.io/metadata.name",
+				"operator": "In",
+				"values":   toInterfaces(namespaces),
+			},
+		},
+	}
+}
+
+// objectName turns the parts into a valid object name
+func objectName(parts ...string) string {
+	name := strings.ToLower(strings.Join(parts, "-"))
+	name = invalidNameChars.ReplaceAllString(name, "-")
+	if len(name) > 253 {
+		name = name[:253]
+	}
+	return strings.Trim(name, "-.")
+}
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// uniqueName shortens the name to maxLen characters and makes it unique among the used names.
+// The shortened names end with a hash of the full name so that they are stable across runs.
+func uniqueName(name string, maxLen int, used sets.String) string {
+	if len(name) > maxLen {
+		hash := fmt.Sprintf("%x", sha256.Sum256([]byte(name)))[:8]
+		name = strings.Trim(name[:maxLen-len(hash)-1], "-.") + "-" + hash
+	}
+
+	unique := name
+	for i := 2; used.Has(unique); i++ {
+		suffix := fmt.Sprintf("-%d", i)
+		if len(name)+len(suffix) > maxLen {
+			name = strings.Trim(name[:maxLen-len(suffix)], "-.")
+		}
+		unique = name + suffix
+	}
+	used.Insert(unique)
+	return unique
+}
+
+func toInterfaces(values []string) []interface{} {
+	ret := make([]interface{}, 0, len(values))
+	for _, v := range values {
+		ret = append(ret, v)
+	}
+	return ret
+}
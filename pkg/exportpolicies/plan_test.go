@@ -0,0 +1,100 @@
+package exportpolicies
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	psapi "k8s.io/pod-security-admission/api"
+	"k8s.io/pod-security-admission/policy"
+
+	"github.com/stlaz/psachecker/pkg/admission"
+)
+
+func failingWorkload(kind, name string, labels map[string]string, checks ...policy.CheckID) admission.WorkloadResult {
+	w := admission.WorkloadResult{Kind: kind, Name: name, MinimalLevel: psapi.LevelPrivileged, PodLabels: labels}
+	for _, id := range checks {
+		w.FailedChecks = append(w.FailedChecks, admission.FailedCheck{ID: id, Level: psapi.LevelBaseline})
+	}
+	return w
+}
+
+func TestPlanExceptions(t *testing.T) {
+	results := []*admission.NamespaceResult{{
+		Name: "app",
+		Workloads: []admission.WorkloadResult{
+			// two revisions of the same deployment
+			failingWorkload("ReplicaSet", "web-5d4f8b9c6", map[string]string{"app": "web", "version": "v1", "pod-template-hash": "5d4f8b9c6"}, "privileged"),
+			failingWorkload("ReplicaSet", "web-7f9c6d8b5", map[string]string{"app": "web", "version": "v2", "pod-template-hash": "7f9c6d8b5"}, "hostPorts"),
+			failingWorkload("DaemonSet", "agent", map[string]string{"app": "agent", "controller-revision-hash": "6b7c", "pod-template-generation": "3"}, "hostPathVolumes"),
+			failingWorkload("Pod", "debug", map[string]string{"run": "debug"}, "hostNamespaces"),
+			failingWorkload("Job", "migrate", nil, "privileged"),
+			// two runs of the same CronJob
+			failingWorkload("Job", "backup-28312560", map[string]string{"app": "backup", "job-name": "backup-28312560", "controller-uid": "8c1f", batchv1.JobNameLabel: "backup-28312560", batchv1.ControllerUidLabel: "8c1f"}, "hostPathVolumes"),
+			failingWorkload("Job", "backup-28314000", map[string]string{"app": "backup", "job-name": "backup-28314000", "controller-uid": "2d9e", batchv1.JobNameLabel: "backup-28314000", batchv1.ControllerUidLabel: "2d9e"}, "hostPathVolumes"),
+		},
+	}}
+
+	plans := Plan(results, psapi.LevelBaseline, 0)
+	if len(plans) != 1 {
+		t.Fatalf("expected a single plan, got %v", plans)
+	}
+
+	expected := []Exception{
+		{Kind: "CronJob", Name: "backup", PodLabels: map[string]string{"app": "backup"}, Checks: []policy.CheckID{"hostPathVolumes"}},
+		{Kind: "DaemonSet", Name: "agent", PodLabels: map[string]string{"app": "agent"}, Checks: []policy.CheckID{"hostPathVolumes"}},
+		{Kind: "Deployment", Name: "web", PodLabels: map[string]string{"app": "web"}, Checks: []policy.CheckID{"hostPorts", "privileged"}},
+		{Kind: "Job", Name: "migrate", PodLabels: map[string]string{}, Checks: []policy.CheckID{"privileged"}},
+		{Kind: "Pod", Name: "debug", Checks: []policy.CheckID{"hostNamespaces"}},
+	}
+	got := plans[0].Exceptions
+	for i := range got {
+		got[i].Images = nil
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected exceptions %v, got %v", expected, got)
+	}
+
+	if names := got[3].podNames(); !reflect.DeepEqual(names, []string{"migrate-*"}) {
+		t.Errorf("expected the job pods to be matched by their name prefix, got %v", names)
+	}
+	if names := got[4].podNames(); !reflect.DeepEqual(names, []string{"debug"}) {
+		t.Errorf("expected the bare pod to be matched by its name, got %v", names)
+	}
+}
+
+func TestKyvernoRuleNames(t *testing.T) {
+	long := strings.Repeat("a", 60)
+	plans := []NamespacePolicy{{
+		Namespace: "app",
+		Level:     psapi.LevelRestricted,
+		Exceptions: []Exception{
+			{Kind: "Deployment", Name: long + "-1", PodLabels: map[string]string{"app": "1"}, Checks: []policy.CheckID{"privileged"}},
+			{Kind: "Deployment", Name: long + "-2", PodLabels: map[string]string{"app": "2"}, Checks: []policy.CheckID{"privileged"}},
+		},
+	}}
+
+	names := func() []string {
+		policy, _ := kyvernoPolicy(plans, false)
+		var names []string
+		for _, r := range policy.Object["spec"].(map[string]interface{})["rules"].([]interface{}) {
+			names = append(names, r.(map[string]interface{})["name"].(string))
+		}
+		return names
+	}
+
+	first := names()
+	if sets.NewString(first...).Len() != len(first) {
+		t.Errorf("expected unique rule names, got %v", first)
+	}
+	for _, n := range first {
+		if len(n) > 63 {
+			t.Errorf("rule name %q is longer than 63 characters", n)
+		}
+	}
+	if second := names(); !reflect.DeepEqual(first, second) {
+		t.Errorf("expected stable rule names, got %v and %v", first, second)
+	}
+}
@@ -0,0 +1,318 @@
+package exportpolicies
+
+import (
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/sets"
+	psapi "k8s.io/pod-security-admission/api"
+	"k8s.io/pod-security-admission/policy"
+)
+
+// vapCheck is the CEL equivalent of a PodSecurity check
+type vapCheck struct {
+	id         policy.CheckID
+	level      psapi.Level
+	expression string
+	message    string
+}
+
+// vapContainers are all the containers of the pod, the expressions of the checks refer to them
+// as variables.containers
+const vapContainers = "object.spec.containers" +
+	" + (has(object.spec.initContainers) ? object.spec.initContainers : [])" +
+	" + (has(object.spec.ephemeralContainers) ? object.spec.ephemeralContainers : [])"
+
+// vapWindows matches the Windows pods, some of the restricted checks do not apply to them
+const vapWindows = "(has(object.spec.os) && object.spec.os.name == 'windows')"
+
+// the expressions follow the latest version of the Pod Security Standards
+var vapChecks = []vapCheck{
+	{
+		id: "hostNamespaces", level: psapi.LevelBaseline,
+		expression: "!(has(object.spec.hostNetwork) && object.spec.hostNetwork) && " +
+			"!(has(object.spec.hostPID) && object.spec.hostPID) && " +
+			"!(has(object.spec.hostIPC) && object.spec.hostIPC)",
+		message: "host namespaces are forbidden",
+	},
+	{
+		id: "privileged", level: psapi.LevelBaseline,
+		expression: "variables.containers.all(c, !has(c.securityContext) || !has(c.securityContext.privileged) || !c.securityContext.privileged)",
+		message:    "privileged containers are forbidden",
+	},
+	{
+		id: "capabilities_baseline", level: psapi.LevelBaseline,
+		expression: "variables.containers.all(c, !has(c.securityContext) || !has(c.securityContext.capabilities) || " +
+			"!has(c.securityContext.capabilities.add) || c.securityContext.capabilities.add.all(cap, cap in " + celList(baselineCapabilities) + "))",
+		message: "adding capabilities beyond the default set is forbidden",
+	},
+	{
+		id: "hostPathVolumes", level: psapi.LevelBaseline,
+		expression: "!has(object.spec.volumes) || object.spec.volumes.all(v, !has(v.hostPath))",
+		message:    "hostPath volumes are forbidden",
+	},
+	{
+		id: "hostPorts", level: psapi.LevelBaseline,
+		expression: "variables.containers.all(c, !has(c.ports) || c.ports.all(p, !has(p.hostPort) || p.hostPort == 0))",
+		message:    "host ports are forbidden",
+	},
+	{
+		id: "appArmorProfile", level: psapi.LevelBaseline,
+		expression: "(!has(object.metadata.annotations) || object.metadata.annotations.all(k, " +
+			"!k.startsWith('container.apparmor.security.beta.kubernetes.io/') || " +
+			"object.metadata.annotations[k] == 'runtime/default' || object.metadata.annotations[k].startsWith('localhost/'))) && " +
+			"!(has(object.spec.securityContext) && has(object.spec.securityContext.appArmorProfile) && " +
+			"object.spec.securityContext.appArmorProfile.type == 'Unconfined') && " +
+			"variables.containers.all(c, !has(c.securityContext) || !has(c.securityContext.appArmorProfile) || " +
+			"c.securityContext.appArmorProfile.type != 'Unconfined')",
+		message: "the Unconfined AppArmor profile is forbidden",
+	},
+	{
+		id: "seLinuxOptions", level: psapi.LevelBaseline,
+		expression: "(!has(object.spec.securityContext) || !has(object.spec.securityContext.seLinuxOptions) || (" +
+			vapSELinux("object.spec.securityContext.seLinuxOptions") + ")) && " +
+			"variables.containers.all(c, !has(c.securityContext) || !has(c.securityContext.seLinuxOptions) || (" +
+			vapSELinux("c.securityContext.seLinuxOptions") + "))",
+		message: "custom SELinux users, roles and types are forbidden",
+	},
+	{
+		id: "procMount", level: psapi.LevelBaseline,
+		expression: "variables.containers.all(c, !has(c.securityContext) || !has(c.securityContext.procMount) || c.securityContext.procMount == 'Default')",
+		message:    "non-default /proc mount types are forbidden",
+	},
+	{
+		id: "seccompProfile_baseline", level: psapi.LevelBaseline,
+		expression: "!(has(object.spec.securityContext) && has(object.spec.securityContext.seccompProfile) && " +
+			"object.spec.securityContext.seccompProfile.type == 'Unconfined') && " +
+			"variables.containers.all(c, !has(c.securityContext) || !has(c.securityContext.seccompProfile) || " +
+			"c.securityContext.seccompProfile.type != 'Unconfined')",
+		message: "the Unconfined seccomp profile is forbidden",
+	},
+	{
+		id: "sysctls", level: psapi.LevelBaseline,
+		expression: "!has(object.spec.securityContext) || !has(object.spec.securityContext.sysctls) || " +
+			"object.spec.securityContext.sysctls.all(s, s.name in " + celList(safeSysctls) + ")",
+		message: "unsafe sysctls are forbidden",
+	},
+	{
+		id: "windowsHostProcess", level: psapi.LevelBaseline,
+		expression: "!(has(object.spec.securityContext) && has(object.spec.securityContext.windowsOptions) && " +
+			"has(object.spec.securityContext.windowsOptions.hostProcess) && object.spec.securityContext.windowsOptions.hostProcess) && " +
+			"variables.containers.all(c, !has(c.securityContext) || !has(c.securityContext.windowsOptions) || " +
+			"!has(c.securityContext.windowsOptions.hostProcess) || !c.securityContext.windowsOptions.hostProcess)",
+		message: "Windows HostProcess containers are forbidden",
+	},
+	{
+		id: "restrictedVolumes", level: psapi.LevelRestricted,
+		expression: "!has(object.spec.volumes) || object.spec.volumes.all(v, " + vapVolumeTypes() + ")",
+		message:    "only " + strings.Join(restrictedVolumes, ", ") + " volumes are allowed",
+	},
+	{
+		id: "allowPrivilegeEscalation", level: psapi.LevelRestricted,
+		expression: vapWindows + " || " +
+			"variables.containers.all(c, has(c.securityContext) && has(c.securityContext.allowPrivilegeEscalation) && " +
+			"c.securityContext.allowPrivilegeEscalation == false)",
+		message: "containers must set securityContext.allowPrivilegeEscalation=false",
+	},
+	{
+		id: "runAsNonRoot", level: psapi.LevelRestricted,
+		expression: "!(has(object.spec.securityContext) && has(object.spec.securityContext.runAsNonRoot) && " +
+			"object.spec.securityContext.runAsNonRoot == false) && " +
+			"variables.containers.all(c, has(c.securityContext) && has(c.securityContext.runAsNonRoot) ? " +
+			"c.securityContext.runAsNonRoot : " +
+			"has(object.spec.securityContext) && has(object.spec.securityContext.runAsNonRoot) && object.spec.securityContext.runAsNonRoot)",
+		message: "pods or all their containers must set securityContext.runAsNonRoot=true",
+	},
+	{
+		id: "runAsUser", level: psapi.LevelRestricted,
+		expression: "!(has(object.spec.securityContext) && has(object.spec.securityContext.runAsUser) && " +
+			"object.spec.securityContext.runAsUser == 0) && " +
+			"variables.containers.all(c, !has(c.securityContext) || !has(c.securityContext.runAsUser) || c.securityContext.runAsUser != 0)",
+		message: "running as the root user is forbidden",
+	},
+	{
+		id: "seccompProfile_restricted", level: psapi.LevelRestricted,
+		expression: vapWindows + " || " +
+			"(!(has(object.spec.securityContext) && has(object.spec.securityContext.seccompProfile) && " +
+			"!(object.spec.securityContext.seccompProfile.type in ['RuntimeDefault', 'Localhost'])) && " +
+			"variables.containers.all(c, has(c.securityContext) && has(c.securityContext.seccompProfile) ? " +
+			"c.securityContext.seccompProfile.type in ['RuntimeDefault', 'Localhost'] : " +
+			"has(object.spec.securityContext) && has(object.spec.securityContext.seccompProfile) && " +
+			"object.spec.securityContext.seccompProfile.type in ['RuntimeDefault', 'Localhost']))",
+		message: "pods or all their containers must set the RuntimeDefault or Localhost seccomp profile",
+	},
+	{
+		id: "capabilities_restricted", level: psapi.LevelRestricted,
+		expression: vapWindows + " || " +
+			"variables.containers.all(c, has(c.securityContext) && has(c.securityContext.capabilities) && " +
+			"has(c.securityContext.capabilities.drop) && 'ALL' in c.securityContext.capabilities.drop && " +
+			"(!has(c.securityContext.capabilities.add) || c.securityContext.capabilities.add.all(cap, cap == 'NET_BIND_SERVICE')))",
+		message: "containers must drop ALL capabilities and may only add NET_BIND_SERVICE",
+	},
+}
+
+// vapPolicies creates a ValidatingAdmissionPolicy with the checks of each of the levels bound to the
+// groups of namespaces sharing the level. The namespaces with exceptions get a policy of their own
+// whose match conditions leave out the exempted workloads, each of the exempted workloads gets a
+// policy that matches only its pods and skips the checks it fails.
+func vapPolicies(plans []NamespacePolicy, audit bool) []*unstructured.Unstructured {
+	actions := []interface{}{"Deny"}
+	if audit {
+		actions = []interface{}{"Audit", "Warn"}
+	}
+
+	var objs []*unstructured.Unstructured
+	groups, withExceptions := groupByLevel(plans)
+	for _, g := range groups {
+		name := objectName("psachecker", string(g.Level))
+		objs = append(objs,
+			vapPolicy(name, g.Level, nil, nil),
+			vapBinding(name, g.Namespaces, actions),
+		)
+	}
+
+	for _, p := range withExceptions {
+		var exempted []string
+		for _, e := range p.Exceptions {
+			match := vapPodsMatch(&e)
+			exempted = append(exempted, "("+match+")")
+
+			name := objectName("psachecker", p.Namespace, e.Kind, e.Name)
+			condition := map[string]interface{}{
+				"name":       "exempted-pods",
+				"expression": match,
+			}
+			// a workload failing all the checks of the level is left without a policy
+			if policy := vapPolicy(name, p.Level, sets.NewString(checkStrings(e.Checks)...), condition); policy != nil {
+				objs = append(objs, policy, vapBinding(name, []string{p.Namespace}, actions))
+			}
+		}
+
+		name := objectName("psachecker", p.Namespace, string(p.Level))
+		condition := map[string]interface{}{
+			"name":       "not-exempted-pods",
+			"expression": "!(" + strings.Join(exempted, " || ") + ")",
+		}
+		objs = append(objs,
+			vapPolicy(name, p.Level, nil, condition),
+			vapBinding(name, []string{p.Namespace}, actions),
+		)
+	}
+
+	return objs
+}
+
+// vapPolicy creates a policy that validates the pods against the checks of the level
+// except for the skipped ones, it returns nil if no checks remain
+func vapPolicy(name string, level psapi.Level, skipped sets.String, matchCondition map[string]interface{}) *unstructured.Unstructured {
+	var validations []interface{}
+	for _, c := range vapChecks {
+		if psapi.CompareLevels(c.level, level) > 0 || skipped.Has(string(c.id)) {
+			continue
+		}
+		validations = append(validations, map[string]interface{}{
+			"expression": c.expression,
+			"message":    string(c.id) + ": " + c.message,
+			"reason":     "Forbidden",
+		})
+	}
+	if len(validations) == 0 {
+		return nil
+	}
+
+	spec := map[string]interface{}{
+		"failurePolicy": "Fail",
+		"matchConstraints": map[string]interface{}{
+			"resourceRules": []interface{}{
+				map[string]interface{}{
+					"apiGroups":   []interface{}{""},
+					"apiVersions": []interface{}{"v1"},
+					"operations":  []interface{}{"CREATE"},
+					"resources":   []interface{}{"pods"},
+				},
+			},
+		},
+		"variables": []interface{}{
+			map[string]interface{}{"name": "containers", "expression": vapContainers},
+		},
+		"validations": validations,
+	}
+	if matchCondition != nil {
+		spec["matchConditions"] = []interface{}{matchCondition}
+	}
+
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "admissionregistration.k8s.io/v1",
+		"kind":       "ValidatingAdmissionPolicy",
+		"metadata": map[string]interface{}{
+			"name": name,
+			"annotations": map[string]interface{}{
+				"psachecker.io/level": string(level),
+			},
+		},
+		"spec": spec,
+	}}
+}
+
+func vapBinding(policyName string, namespaces []string, actions []interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "admissionregistration.k8s.io/v1",
+		"kind":       "ValidatingAdmissionPolicyBinding",
+		"metadata":   map[string]interface{}{"name": policyName},
+		"spec": map[string]interface{}{
+			"policyName":        policyName,
+			"validationActions": actions,
+			"matchResources": map[string]interface{}{
+				"namespaceSelector": namespaceSelector(namespaces),
+			},
+		},
+	}}
+}
+
+// vapPodsMatch returns an expression matching the pods of the exempted workload by their labels,
+// or by their names if the workload has no stable labels
+func vapPodsMatch(e *Exception) string {
+	var matches []string
+	if len(e.PodLabels) > 0 {
+		for _, k := range sets.StringKeySet(e.PodLabels).List() {
+			label := "object.metadata.labels[" + celString(k) + "]"
+			matches = append(matches, celString(k)+" in object.metadata.labels && "+label+" == "+celString(e.PodLabels[k]))
+		}
+		return "has(object.metadata.labels) && " + strings.Join(matches, " && ")
+	}
+
+	for _, n := range e.podNames() {
+		if prefix := strings.TrimSuffix(n, "*"); prefix != n {
+			matches = append(matches, "object.metadata.name.startsWith("+celString(prefix)+")")
+			continue
+		}
+		matches = append(matches, "object.metadata.name == "+celString(n))
+	}
+	return strings.Join(matches, " || ")
+}
+
+func vapSELinux(options string) string {
+	return "(!has(" + options + ".type) || " + options + ".type in ['', 'container_t', 'container_init_t', 'container_kvm_t']) && " +
+		"(!has(" + options + ".user) || " + options + ".user == '') && " +
+		"(!has(" + options + ".role) || " + options + ".role == '')"
+}
+
+func vapVolumeTypes() string {
+	var types []string
+	for _, v := range restrictedVolumes {
+		types = append(types, "has(v."+v+")")
+	}
+	return strings.Join(types, " || ")
+}
+
+func celList(values []string) string {
+	quoted := make([]string, 0, len(values))
+	for _, v := range values {
+		quoted = append(quoted, celString(v))
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+func celString(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
@@ -0,0 +1,229 @@
+package exportpolicies
+
+import (
+	"testing"
+
+	"github.com/google/cel-go/cel"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/pod-security-admission/policy"
+)
+
+func boolPtr(b bool) *bool { return &b }
+
+func int64Ptr(i int64) *int64 { return &i }
+
+// restrictedPod passes all the checks of the restricted level
+func restrictedPod() *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "app"},
+		Spec: corev1.PodSpec{
+			SecurityContext: &corev1.PodSecurityContext{
+				RunAsNonRoot:   boolPtr(true),
+				SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+			},
+			InitContainers: []corev1.Container{restrictedContainer("init")},
+			Containers:     []corev1.Container{restrictedContainer("app")},
+			Volumes: []corev1.Volume{{
+				Name:         "data",
+				VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+			}},
+		},
+	}
+}
+
+func restrictedContainer(name string) corev1.Container {
+	return corev1.Container{
+		Name:  name,
+		Image: name,
+		SecurityContext: &corev1.SecurityContext{
+			AllowPrivilegeEscalation: boolPtr(false),
+			Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+		},
+	}
+}
+
+// vapTestPods change the restricted pod so that it fails or passes each of the checks
+var vapTestPods = map[string]func(pod *corev1.Pod){
+	"restricted":   func(pod *corev1.Pod) {},
+	"hostNetwork":  func(pod *corev1.Pod) { pod.Spec.HostNetwork = true },
+	"hostPID":      func(pod *corev1.Pod) { pod.Spec.HostPID = true },
+	"privileged":   func(pod *corev1.Pod) { pod.Spec.Containers[0].SecurityContext.Privileged = boolPtr(true) },
+	"unprivileged": func(pod *corev1.Pod) { pod.Spec.Containers[0].SecurityContext.Privileged = boolPtr(false) },
+	"baseline capability": func(pod *corev1.Pod) {
+		pod.Spec.Containers[0].SecurityContext.Capabilities.Add = []corev1.Capability{"CHOWN"}
+	},
+	"NET_BIND_SERVICE capability": func(pod *corev1.Pod) {
+		pod.Spec.Containers[0].SecurityContext.Capabilities.Add = []corev1.Capability{"NET_BIND_SERVICE"}
+	},
+	"NET_ADMIN capability": func(pod *corev1.Pod) {
+		pod.Spec.InitContainers[0].SecurityContext.Capabilities.Add = []corev1.Capability{"NET_ADMIN"}
+	},
+	"no dropped capabilities": func(pod *corev1.Pod) { pod.Spec.Containers[0].SecurityContext.Capabilities = nil },
+	"hostPath volume": func(pod *corev1.Pod) {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{Name: "host", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/"}}})
+	},
+	"nfs volume": func(pod *corev1.Pod) {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{Name: "nfs", VolumeSource: corev1.VolumeSource{NFS: &corev1.NFSVolumeSource{Server: "nfs", Path: "/"}}})
+	},
+	"hostPort": func(pod *corev1.Pod) {
+		pod.Spec.Containers[0].Ports = []corev1.ContainerPort{{ContainerPort: 80, HostPort: 80}}
+	},
+	"containerPort": func(pod *corev1.Pod) { pod.Spec.Containers[0].Ports = []corev1.ContainerPort{{ContainerPort: 80}} },
+	"unconfined AppArmor": func(pod *corev1.Pod) {
+		pod.Annotations = map[string]string{corev1.AppArmorBetaContainerAnnotationKeyPrefix + "app": corev1.AppArmorBetaProfileNameUnconfined}
+	},
+	"localhost AppArmor": func(pod *corev1.Pod) {
+		pod.Annotations = map[string]string{corev1.AppArmorBetaContainerAnnotationKeyPrefix + "app": corev1.AppArmorBetaProfileNamePrefix + "custom"}
+	},
+	"SELinux type": func(pod *corev1.Pod) {
+		pod.Spec.SecurityContext.SELinuxOptions = &corev1.SELinuxOptions{Type: "spc_t"}
+	},
+	"SELinux level": func(pod *corev1.Pod) {
+		pod.Spec.Containers[0].SecurityContext.SELinuxOptions = &corev1.SELinuxOptions{Type: "container_t", Level: "s0:c1,c2"}
+	},
+	"SELinux user": func(pod *corev1.Pod) {
+		pod.Spec.Containers[0].SecurityContext.SELinuxOptions = &corev1.SELinuxOptions{User: "root"}
+	},
+	"unmasked procMount": func(pod *corev1.Pod) {
+		procMount := corev1.UnmaskedProcMount
+		pod.Spec.Containers[0].SecurityContext.ProcMount = &procMount
+	},
+	"unconfined pod seccomp": func(pod *corev1.Pod) {
+		pod.Spec.SecurityContext.SeccompProfile.Type = corev1.SeccompProfileTypeUnconfined
+	},
+	"unconfined container seccomp": func(pod *corev1.Pod) {
+		pod.Spec.Containers[0].SecurityContext.SeccompProfile = &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeUnconfined}
+	},
+	"no seccomp": func(pod *corev1.Pod) { pod.Spec.SecurityContext.SeccompProfile = nil },
+	"container seccomp only": func(pod *corev1.Pod) {
+		pod.Spec.SecurityContext.SeccompProfile = nil
+		for i := range pod.Spec.InitContainers {
+			pod.Spec.InitContainers[i].SecurityContext.SeccompProfile = &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault}
+		}
+		for i := range pod.Spec.Containers {
+			pod.Spec.Containers[i].SecurityContext.SeccompProfile = &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeLocalhost}
+		}
+	},
+	"safe sysctls": func(pod *corev1.Pod) {
+		pod.Spec.SecurityContext.Sysctls = []corev1.Sysctl{
+			{Name: "net.ipv4.ip_local_reserved_ports", Value: "8080"},
+			{Name: "net.ipv4.ping_group_range", Value: "0 0"},
+		}
+	},
+	"unsafe sysctl": func(pod *corev1.Pod) {
+		pod.Spec.SecurityContext.Sysctls = []corev1.Sysctl{{Name: "kernel.msgmax", Value: "1"}}
+	},
+	"hostProcess": func(pod *corev1.Pod) {
+		pod.Spec.Containers[0].SecurityContext.WindowsOptions = &corev1.WindowsSecurityContextOptions{HostProcess: boolPtr(true)}
+	},
+	"privilege escalation": func(pod *corev1.Pod) {
+		pod.Spec.InitContainers[0].SecurityContext.AllowPrivilegeEscalation = boolPtr(true)
+	},
+	"unset privilege escalation": func(pod *corev1.Pod) {
+		pod.Spec.Containers[0].SecurityContext.AllowPrivilegeEscalation = nil
+	},
+	"container runAsNonRoot=false": func(pod *corev1.Pod) {
+		pod.Spec.Containers[0].SecurityContext.RunAsNonRoot = boolPtr(false)
+	},
+	"container runAsNonRoot only": func(pod *corev1.Pod) {
+		pod.Spec.SecurityContext.RunAsNonRoot = nil
+		pod.Spec.InitContainers[0].SecurityContext.RunAsNonRoot = boolPtr(true)
+		pod.Spec.Containers[0].SecurityContext.RunAsNonRoot = boolPtr(true)
+	},
+	"pod runAsNonRoot=false overridden by the containers": func(pod *corev1.Pod) {
+		pod.Spec.SecurityContext.RunAsNonRoot = boolPtr(false)
+		for i := range pod.Spec.InitContainers {
+			pod.Spec.InitContainers[i].SecurityContext.RunAsNonRoot = boolPtr(true)
+		}
+		for i := range pod.Spec.Containers {
+			pod.Spec.Containers[i].SecurityContext.RunAsNonRoot = boolPtr(true)
+		}
+	},
+	"unconfined pod seccomp overridden by the containers": func(pod *corev1.Pod) {
+		pod.Spec.SecurityContext.SeccompProfile.Type = corev1.SeccompProfileTypeUnconfined
+		for i := range pod.Spec.InitContainers {
+			pod.Spec.InitContainers[i].SecurityContext.SeccompProfile = &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault}
+		}
+		for i := range pod.Spec.Containers {
+			pod.Spec.Containers[i].SecurityContext.SeccompProfile = &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault}
+		}
+	},
+	"root user":     func(pod *corev1.Pod) { pod.Spec.SecurityContext.RunAsUser = int64Ptr(0) },
+	"non-root user": func(pod *corev1.Pod) { pod.Spec.Containers[0].SecurityContext.RunAsUser = int64Ptr(1000) },
+	"no container securityContext": func(pod *corev1.Pod) {
+		pod.Spec.Containers[0].SecurityContext = nil
+	},
+	"windows without restricted fields": func(pod *corev1.Pod) {
+		pod.Spec.OS = &corev1.PodOS{Name: corev1.Windows}
+		pod.Spec.SecurityContext.SeccompProfile = nil
+		pod.Spec.Containers[0].SecurityContext = nil
+	},
+	"linux without restricted fields": func(pod *corev1.Pod) {
+		pod.Spec.OS = &corev1.PodOS{Name: corev1.Linux}
+		pod.Spec.SecurityContext.SeccompProfile = nil
+		pod.Spec.Containers[0].SecurityContext = nil
+	},
+}
+
+// TestVAPChecksMatchPodSecurity compiles the CEL expressions of the checks and makes sure
+// that they allow and forbid the same pods as the latest version of the PodSecurity checks
+func TestVAPChecksMatchPodSecurity(t *testing.T) {
+	env, err := cel.NewEnv(
+		cel.Variable("object", cel.DynType),
+		cel.Variable("variables", cel.DynType),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compile := func(expression string) cel.Program {
+		ast, issues := env.Compile(expression)
+		if issues != nil && issues.Err() != nil {
+			t.Fatalf("failed to compile %q: %v", expression, issues.Err())
+		}
+		program, err := env.Program(ast)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return program
+	}
+	eval := func(program cel.Program, vars map[string]interface{}) interface{} {
+		out, _, err := program.Eval(vars)
+		if err != nil {
+			t.Fatalf("failed to evaluate: %v", err)
+		}
+		return out.Value()
+	}
+
+	checks := map[policy.CheckID]policy.CheckPodFn{}
+	for _, c := range policy.DefaultChecks() {
+		checks[c.ID] = c.Versions[len(c.Versions)-1].CheckPod
+	}
+
+	containers := compile(vapContainers)
+	for _, c := range vapChecks {
+		check, ok := checks[c.id]
+		if !ok {
+			t.Errorf("unknown check %s", c.id)
+			continue
+		}
+		program := compile(c.expression)
+
+		for name, mutate := range vapTestPods {
+			pod := restrictedPod()
+			mutate(pod)
+			object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(pod)
+			if err != nil {
+				t.Fatal(err)
+			}
+			vars := map[string]interface{}{"object": object}
+			vars["variables"] = map[string]interface{}{"containers": eval(containers, vars)}
+
+			expected := check(&pod.ObjectMeta, &pod.Spec).Allowed
+			if got := eval(program, vars); got != expected {
+				t.Errorf("%s check, %s pod: expected allowed=%v, got %v", c.id, name, expected, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package history
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+func NewHistoryCommand(clientConfigOptions *genericclioptions.ConfigFlags) *cobra.Command {
+	o := newHistoryOptions()
+
+	cmd := &cobra.Command{
+		Use:          "history --history-dir <dir> [flags]",
+		Short:        "show how the PodSecurity levels of namespaces evolved across the stored inspect-cluster runs",
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			o.Complete(clientConfigOptions)
+			errs := o.Validate()
+			if len(errs) > 0 {
+				return fmt.Errorf("there were errors while setting up the command: %v", errs)
+			}
+
+			return o.Run(c.OutOrStdout())
+		},
+	}
+
+	o.AddFlags(cmd)
+	return cmd
+}
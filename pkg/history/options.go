@@ -0,0 +1,94 @@
+package history
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+type HistoryOptions struct {
+	historyDir string
+	namespace  string
+}
+
+func newHistoryOptions() *HistoryOptions {
+	return &HistoryOptions{}
+}
+
+func (o *HistoryOptions) AddFlags(cmd *cobra.Command) {
+	AddHistoryDirFlag(cmd, &o.historyDir)
+}
+
+// AddHistoryDirFlag adds the flag pointing to the directory with the stored inspection results
+func AddHistoryDirFlag(cmd *cobra.Command, dir *string) {
+	cmd.Flags().StringVar(dir, "history-dir", "", "Directory in which the results of the inspect-cluster runs are stored.")
+}
+
+func (o *HistoryOptions) Complete(clientConfigOptions *genericclioptions.ConfigFlags) {
+	if clientConfigOptions.Namespace != nil {
+		o.namespace = *clientConfigOptions.Namespace
+	}
+}
+
+func (o *HistoryOptions) Validate() []error {
+	errs := []error{}
+
+	if len(o.historyDir) == 0 {
+		errs = append(errs, fmt.Errorf("--history-dir must be set"))
+	}
+
+	return errs
+}
+
+func (o *HistoryOptions) Run(out io.Writer) error {
+	snapshots, err := NewStore(o.historyDir).Load()
+	if err != nil {
+		return err
+	}
+	if len(snapshots) == 0 {
+		return fmt.Errorf("no inspection results found in %q", o.historyDir)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tTIME\tENFORCE\tRECOMMENDED\tVIOLATING RESTRICTED")
+	for _, trend := range Trends(snapshots) {
+		if len(o.namespace) > 0 && trend.Namespace != o.namespace {
+			continue
+		}
+		for _, p := range trend.Points {
+			enforce := p.Enforce
+			if len(enforce) == 0 {
+				enforce = "<unset>"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\n", trend.Namespace, p.Timestamp.Format(time.RFC3339), enforce, p.Recommended, p.Violating)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if len(snapshots) < 2 {
+		return nil
+	}
+
+	previous, last := snapshots[len(snapshots)-2], snapshots[len(snapshots)-1]
+	fmt.Fprintf(out, "\nRegressions since %s:\n", previous.Timestamp.Format(time.RFC3339))
+	found := false
+	for _, r := range Regressions(previous, last) {
+		if len(o.namespace) > 0 && r.Namespace != o.namespace {
+			continue
+		}
+		found = true
+		fmt.Fprintf(out, "  %s\n", r)
+	}
+	if !found {
+		fmt.Fprintln(out, "  none")
+	}
+
+	return nil
+}
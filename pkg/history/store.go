@@ -0,0 +1,76 @@
+package history
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/stlaz/psachecker/pkg/snapshot"
+)
+
+const (
+	snapshotFilePrefix = "inspection-"
+	snapshotFileSuffix = ".json"
+	// snapshotTimeFormat is a sortable, filename-safe variant of RFC3339 with nanosecond precision
+	snapshotTimeFormat = "20060102T150405.000000000Z"
+)
+
+// Store keeps the results of the cluster inspections as timestamped JSON files in a directory
+type Store struct {
+	dir string
+}
+
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// Save writes the snapshot into the store, creating the store directory if necessary.
+// Snapshots taken at the same time are stored side by side rather than overwritten.
+func (s *Store) Save(snap *snapshot.Snapshot) error {
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create the history directory: %w", err)
+	}
+
+	name := snapshotFilePrefix + snap.Timestamp.UTC().Format(snapshotTimeFormat)
+	path := filepath.Join(s.dir, name+snapshotFileSuffix)
+	for i := 1; fileExists(path); i++ {
+		path = filepath.Join(s.dir, fmt.Sprintf("%s-%d%s", name, i, snapshotFileSuffix))
+	}
+	if err := snap.WriteFile(path); err != nil {
+		return fmt.Errorf("failed to save the inspection results: %w", err)
+	}
+	return nil
+}
+
+// Load returns all the snapshots from the store, oldest first.
+func (s *Store) Load() ([]*snapshot.Snapshot, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the history directory: %w", err)
+	}
+
+	var snapshots []*snapshot.Snapshot
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), snapshotFilePrefix) || !strings.HasSuffix(e.Name(), snapshotFileSuffix) {
+			continue
+		}
+
+		snap, err := snapshot.ReadFile(filepath.Join(s.dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snap)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Timestamp.Before(snapshots[j].Timestamp)
+	})
+	return snapshots, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
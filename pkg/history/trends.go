@@ -0,0 +1,108 @@
+package history
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	psapi "k8s.io/pod-security-admission/api"
+
+	"github.com/stlaz/psachecker/pkg/admission"
+	"github.com/stlaz/psachecker/pkg/snapshot"
+)
+
+// TrendPoint is the state of a namespace in a single inspection run
+type TrendPoint struct {
+	Timestamp   time.Time
+	Enforce     string
+	Recommended psapi.Level
+	// Violating is the number of workloads that would be rejected by the restricted level
+	Violating int
+}
+
+type NamespaceTrend struct {
+	Namespace string
+	Points    []TrendPoint
+}
+
+// Trends groups the data points of the snapshots per namespace, the snapshots are expected
+// to be sorted from the oldest.
+func Trends(snapshots []*snapshot.Snapshot) []NamespaceTrend {
+	trends := map[string]*NamespaceTrend{}
+	for _, snap := range snapshots {
+		for _, ns := range snap.Namespaces {
+			t, ok := trends[ns.Name]
+			if !ok {
+				t = &NamespaceTrend{Namespace: ns.Name}
+				trends[ns.Name] = t
+			}
+			t.Points = append(t.Points, TrendPoint{
+				Timestamp:   snap.Timestamp,
+				Enforce:     ns.Labels[psapi.EnforceLevelLabel],
+				Recommended: ns.RecommendedLevel,
+				Violating:   len(ns.WorkloadsFailingLevel(psapi.LevelRestricted)),
+			})
+		}
+	}
+
+	ret := make([]NamespaceTrend, 0, len(trends))
+	for _, t := range trends {
+		ret = append(ret, *t)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Namespace < ret[j].Namespace })
+	return ret
+}
+
+// Regression describes a change of a namespace for the worse
+type Regression struct {
+	Namespace   string
+	Description string
+}
+
+func (r Regression) String() string {
+	return r.Namespace + ": " + r.Description
+}
+
+// Regressions lists the changes for the worse between the two snapshots
+func Regressions(previous, last *snapshot.Snapshot) []Regression {
+	var regressions []Regression
+	for _, ns := range last.Namespaces {
+		prevNS := previous.Namespace(ns.Name)
+		if prevNS == nil {
+			continue
+		}
+
+		if psapi.CompareLevels(ns.RecommendedLevel, prevNS.RecommendedLevel) < 0 {
+			regressions = append(regressions, Regression{
+				Namespace:   ns.Name,
+				Description: fmt.Sprintf("recommended level dropped from %q to %q", prevNS.RecommendedLevel, ns.RecommendedLevel),
+			})
+		}
+
+		for _, w := range newlyFailing(prevNS, ns, psapi.LevelRestricted) {
+			regressions = append(regressions, Regression{
+				Namespace:   ns.Name,
+				Description: fmt.Sprintf("%s newly requires %q", w.String(), w.MinimalLevel),
+			})
+		}
+	}
+	return regressions
+}
+
+// newlyFailing returns the workloads of the current result that fail the level and
+// that either did not exist or did not fail the level in the previous result. The workloads
+// are matched by their top-level owners so that rollouts do not show up as regressions.
+func newlyFailing(previous, current *admission.NamespaceResult, level psapi.Level) []admission.WorkloadResult {
+	previouslyFailing := map[string]bool{}
+	for _, w := range previous.WorkloadsFailingLevel(level) {
+		previouslyFailing[w.OwnerKey()] = true
+	}
+
+	var ret []admission.WorkloadResult
+	for _, w := range current.WorkloadsFailingLevel(level) {
+		if !previouslyFailing[w.OwnerKey()] {
+			ret = append(ret, w)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,34 @@
+package metricsexporter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+func NewMetricsExporterCommand(clientConfigOptions *genericclioptions.ConfigFlags) *cobra.Command {
+	o := newMetricsExporterOptions()
+
+	cmd := &cobra.Command{
+		Use:          "serve-metrics [flags]",
+		Short:        "periodically inspect the cluster and expose the results as Prometheus metrics",
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(c, clientConfigOptions); err != nil {
+				return err
+			}
+			errs := o.Validate()
+			if len(errs) > 0 {
+				return fmt.Errorf("there were errors while setting up the command: %v", errs)
+			}
+
+			return o.Run(context.Background())
+		},
+	}
+
+	o.AddFlags(cmd)
+	return cmd
+}
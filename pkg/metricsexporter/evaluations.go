@@ -0,0 +1,46 @@
+package metricsexporter
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/stlaz/psachecker/pkg/admission"
+)
+
+var (
+	evaluationsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "admission", "evaluations_total"),
+		"Number of PodSecurity policy evaluations performed while inspecting the cluster.",
+		[]string{"decision", "policy_level", "policy_version", "mode"}, nil,
+	)
+	errorsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "admission", "errors_total"),
+		"Number of errors that occurred during the PodSecurity evaluations.",
+		[]string{"fatal"}, nil,
+	)
+)
+
+// evaluationsCollector exposes the counts of an admission.EvaluationCounter as Prometheus counters
+type evaluationsCollector struct {
+	counts func() admission.EvaluationCounts
+}
+
+var _ prometheus.Collector = &evaluationsCollector{}
+
+func (c *evaluationsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- evaluationsDesc
+	ch <- errorsDesc
+}
+
+func (c *evaluationsCollector) Collect(ch chan<- prometheus.Metric) {
+	counts := c.counts()
+
+	for k, v := range counts.Evaluations {
+		ch <- prometheus.MustNewConstMetric(evaluationsDesc, prometheus.CounterValue, float64(v),
+			string(k.Decision), string(k.Level), k.Version, string(k.Mode),
+		)
+	}
+	ch <- prometheus.MustNewConstMetric(errorsDesc, prometheus.CounterValue, float64(counts.FatalErrors), strconv.FormatBool(true))
+	ch <- prometheus.MustNewConstMetric(errorsDesc, prometheus.CounterValue, float64(counts.Errors-counts.FatalErrors), strconv.FormatBool(false))
+}
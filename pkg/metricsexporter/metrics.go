@@ -0,0 +1,106 @@
+package metricsexporter
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	psapi "k8s.io/pod-security-admission/api"
+
+	"github.com/stlaz/psachecker/pkg/admission"
+)
+
+const metricsNamespace = "psachecker"
+
+type inspectionMetrics struct {
+	registry *prometheus.Registry
+
+	namespaceWorkloads      *prometheus.GaugeVec
+	namespaceLooserLabels   *prometheus.GaugeVec
+	namespaceFailingAtLevel *prometheus.GaugeVec
+	failedChecks            *prometheus.GaugeVec
+
+	lastInspectionSuccess   prometheus.Gauge
+	lastInspectionTimestamp prometheus.Gauge
+}
+
+func newInspectionMetrics(evaluationCounts func() admission.EvaluationCounts) *inspectionMetrics {
+	m := &inspectionMetrics{
+		registry: prometheus.NewRegistry(),
+
+		namespaceWorkloads: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "namespace_workloads",
+			Help:      "Number of workloads in a namespace by the least privileged PodSecurity level they are able to run with.",
+		}, []string{"namespace", "level"}),
+		namespaceLooserLabels: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "namespace_enforce_looser_than_needed",
+			Help:      "Set to 1 if the namespace enforces a less restrictive PodSecurity level than its workloads would pass.",
+		}, []string{"namespace"}),
+		namespaceFailingAtLevel: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "namespace_workloads_failing_level",
+			Help:      "Number of workloads in a namespace that would be rejected if the namespace enforced the given PodSecurity level.",
+		}, []string{"namespace", "level"}),
+		failedChecks: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "workloads_failed_checks",
+			Help:      "Number of workloads in the cluster failing the given PodSecurity check.",
+		}, []string{"check"}),
+
+		lastInspectionSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "last_inspection_success",
+			Help:      "Set to 1 if the last inspection of the cluster succeeded.",
+		}),
+		lastInspectionTimestamp: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "last_inspection_timestamp_seconds",
+			Help:      "Time of the last successful inspection of the cluster.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		m.namespaceWorkloads,
+		m.namespaceLooserLabels,
+		m.namespaceFailingAtLevel,
+		m.failedChecks,
+		m.lastInspectionSuccess,
+		m.lastInspectionTimestamp,
+		&evaluationsCollector{counts: evaluationCounts},
+	)
+
+	return m
+}
+
+// update replaces the values of all the inspection gauges with those computed from the results
+func (m *inspectionMetrics) update(results []*admission.NamespaceResult) {
+	m.namespaceWorkloads.Reset()
+	m.namespaceLooserLabels.Reset()
+	m.namespaceFailingAtLevel.Reset()
+	m.failedChecks.Reset()
+
+	for _, ns := range results {
+		for _, level := range []psapi.Level{psapi.LevelPrivileged, psapi.LevelBaseline, psapi.LevelRestricted} {
+			m.namespaceWorkloads.WithLabelValues(ns.Name, string(level))
+			m.namespaceFailingAtLevel.WithLabelValues(ns.Name, string(level))
+		}
+
+		var looser float64
+		if psapi.CompareLevels(ns.EnforceLevel(), ns.RecommendedLevel) < 0 {
+			looser = 1
+		}
+		m.namespaceLooserLabels.WithLabelValues(ns.Name).Set(looser)
+
+		for _, w := range ns.Workloads {
+			m.namespaceWorkloads.WithLabelValues(ns.Name, string(w.MinimalLevel)).Inc()
+			for _, level := range []psapi.Level{psapi.LevelBaseline, psapi.LevelRestricted} {
+				if psapi.CompareLevels(w.MinimalLevel, level) < 0 {
+					m.namespaceFailingAtLevel.WithLabelValues(ns.Name, string(level)).Inc()
+				}
+			}
+			for _, check := range w.FailedChecks {
+				m.failedChecks.WithLabelValues(string(check.ID)).Inc()
+			}
+		}
+	}
+}
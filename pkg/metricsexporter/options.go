@@ -0,0 +1,133 @@
+package metricsexporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/cobra"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/klog/v2"
+
+	"github.com/stlaz/psachecker/pkg/clusterinspect"
+)
+
+type MetricsExporterOptions struct {
+	inspectOptions *clusterinspect.ClusterInspectOptions
+
+	listenAddress string
+	interval      time.Duration
+	textfilePath  string
+	once          bool
+}
+
+func newMetricsExporterOptions() *MetricsExporterOptions {
+	return &MetricsExporterOptions{
+		inspectOptions: clusterinspect.NewClusterInspectOptions(),
+	}
+}
+
+func (o *MetricsExporterOptions) AddFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+
+	flags.StringVar(&o.listenAddress, "listen-address", ":9737", "Address to serve the /metrics endpoint on. Set to an empty string to disable serving metrics over HTTP.")
+	flags.DurationVar(&o.interval, "interval", 10*time.Minute, "How often the cluster gets inspected.")
+	flags.StringVar(&o.textfilePath, "textfile", "", "Also write the metrics to this file after each inspection so that it can be picked up by the node_exporter textfile collector. The file name must end with \".prom\".")
+	flags.BoolVar(&o.once, "once", false, "Inspect the cluster only once, write the --textfile and exit.")
+
+	o.inspectOptions.AddScanFlags(cmd)
+}
+
+func (o *MetricsExporterOptions) Complete(cmd *cobra.Command, clientConfigOptions *genericclioptions.ConfigFlags) error {
+	if o.once {
+		o.listenAddress = ""
+	}
+	return o.inspectOptions.Complete(cmd, clientConfigOptions)
+}
+
+func (o *MetricsExporterOptions) Validate() []error {
+	errs := []error{}
+
+	if o.interval <= 0 {
+		errs = append(errs, fmt.Errorf("--interval must be a positive duration"))
+	}
+
+	if o.once && len(o.textfilePath) == 0 {
+		errs = append(errs, fmt.Errorf("--once requires --textfile to be set"))
+	}
+
+	if len(o.listenAddress) == 0 && len(o.textfilePath) == 0 {
+		errs = append(errs, fmt.Errorf("at least one of --listen-address or --textfile must be set"))
+	}
+
+	errs = append(errs, o.inspectOptions.Validate()...)
+
+	return errs
+}
+
+func (o *MetricsExporterOptions) Run(ctx context.Context) error {
+	metrics := newInspectionMetrics(o.inspectOptions.EvaluationCounts)
+
+	if o.once {
+		return o.inspect(ctx, metrics)
+	}
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	serverErrs := make(chan error, 1)
+	if len(o.listenAddress) > 0 {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.HandlerFor(metrics.registry, promhttp.HandlerOpts{}))
+		server := &http.Server{Addr: o.listenAddress, Handler: mux}
+
+		go func() {
+			if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				serverErrs <- err
+			}
+			close(serverErrs)
+		}()
+		defer server.Close()
+	}
+
+	go wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := o.inspect(ctx, metrics); err != nil {
+			klog.Errorf("cluster inspection failed: %v", err)
+		}
+	}, o.interval)
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-serverErrs:
+		return fmt.Errorf("failed to serve metrics: %w", err)
+	}
+}
+
+func (o *MetricsExporterOptions) inspect(ctx context.Context, metrics *inspectionMetrics) error {
+	results, err := o.inspectOptions.Inspect(ctx)
+	if err != nil {
+		metrics.lastInspectionSuccess.Set(0)
+	} else {
+		metrics.update(results)
+		metrics.lastInspectionSuccess.Set(1)
+		metrics.lastInspectionTimestamp.SetToCurrentTime()
+	}
+
+	if len(o.textfilePath) > 0 {
+		if writeErr := prometheus.WriteToTextfile(o.textfilePath, metrics.registry); writeErr != nil && err == nil {
+			err = fmt.Errorf("failed to write the metrics textfile: %w", writeErr)
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,32 @@
+package migratepsp
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+func NewMigratePSPCommand(clientConfigOptions *genericclioptions.ConfigFlags) *cobra.Command {
+	o := newMigratePSPOptions()
+
+	cmd := &cobra.Command{
+		Use:          "migrate-psp -f <path> [-f <path> ...] [flags]",
+		Short:        "map PodSecurityPolicies to PodSecurity levels and report what changes in each namespace after the migration",
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			o.Complete(clientConfigOptions)
+			errs := o.Validate()
+			if len(errs) > 0 {
+				return fmt.Errorf("there were errors while setting up the command: %v", errs)
+			}
+
+			return o.Run(context.Background(), c.OutOrStdout())
+		},
+	}
+
+	o.AddFlags(cmd)
+	return cmd
+}
@@ -0,0 +1,360 @@
+package migratepsp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	psapi "k8s.io/pod-security-admission/api"
+)
+
+const (
+	seccompAllowedProfilesAnnotation  = "seccomp.security.alpha.kubernetes.io/allowedProfileNames"
+	seccompDefaultProfileAnnotation   = "seccomp.security.alpha.kubernetes.io/defaultProfileName"
+	apparmorAllowedProfilesAnnotation = "apparmor.security.beta.kubernetes.io/allowedProfileNames"
+	apparmorDefaultProfileAnnotation  = "apparmor.security.beta.kubernetes.io/defaultProfileName"
+)
+
+var (
+	// baselineCapabilities are the capabilities the baseline level allows adding
+	baselineCapabilities = sets.NewString(
+		"AUDIT_WRITE", "CHOWN", "DAC_OVERRIDE", "FOWNER", "FSETID", "KILL", "MKNOD", "NET_BIND_SERVICE",
+		"SETFCAP", "SETGID", "SETPCAP", "SETUID", "SYS_CHROOT",
+	)
+
+	// restrictedVolumes are the volume types the restricted level allows
+	restrictedVolumes = sets.NewString(
+		string(policyv1beta1.ConfigMap), string(policyv1beta1.CSI), string(policyv1beta1.DownwardAPI),
+		string(policyv1beta1.EmptyDir), string(policyv1beta1.Ephemeral), string(policyv1beta1.PersistentVolumeClaim),
+		string(policyv1beta1.Projected), string(policyv1beta1.Secret),
+	)
+
+	// allVolumes are all the volume types a PodSecurityPolicy can allow
+	allVolumes = sets.NewString(
+		string(policyv1beta1.AzureFile), string(policyv1beta1.Flocker), string(policyv1beta1.FlexVolume),
+		string(policyv1beta1.HostPath), string(policyv1beta1.EmptyDir), string(policyv1beta1.GCEPersistentDisk),
+		string(policyv1beta1.AWSElasticBlockStore), string(policyv1beta1.GitRepo), string(policyv1beta1.Secret),
+		string(policyv1beta1.NFS), string(policyv1beta1.ISCSI), string(policyv1beta1.Glusterfs),
+		string(policyv1beta1.PersistentVolumeClaim), string(policyv1beta1.RBD), string(policyv1beta1.Cinder),
+		string(policyv1beta1.CephFS), string(policyv1beta1.DownwardAPI), string(policyv1beta1.FC),
+		string(policyv1beta1.ConfigMap), string(policyv1beta1.VsphereVolume), string(policyv1beta1.Quobyte),
+		string(policyv1beta1.AzureDisk), string(policyv1beta1.PhotonPersistentDisk), string(policyv1beta1.StorageOS),
+		string(policyv1beta1.Projected), string(policyv1beta1.PortworxVolume), string(policyv1beta1.ScaleIO),
+		string(policyv1beta1.CSI), string(policyv1beta1.Ephemeral),
+	)
+
+	baselineSELinuxTypes = sets.NewString("", "container_t", "container_init_t", "container_kvm_t")
+)
+
+// Permission is something a PodSecurityPolicy allows that the levels more restrictive
+// than Level forbid.
+type Permission struct {
+	Level  psapi.Level `json:"level"`
+	Field  string      `json:"field"`
+	Detail string      `json:"detail"`
+}
+
+// Setting is a restriction or a default of a PodSecurityPolicy that has no PodSecurity equivalent
+type Setting struct {
+	Field  string `json:"field"`
+	Detail string `json:"detail"`
+}
+
+// PolicyMapping maps a PodSecurityPolicy to the closest PodSecurity level, that is the most
+// restrictive level that allows everything the policy does.
+type PolicyMapping struct {
+	Name  string      `json:"name"`
+	Level psapi.Level `json:"level"`
+	// Permissions are what the policy allows beyond the restricted level
+	Permissions []Permission `json:"permissions,omitempty"`
+	// Restrictions are what the policy forbids regardless of the PodSecurity levels
+	Restrictions []Setting `json:"restrictions,omitempty"`
+	// Defaults are the values the policy sets on the admitted pods, PodSecurity never modifies pods
+	Defaults []Setting `json:"defaults,omitempty"`
+
+	// volumes are the volume types the policy allows
+	volumes sets.String
+}
+
+// MapPolicy maps the PodSecurityPolicy to the closest PodSecurity level following
+// https://kubernetes.io/docs/reference/access-authn-authz/psp-to-pod-security-standards/
+func MapPolicy(psp *policyv1beta1.PodSecurityPolicy) *PolicyMapping {
+	m := &PolicyMapping{Name: psp.Name, Level: psapi.LevelRestricted}
+	spec := &psp.Spec
+
+	if spec.Privileged {
+		m.permit(psapi.LevelPrivileged, "privileged", "privileged containers")
+	}
+	if spec.HostNetwork {
+		m.permit(psapi.LevelPrivileged, "hostNetwork", "the host network")
+	}
+	if spec.HostPID {
+		m.permit(psapi.LevelPrivileged, "hostPID", "the host PID namespace")
+	}
+	if spec.HostIPC {
+		m.permit(psapi.LevelPrivileged, "hostIPC", "the host IPC namespace")
+	}
+	if len(spec.HostPorts) > 0 {
+		ranges := make([]string, 0, len(spec.HostPorts))
+		for _, r := range spec.HostPorts {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", r.Min, r.Max))
+		}
+		m.permit(psapi.LevelPrivileged, "hostPorts", "host ports "+strings.Join(ranges, ", "))
+	}
+
+	capabilities := sets.NewString()
+	for _, c := range append(append([]corev1.Capability{}, spec.DefaultAddCapabilities...), spec.AllowedCapabilities...) {
+		capabilities.Insert(strings.TrimPrefix(strings.ToUpper(string(c)), "CAP_"))
+	}
+	for _, c := range capabilities.List() {
+		switch {
+		case c == "*":
+			m.permit(psapi.LevelPrivileged, "allowedCapabilities", "adding any capability")
+		case !baselineCapabilities.Has(c):
+			m.permit(psapi.LevelPrivileged, "allowedCapabilities", "adding the "+c+" capability")
+		case c != "NET_BIND_SERVICE":
+			m.permit(psapi.LevelBaseline, "allowedCapabilities", "adding the "+c+" capability")
+		}
+	}
+	if !dropsAll(spec.RequiredDropCapabilities) {
+		m.permit(psapi.LevelBaseline, "requiredDropCapabilities", "keeping the default capabilities")
+	}
+
+	m.volumes = sets.NewString()
+	for _, v := range spec.Volumes {
+		m.volumes.Insert(string(v))
+	}
+	if m.volumes.Has(string(policyv1beta1.All)) {
+		m.volumes = sets.NewString(allVolumes.List()...)
+		m.permit(psapi.LevelPrivileged, "volumes", "all volume types")
+	} else {
+		for _, v := range m.volumes.List() {
+			switch {
+			case v == string(policyv1beta1.HostPath):
+				m.permit(psapi.LevelPrivileged, "volumes", "hostPath volumes")
+			case !restrictedVolumes.Has(v):
+				m.permit(psapi.LevelBaseline, "volumes", v+" volumes")
+			}
+		}
+	}
+
+	if len(spec.AllowedUnsafeSysctls) > 0 {
+		m.permit(psapi.LevelPrivileged, "allowedUnsafeSysctls", "the unsafe sysctls "+strings.Join(spec.AllowedUnsafeSysctls, ", "))
+	}
+	for _, t := range spec.AllowedProcMountTypes {
+		if t == corev1.UnmaskedProcMount {
+			m.permit(psapi.LevelPrivileged, "allowedProcMountTypes", "the Unmasked proc mount")
+		}
+	}
+
+	switch selinux := spec.SELinux; {
+	case selinux.Rule == policyv1beta1.SELinuxStrategyRunAsAny:
+		m.permit(psapi.LevelPrivileged, "seLinux", "any SELinux options")
+	case selinux.SELinuxOptions != nil:
+		opts := selinux.SELinuxOptions
+		if len(opts.User) > 0 || len(opts.Role) > 0 || !baselineSELinuxTypes.Has(opts.Type) {
+			m.permit(psapi.LevelPrivileged, "seLinux", fmt.Sprintf("the SELinux options user=%q, role=%q, type=%q", opts.User, opts.Role, opts.Type))
+		}
+		m.addDefault("seLinux", fmt.Sprintf("sets the SELinux options user=%q, role=%q, type=%q, level=%q", opts.User, opts.Role, opts.Type, opts.Level))
+	}
+
+	switch runAsUser := spec.RunAsUser; runAsUser.Rule {
+	case policyv1beta1.RunAsUserStrategyMustRunAsNonRoot:
+		m.addDefault("runAsUser", "sets runAsNonRoot=true")
+	case policyv1beta1.RunAsUserStrategyMustRunAs:
+		nonRoot := len(runAsUser.Ranges) > 0
+		for _, r := range runAsUser.Ranges {
+			nonRoot = nonRoot && r.Min > 0
+		}
+		if !nonRoot {
+			m.permit(psapi.LevelBaseline, "runAsUser", "running as root")
+		}
+		m.restrict("runAsUser", "UIDs limited to "+idRanges(runAsUser.Ranges))
+		if len(runAsUser.Ranges) > 0 {
+			m.addDefault("runAsUser", fmt.Sprintf("sets runAsUser=%d", runAsUser.Ranges[0].Min))
+		}
+	default:
+		m.permit(psapi.LevelBaseline, "runAsUser", "running as root")
+	}
+
+	if spec.AllowPrivilegeEscalation == nil || *spec.AllowPrivilegeEscalation {
+		m.permit(psapi.LevelBaseline, "allowPrivilegeEscalation", "privilege escalation")
+	}
+	if spec.DefaultAllowPrivilegeEscalation != nil {
+		m.addDefault("defaultAllowPrivilegeEscalation", fmt.Sprintf("sets allowPrivilegeEscalation=%t", *spec.DefaultAllowPrivilegeEscalation))
+	}
+
+	m.mapSeccomp(psp.Annotations)
+	m.mapAppArmor(psp.Annotations)
+
+	if spec.RunAsGroup != nil && spec.RunAsGroup.Rule != policyv1beta1.RunAsGroupStrategyRunAsAny {
+		m.restrictIDs("runAsGroup", string(spec.RunAsGroup.Rule), spec.RunAsGroup.Ranges)
+	}
+	if spec.SupplementalGroups.Rule != "" && spec.SupplementalGroups.Rule != policyv1beta1.SupplementalGroupsStrategyRunAsAny {
+		m.restrictIDs("supplementalGroups", string(spec.SupplementalGroups.Rule), spec.SupplementalGroups.Ranges)
+	}
+	if spec.FSGroup.Rule != "" && spec.FSGroup.Rule != policyv1beta1.FSGroupStrategyRunAsAny {
+		m.restrictIDs("fsGroup", string(spec.FSGroup.Rule), spec.FSGroup.Ranges)
+	}
+
+	if spec.ReadOnlyRootFilesystem {
+		m.restrict("readOnlyRootFilesystem", "read-only root filesystem")
+		m.addDefault("readOnlyRootFilesystem", "sets readOnlyRootFilesystem=true")
+	}
+	if len(spec.RequiredDropCapabilities) > 0 {
+		m.addDefault("requiredDropCapabilities", "drops the capabilities "+capabilityList(spec.RequiredDropCapabilities))
+	}
+	if len(spec.DefaultAddCapabilities) > 0 {
+		m.addDefault("defaultAddCapabilities", "adds the capabilities "+capabilityList(spec.DefaultAddCapabilities))
+	}
+	if len(spec.AllowedHostPaths) > 0 {
+		paths := make([]string, 0, len(spec.AllowedHostPaths))
+		for _, p := range spec.AllowedHostPaths {
+			paths = append(paths, p.PathPrefix)
+		}
+		m.restrict("allowedHostPaths", "host paths limited to "+strings.Join(paths, ", "))
+	}
+	if len(spec.AllowedFlexVolumes) > 0 {
+		m.restrict("allowedFlexVolumes", fmt.Sprintf("%d allowed flexVolume drivers", len(spec.AllowedFlexVolumes)))
+	}
+	if len(spec.AllowedCSIDrivers) > 0 {
+		m.restrict("allowedCSIDrivers", fmt.Sprintf("%d allowed inline CSI drivers", len(spec.AllowedCSIDrivers)))
+	}
+	if len(spec.ForbiddenSysctls) > 0 {
+		m.restrict("forbiddenSysctls", "the sysctls "+strings.Join(spec.ForbiddenSysctls, ", ")+" are forbidden")
+	}
+	if rc := spec.RuntimeClass; rc != nil {
+		if !sets.NewString(rc.AllowedRuntimeClassNames...).Has("*") {
+			m.restrict("runtimeClass", "runtime classes limited to "+strings.Join(rc.AllowedRuntimeClassNames, ", "))
+		}
+		if rc.DefaultRuntimeClassName != nil {
+			m.addDefault("runtimeClass", "sets the "+*rc.DefaultRuntimeClassName+" runtime class")
+		}
+	}
+
+	return m
+}
+
+func (m *PolicyMapping) mapSeccomp(annotations map[string]string) {
+	allowed := splitProfiles(annotations[seccompAllowedProfilesAnnotation])
+	if def, ok := annotations[seccompDefaultProfileAnnotation]; ok {
+		allowed.Insert(def)
+		m.addDefault("seccomp", "sets the "+def+" seccomp profile")
+	}
+
+	if allowed.Len() == 0 {
+		m.permit(psapi.LevelBaseline, "seccomp", "pods without a seccomp profile")
+		return
+	}
+	for _, p := range allowed.List() {
+		switch {
+		case p == "*" || p == "unconfined":
+			m.permit(psapi.LevelPrivileged, "seccomp", "the unconfined seccomp profile")
+		case p != "runtime/default" && p != "docker/default" && !strings.HasPrefix(p, "localhost/"):
+			m.permit(psapi.LevelBaseline, "seccomp", "the "+p+" seccomp profile")
+		}
+	}
+}
+
+func (m *PolicyMapping) mapAppArmor(annotations map[string]string) {
+	// unlike with seccomp, the policy does not restrict the AppArmor profiles without the annotation
+	if _, ok := annotations[apparmorAllowedProfilesAnnotation]; ok {
+		for _, p := range splitProfiles(annotations[apparmorAllowedProfilesAnnotation]).List() {
+			if p != "runtime/default" && !strings.HasPrefix(p, "localhost/") {
+				m.permit(psapi.LevelPrivileged, "apparmor", "the "+p+" AppArmor profile")
+			}
+		}
+	} else {
+		m.permit(psapi.LevelPrivileged, "apparmor", "any AppArmor profile")
+	}
+	if def, ok := annotations[apparmorDefaultProfileAnnotation]; ok {
+		m.addDefault("apparmor", "sets the "+def+" AppArmor profile")
+	}
+}
+
+func (m *PolicyMapping) permit(level psapi.Level, field, detail string) {
+	m.Permissions = append(m.Permissions, Permission{Level: level, Field: field, Detail: detail})
+	if psapi.CompareLevels(level, m.Level) < 0 {
+		m.Level = level
+	}
+}
+
+func (m *PolicyMapping) restrict(field, detail string) {
+	m.Restrictions = append(m.Restrictions, Setting{Field: field, Detail: detail})
+}
+
+func (m *PolicyMapping) restrictIDs(field, rule string, ranges []policyv1beta1.IDRange) {
+	m.restrict(field, field+" limited to "+idRanges(ranges))
+	if rule == "MustRunAs" && len(ranges) > 0 {
+		m.addDefault(field, fmt.Sprintf("sets %s=%d", field, ranges[0].Min))
+	}
+}
+
+func (m *PolicyMapping) addDefault(field, detail string) {
+	m.Defaults = append(m.Defaults, Setting{Field: field, Detail: detail})
+}
+
+// PermissionsBeyond returns the permissions of the policy that the level does not grant
+func (m *PolicyMapping) PermissionsBeyond(level psapi.Level) []Permission {
+	var ret []Permission
+	for _, p := range m.Permissions {
+		if psapi.CompareLevels(p.Level, level) < 0 {
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
+
+// ForbiddenVolumes returns the volume types the level allows but the policy does not
+func (m *PolicyMapping) ForbiddenVolumes(level psapi.Level) sets.String {
+	switch level {
+	case psapi.LevelRestricted:
+		return restrictedVolumes.Difference(m.volumes)
+	case psapi.LevelBaseline:
+		return allVolumes.Difference(m.volumes).Delete(string(policyv1beta1.HostPath))
+	default:
+		return allVolumes.Difference(m.volumes)
+	}
+}
+
+func dropsAll(capabilities []corev1.Capability) bool {
+	for _, c := range capabilities {
+		if strings.EqualFold(string(c), "ALL") {
+			return true
+		}
+	}
+	return false
+}
+
+func capabilityList(capabilities []corev1.Capability) string {
+	ret := make([]string, 0, len(capabilities))
+	for _, c := range capabilities {
+		ret = append(ret, string(c))
+	}
+	sort.Strings(ret)
+	return strings.Join(ret, ", ")
+}
+
+func idRanges(ranges []policyv1beta1.IDRange) string {
+	if len(ranges) == 0 {
+		return "none"
+	}
+	ret := make([]string, 0, len(ranges))
+	for _, r := range ranges {
+		ret = append(ret, fmt.Sprintf("%d-%d", r.Min, r.Max))
+	}
+	return strings.Join(ret, ", ")
+}
+
+func splitProfiles(annotation string) sets.String {
+	profiles := sets.NewString()
+	for _, p := range strings.Split(annotation, ",") {
+		if p = strings.TrimSpace(p); len(p) > 0 {
+			profiles.Insert(p)
+		}
+	}
+	return profiles
+}
@@ -0,0 +1,65 @@
+package migratepsp
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	psapi "k8s.io/pod-security-admission/api"
+)
+
+// restrictedPSP allows nothing beyond the restricted level except for the AppArmor profiles
+// given by the annotations
+func restrictedPSP(annotations map[string]string) *policyv1beta1.PodSecurityPolicy {
+	allowPrivilegeEscalation := false
+	psp := &policyv1beta1.PodSecurityPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "restricted",
+			Annotations: map[string]string{seccompAllowedProfilesAnnotation: "runtime/default"},
+		},
+		Spec: policyv1beta1.PodSecurityPolicySpec{
+			RequiredDropCapabilities: []corev1.Capability{"ALL"},
+			AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+			Volumes:                  []policyv1beta1.FSType{policyv1beta1.ConfigMap, policyv1beta1.Secret, policyv1beta1.EmptyDir},
+			SELinux:                  policyv1beta1.SELinuxStrategyOptions{Rule: policyv1beta1.SELinuxStrategyMustRunAs},
+			RunAsUser:                policyv1beta1.RunAsUserStrategyOptions{Rule: policyv1beta1.RunAsUserStrategyMustRunAsNonRoot},
+		},
+	}
+	for k, v := range annotations {
+		psp.Annotations[k] = v
+	}
+	return psp
+}
+
+func TestMapPolicyAppArmor(t *testing.T) {
+	tests := map[string]struct {
+		annotations map[string]string
+		expected    psapi.Level
+	}{
+		"no AppArmor annotation": {
+			expected: psapi.LevelPrivileged,
+		},
+		"runtime/default only": {
+			annotations: map[string]string{apparmorAllowedProfilesAnnotation: "runtime/default"},
+			expected:    psapi.LevelRestricted,
+		},
+		"runtime/default and localhost profiles": {
+			annotations: map[string]string{apparmorAllowedProfilesAnnotation: "runtime/default,localhost/custom"},
+			expected:    psapi.LevelRestricted,
+		},
+		"unconfined": {
+			annotations: map[string]string{apparmorAllowedProfilesAnnotation: "runtime/default,unconfined"},
+			expected:    psapi.LevelPrivileged,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			m := MapPolicy(restrictedPSP(tt.annotations))
+			if m.Level != tt.expected {
+				t.Errorf("expected level %s, got %s with permissions %v", tt.expected, m.Level, m.Permissions)
+			}
+		})
+	}
+}
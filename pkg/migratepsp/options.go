@@ -0,0 +1,126 @@
+package migratepsp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes/fake"
+	psapi "k8s.io/pod-security-admission/api"
+
+	"github.com/stlaz/psachecker/pkg/admission"
+	"github.com/stlaz/psachecker/pkg/dump"
+)
+
+type MigratePSPOptions struct {
+	filenames []string
+	fromDump  string
+	namespace string
+	output    string
+}
+
+func newMigratePSPOptions() *MigratePSPOptions {
+	return &MigratePSPOptions{}
+}
+
+func (o *MigratePSPOptions) AddFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+
+	flags.StringSliceVarP(&o.filenames, "filename", "f", nil, "YAML or JSON files, directories of them or tarballs containing the PodSecurityPolicies, the RBAC roles and bindings that grant their use and the workloads. Can be repeated.")
+	flags.StringVar(&o.fromDump, "from-dump", "", "Read the objects from a dump, e.g. an archive created by the collect command.")
+	flags.StringVarP(&o.output, "output", "o", "", "Output format. One of: (json).")
+}
+
+func (o *MigratePSPOptions) Complete(clientConfigOptions *genericclioptions.ConfigFlags) {
+	if clientConfigOptions.Namespace != nil {
+		o.namespace = *clientConfigOptions.Namespace
+	}
+}
+
+func (o *MigratePSPOptions) Validate() []error {
+	errs := []error{}
+
+	if len(o.filenames) == 0 && len(o.fromDump) == 0 {
+		errs = append(errs, fmt.Errorf("either --filename or --from-dump must be specified"))
+	}
+
+	if o.output != "" && o.output != "json" {
+		errs = append(errs, fmt.Errorf("unsupported output format %q", o.output))
+	}
+
+	return errs
+}
+
+func (o *MigratePSPOptions) Run(ctx context.Context, out io.Writer) error {
+	var objs []runtime.Object
+	for _, path := range append(o.filenames, o.fromDump) {
+		if len(path) == 0 {
+			continue
+		}
+		pathObjs, err := dump.Load(path)
+		if err != nil {
+			return err
+		}
+		objs = append(objs, pathObjs...)
+	}
+
+	// the workloads are only evaluated locally
+	adm, err := admission.NewParallelAdmission(fake.NewSimpleClientset())
+	if err != nil {
+		return fmt.Errorf("failed to set up admission: %w", err)
+	}
+
+	report, err := NewReport(ctx, adm, objs, o.namespace)
+	if err != nil {
+		return err
+	}
+	if len(report.Policies) == 0 {
+		return fmt.Errorf("no PodSecurityPolicies found")
+	}
+
+	if o.output == "json" {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+
+	printReport(out, report)
+	return nil
+}
+
+func printReport(out io.Writer, report *Report) {
+	fmt.Fprintln(out, "PodSecurityPolicies:")
+	for _, p := range report.Policies {
+		fmt.Fprintf(out, "%s: %s\n", p.Name, p.Level)
+		for _, permission := range p.PermissionsBeyond(psapi.LevelRestricted) {
+			fmt.Fprintf(out, "  allows %s (%s)\n", permission.Detail, permission.Level)
+		}
+	}
+
+	fmt.Fprintln(out, "\nNamespaces:")
+	for _, ns := range report.Namespaces {
+		if len(ns.Policies) == 0 {
+			fmt.Fprintf(out, "%s: %s, no PodSecurityPolicy can be used in the namespace\n", ns.Name, ns.RecommendedLevel)
+			continue
+		}
+		fmt.Fprintf(out, "%s: %s, PodSecurityPolicies: %s (%s)\n", ns.Name, ns.RecommendedLevel, strings.Join(ns.Policies, ", "), ns.PolicyLevel)
+		if psapi.CompareLevels(ns.RecommendedLevel, ns.PolicyLevel) < 0 {
+			fmt.Fprintf(out, "  the workloads need a less restrictive level than the policies allow, they may depend on the defaults of the policies\n")
+		}
+		for _, f := range ns.Revoked {
+			fmt.Fprintf(out, "  no longer allowed: %s\n", f)
+		}
+		for _, f := range ns.Unenforced {
+			fmt.Fprintf(out, "  no longer enforced: %s\n", f)
+		}
+		for _, f := range ns.Defaults {
+			fmt.Fprintf(out, "  no longer defaulted: %s\n", f)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package migratepsp
+
+import (
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/authentication/serviceaccount"
+	"k8s.io/apiserver/pkg/authentication/user"
+)
+
+// policyUsers resolves the PodSecurityPolicies that the RBAC bindings authorize the pods to use.
+// A pod may use the policies its service account is allowed to use, a policy usable by any of
+// the service accounts of a namespace is considered usable by all the pods of the namespace.
+// The policies only available to the users creating the pods are not taken into account.
+type policyUsers struct {
+	policies     sets.String
+	roles        map[string]*rbacv1.Role
+	clusterRoles map[string]*rbacv1.ClusterRole
+
+	clusterBindings []*rbacv1.ClusterRoleBinding
+	bindings        map[string][]*rbacv1.RoleBinding
+}
+
+func newPolicyUsers(policies sets.String) *policyUsers {
+	return &policyUsers{
+		policies:     policies,
+		roles:        map[string]*rbacv1.Role{},
+		clusterRoles: map[string]*rbacv1.ClusterRole{},
+		bindings:     map[string][]*rbacv1.RoleBinding{},
+	}
+}
+
+func (u *policyUsers) addRole(role *rbacv1.Role) {
+	u.roles[role.Namespace+"/"+role.Name] = role
+}
+
+func (u *policyUsers) addClusterRole(role *rbacv1.ClusterRole) {
+	u.clusterRoles[role.Name] = role
+}
+
+func (u *policyUsers) addRoleBinding(binding *rbacv1.RoleBinding) {
+	u.bindings[binding.Namespace] = append(u.bindings[binding.Namespace], binding)
+}
+
+func (u *policyUsers) addClusterRoleBinding(binding *rbacv1.ClusterRoleBinding) {
+	u.clusterBindings = append(u.clusterBindings, binding)
+}
+
+// namespaces returns the namespaces with role bindings
+func (u *policyUsers) namespaces() sets.String {
+	ret := sets.NewString()
+	for ns := range u.bindings {
+		ret.Insert(ns)
+	}
+	return ret
+}
+
+// usablePolicies returns the names of the policies that the service accounts of the namespace
+// can use
+func (u *policyUsers) usablePolicies(namespace string) sets.String {
+	usable := sets.NewString()
+	for _, b := range u.clusterBindings {
+		if !bindsServiceAccounts(b.Subjects, "", namespace) {
+			continue
+		}
+		if role, ok := u.clusterRoles[b.RoleRef.Name]; ok && b.RoleRef.Kind == "ClusterRole" {
+			usable.Insert(u.grantedPolicies(role.Rules).UnsortedList()...)
+		}
+	}
+
+	for _, b := range u.bindings[namespace] {
+		if !bindsServiceAccounts(b.Subjects, namespace, namespace) {
+			continue
+		}
+
+		var rules []rbacv1.PolicyRule
+		switch b.RoleRef.Kind {
+		case "ClusterRole":
+			if role, ok := u.clusterRoles[b.RoleRef.Name]; ok {
+				rules = role.Rules
+			}
+		case "Role":
+			if role, ok := u.roles[namespace+"/"+b.RoleRef.Name]; ok {
+				rules = role.Rules
+			}
+		}
+		usable.Insert(u.grantedPolicies(rules).UnsortedList()...)
+	}
+	return usable
+}
+
+// bindsServiceAccounts tells whether any of the subjects of a binding stands for the service
+// accounts of the namespace, bindingNamespace is empty for cluster role bindings
+func bindsServiceAccounts(subjects []rbacv1.Subject, bindingNamespace, namespace string) bool {
+	for _, s := range subjects {
+		switch s.Kind {
+		case rbacv1.ServiceAccountKind:
+			// the namespace of the service accounts in role bindings defaults to theirs
+			saNamespace := s.Namespace
+			if len(saNamespace) == 0 {
+				saNamespace = bindingNamespace
+			}
+			if saNamespace == namespace {
+				return true
+			}
+		case rbacv1.GroupKind:
+			switch s.Name {
+			case serviceaccount.AllServiceAccountsGroup, serviceaccount.MakeNamespaceGroupName(namespace), user.AllAuthenticated:
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// grantedPolicies returns the policies the rules grant the "use" verb on. Only the rules that
+// name the podsecuritypolicies resource count, the rules granting everything on all resources,
+// e.g. those of cluster-admin, are meant for the administrators rather than for pods.
+func (u *policyUsers) grantedPolicies(rules []rbacv1.PolicyRule) sets.String {
+	granted := sets.NewString()
+	for _, r := range rules {
+		if !hasAny(r.Verbs, "use", rbacv1.VerbAll) ||
+			!hasAny(r.APIGroups, "policy", "extensions", rbacv1.APIGroupAll) ||
+			!hasAny(r.Resources, "podsecuritypolicies") {
+			continue
+		}
+		if len(r.ResourceNames) == 0 {
+			return u.policies
+		}
+		granted.Insert(u.policies.Intersection(sets.NewString(r.ResourceNames...)).UnsortedList()...)
+	}
+	return granted
+}
+
+func hasAny(values []string, wanted ...string) bool {
+	return sets.NewString(values...).HasAny(wanted...)
+}
@@ -0,0 +1,145 @@
+package migratepsp
+
+import (
+	"reflect"
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func pspUseRule(policies ...string) rbacv1.PolicyRule {
+	return rbacv1.PolicyRule{
+		APIGroups:     []string{"policy"},
+		Resources:     []string{"podsecuritypolicies"},
+		Verbs:         []string{"use"},
+		ResourceNames: policies,
+	}
+}
+
+func testPolicyUsers() *policyUsers {
+	u := newPolicyUsers(sets.NewString("privileged", "baseline", "restricted", "hostnetwork", "admin-only"))
+
+	u.addClusterRole(&rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster-admin"},
+		Rules: []rbacv1.PolicyRule{
+			{APIGroups: []string{"*"}, Resources: []string{"*"}, Verbs: []string{"*"}},
+			{NonResourceURLs: []string{"*"}, Verbs: []string{"*"}},
+		},
+	})
+	u.addClusterRole(&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "psp:privileged"}, Rules: []rbacv1.PolicyRule{pspUseRule("privileged")}})
+	u.addClusterRole(&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "psp:restricted"}, Rules: []rbacv1.PolicyRule{pspUseRule("restricted")}})
+	u.addClusterRole(&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "psp:admin-only"}, Rules: []rbacv1.PolicyRule{pspUseRule("admin-only")}})
+	u.addRole(&rbacv1.Role{ObjectMeta: metav1.ObjectMeta{Namespace: "app", Name: "psp:baseline"}, Rules: []rbacv1.PolicyRule{pspUseRule("baseline")}})
+	u.addRole(&rbacv1.Role{ObjectMeta: metav1.ObjectMeta{Namespace: "app", Name: "psp:hostnetwork"}, Rules: []rbacv1.PolicyRule{pspUseRule("hostnetwork")}})
+
+	u.addClusterRoleBinding(&rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster-admin"},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "cluster-admin"},
+		Subjects: []rbacv1.Subject{
+			{Kind: rbacv1.GroupKind, APIGroup: rbacv1.GroupName, Name: "system:masters"},
+			{Kind: rbacv1.ServiceAccountKind, Namespace: "app", Name: "deployer"},
+		},
+	})
+	u.addClusterRoleBinding(&rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "psp:restricted"},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "psp:restricted"},
+		Subjects:   []rbacv1.Subject{{Kind: rbacv1.GroupKind, APIGroup: rbacv1.GroupName, Name: "system:authenticated"}},
+	})
+	u.addClusterRoleBinding(&rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "psp:admin-only"},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "psp:admin-only"},
+		Subjects:   []rbacv1.Subject{{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "admin"}},
+	})
+	u.addClusterRoleBinding(&rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "psp:privileged:kube-system"},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "psp:privileged"},
+		Subjects:   []rbacv1.Subject{{Kind: rbacv1.GroupKind, APIGroup: rbacv1.GroupName, Name: "system:serviceaccounts:kube-system"}},
+	})
+
+	u.addRoleBinding(&rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "app", Name: "psp:baseline"},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: "psp:baseline"},
+		// the namespace of the service account defaults to that of the binding
+		Subjects: []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: "default"}},
+	})
+	u.addRoleBinding(&rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "app", Name: "psp:hostnetwork"},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: "psp:hostnetwork"},
+		Subjects: []rbacv1.Subject{
+			{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "developer"},
+			{Kind: rbacv1.ServiceAccountKind, Namespace: "other", Name: "default"},
+		},
+	})
+	u.addRoleBinding(&rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "monitoring", Name: "psp:privileged"},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "psp:privileged"},
+		Subjects:   []rbacv1.Subject{{Kind: rbacv1.GroupKind, APIGroup: rbacv1.GroupName, Name: "system:serviceaccounts"}},
+	})
+	u.addRoleBinding(&rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "monitoring", Name: "admin"},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "cluster-admin"},
+		Subjects:   []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: "operator"}},
+	})
+
+	return u
+}
+
+func TestUsablePolicies(t *testing.T) {
+	u := testPolicyUsers()
+
+	tests := map[string][]string{
+		// cluster-admin of the deployer service account, hostnetwork of a user and of a service
+		// account of another namespace and admin-only of a user don't count
+		"app": {"baseline", "restricted"},
+		// cluster-admin of the operator service account doesn't count
+		"monitoring":  {"privileged", "restricted"},
+		"kube-system": {"privileged", "restricted"},
+		"other":       {"restricted"},
+	}
+	for ns, expected := range tests {
+		t.Run(ns, func(t *testing.T) {
+			if got := u.usablePolicies(ns).List(); !reflect.DeepEqual(expected, got) {
+				t.Errorf("expected the policies %v, got %v", expected, got)
+			}
+		})
+	}
+}
+
+func TestGrantedPolicies(t *testing.T) {
+	u := newPolicyUsers(sets.NewString("privileged", "restricted"))
+
+	tests := []struct {
+		name     string
+		rule     rbacv1.PolicyRule
+		expected []string
+	}{
+		{name: "named policy", rule: pspUseRule("restricted", "missing"), expected: []string{"restricted"}},
+		{name: "all policies", rule: pspUseRule(), expected: []string{"privileged", "restricted"}},
+		{
+			name:     "all verbs on the policies",
+			rule:     rbacv1.PolicyRule{APIGroups: []string{"extensions"}, Resources: []string{"podsecuritypolicies"}, Verbs: []string{"*"}},
+			expected: []string{"privileged", "restricted"},
+		},
+		{
+			name: "all resources",
+			rule: rbacv1.PolicyRule{APIGroups: []string{"*"}, Resources: []string{"*"}, Verbs: []string{"*"}},
+		},
+		{
+			name: "other verbs",
+			rule: rbacv1.PolicyRule{APIGroups: []string{"policy"}, Resources: []string{"podsecuritypolicies"}, Verbs: []string{"get", "list"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := u.grantedPolicies([]rbacv1.PolicyRule{tt.rule}).List()
+			if len(got) == 0 && len(tt.expected) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(tt.expected, got) {
+				t.Errorf("expected the policies %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package migratepsp
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/cli-runtime/pkg/resource"
+	psapi "k8s.io/pod-security-admission/api"
+
+	"github.com/stlaz/psachecker/pkg/admission"
+)
+
+// Finding is a permission, a restriction or a default of one or more PodSecurityPolicies
+type Finding struct {
+	Policies []string `json:"policies"`
+	Field    string   `json:"field"`
+	Detail   string   `json:"detail"`
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s [%s]", f.Detail, strings.Join(f.Policies, ", "))
+}
+
+// NamespaceReport describes what changes in a namespace once its PodSecurityPolicies get
+// replaced by the recommended PodSecurity level.
+type NamespaceReport struct {
+	Name string `json:"name"`
+	// Policies are the PodSecurityPolicies the pods of the namespace are authorized to use
+	Policies []string `json:"policies,omitempty"`
+	// PolicyLevel is the closest level to the least restrictive of the policies
+	PolicyLevel psapi.Level `json:"policyLevel,omitempty"`
+	// RecommendedLevel is the most restrictive level the workloads of the namespace are able to run with
+	RecommendedLevel psapi.Level `json:"recommendedLevel"`
+	// Workloads is the number of the evaluated pods and pod controllers
+	Workloads int `json:"workloads"`
+
+	// Revoked are the permissions of the policies the recommended level does not grant
+	Revoked []Finding `json:"revoked,omitempty"`
+	// Unenforced are the restrictions shared by all the policies that the recommended level does not enforce
+	Unenforced []Finding `json:"unenforced,omitempty"`
+	// Defaults are the values the policies set on the pods, PodSecurity does not set them
+	Defaults []Finding `json:"defaults,omitempty"`
+}
+
+// Report is the result of mapping the PodSecurityPolicies to the PodSecurity levels
+type Report struct {
+	Policies   []*PolicyMapping   `json:"policies"`
+	Namespaces []*NamespaceReport `json:"namespaces"`
+}
+
+// NewReport maps the PodSecurityPolicies among the objects to the PodSecurity levels and compares
+// them with the levels recommended for the workloads of each namespace. Namespaces are taken from
+// the Namespace objects, the workloads and the role bindings, only the given namespace is reported
+// if it's set.
+func NewReport(ctx context.Context, adm *admission.ParallelAdmission, objs []runtime.Object, namespace string) (*Report, error) {
+	report := &Report{}
+	mappings := map[string]*PolicyMapping{}
+	namespaces := sets.NewString()
+	var workloads []*resource.Info
+
+	for _, obj := range objs {
+		if psp, ok := obj.(*policyv1beta1.PodSecurityPolicy); ok {
+			m := MapPolicy(psp)
+			mappings[m.Name] = m
+			report.Policies = append(report.Policies, m)
+		}
+	}
+	sort.Slice(report.Policies, func(i, j int) bool {
+		return report.Policies[i].Name < report.Policies[j].Name
+	})
+
+	users := newPolicyUsers(sets.StringKeySet(mappings))
+	for _, obj := range objs {
+		switch o := obj.(type) {
+		case *corev1.Namespace:
+			namespaces.Insert(o.Name)
+		case *rbacv1.Role:
+			users.addRole(o)
+		case *rbacv1.ClusterRole:
+			users.addClusterRole(o)
+		case *rbacv1.RoleBinding:
+			users.addRoleBinding(o)
+		case *rbacv1.ClusterRoleBinding:
+			users.addClusterRoleBinding(o)
+		case *corev1.Pod, *corev1.ReplicationController, *appsv1.Deployment, *appsv1.ReplicaSet,
+			*appsv1.StatefulSet, *appsv1.DaemonSet, *batchv1.Job, *batchv1.CronJob:
+			workloads = append(workloads, &resource.Info{Object: obj})
+		}
+	}
+	namespaces.Insert(users.namespaces().UnsortedList()...)
+
+	results, err := adm.ValidateResources(ctx, true, nil, workloads...)
+	if err != nil {
+		return nil, err
+	}
+	levels := admission.MostRestrictivePolicyPerNamespace(results)
+	workloadCounts := map[string]int{}
+	for key := range results {
+		namespaces.Insert(key.Namespace)
+		workloadCounts[key.Namespace]++
+	}
+
+	for _, ns := range namespaces.List() {
+		if len(namespace) > 0 && ns != namespace {
+			continue
+		}
+
+		nsReport := &NamespaceReport{
+			Name:             ns,
+			RecommendedLevel: psapi.LevelRestricted,
+			Workloads:        workloadCounts[ns],
+		}
+		if level, ok := levels[ns]; ok {
+			nsReport.RecommendedLevel = level
+		}
+
+		var policies []*PolicyMapping
+		for _, name := range users.usablePolicies(ns).List() {
+			policies = append(policies, mappings[name])
+		}
+		nsReport.compare(policies)
+		report.Namespaces = append(report.Namespaces, nsReport)
+	}
+
+	return report, nil
+}
+
+// compare fills in the differences between the policies and the recommended level
+func (r *NamespaceReport) compare(policies []*PolicyMapping) {
+	if len(policies) == 0 {
+		return
+	}
+
+	r.PolicyLevel = psapi.LevelRestricted
+	revoked, defaults := newFindings(), newFindings()
+	// restrictions are only effective if all the policies apply them, pods may use any of them
+	var unenforced *findings
+	forbiddenVolumes := sets.NewString(allVolumes.List()...)
+	for _, p := range policies {
+		r.Policies = append(r.Policies, p.Name)
+		if psapi.CompareLevels(p.Level, r.PolicyLevel) < 0 {
+			r.PolicyLevel = p.Level
+		}
+
+		for _, permission := range p.PermissionsBeyond(r.RecommendedLevel) {
+			revoked.add(p.Name, permission.Field, permission.Detail)
+		}
+		for _, d := range p.Defaults {
+			defaults.add(p.Name, d.Field, d.Detail)
+		}
+
+		restrictions := newFindings()
+		for _, restriction := range p.Restrictions {
+			restrictions.add(p.Name, restriction.Field, restriction.Detail)
+		}
+		unenforced = unenforced.intersect(restrictions)
+		forbiddenVolumes = forbiddenVolumes.Intersection(p.ForbiddenVolumes(r.RecommendedLevel))
+	}
+
+	r.Revoked = revoked.list()
+	r.Defaults = defaults.list()
+	r.Unenforced = unenforced.list()
+	if forbiddenVolumes.Len() > 0 {
+		r.Unenforced = append(r.Unenforced, Finding{
+			Policies: r.Policies,
+			Field:    "volumes",
+			Detail:   strings.Join(forbiddenVolumes.List(), ", ") + " volumes are forbidden",
+		})
+	}
+}
+
+// findings merges the same findings of different policies
+type findings struct {
+	byKey map[string]*Finding
+}
+
+func newFindings() *findings {
+	return &findings{byKey: map[string]*Finding{}}
+}
+
+func (f *findings) add(policy, field, detail string) {
+	key := field + ": " + detail
+	if existing, ok := f.byKey[key]; ok {
+		existing.Policies = append(existing.Policies, policy)
+		return
+	}
+	f.byKey[key] = &Finding{Policies: []string{policy}, Field: field, Detail: detail}
+}
+
+// intersect returns the findings present in both f and other, a nil f stands for all the findings
+func (f *findings) intersect(other *findings) *findings {
+	if f == nil {
+		return other
+	}
+	ret := newFindings()
+	for key, finding := range f.byKey {
+		if o, ok := other.byKey[key]; ok {
+			ret.byKey[key] = &Finding{
+				Policies: append(append([]string{}, finding.Policies...), o.Policies...),
+				Field:    finding.Field,
+				Detail:   finding.Detail,
+			}
+		}
+	}
+	return ret
+}
+
+func (f *findings) list() []Finding {
+	if f == nil {
+		return nil
+	}
+	ret := make([]Finding, 0, len(f.byKey))
+	for _, finding := range f.byKey {
+		ret = append(ret, *finding)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Field != ret[j].Field {
+			return ret[i].Field < ret[j].Field
+		}
+		return ret[i].Detail < ret[j].Detail
+	})
+	return ret
+}
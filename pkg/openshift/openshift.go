@@ -0,0 +1,125 @@
+package openshift
+
+import (
+	"encoding/json"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	psapi "k8s.io/pod-security-admission/api"
+)
+
+const (
+	// PodSecurityLabelSyncLabel controls whether the OpenShift label syncer manages the
+	// PodSecurity labels of the namespace
+	PodSecurityLabelSyncLabel = "security.openshift.io/scc.podSecurityLabelSync"
+
+	// SCCAnnotation is the name of the SecurityContextConstraints that admitted the pod
+	SCCAnnotation = "openshift.io/scc"
+
+	// UIDRangeAnnotation is one of the ranges OpenShift allocates to each of its namespaces,
+	// the SCCs with the MustRunAs* strategies set the security context of the pods from them
+	UIDRangeAnnotation = "openshift.io/sa.scc.uid-range"
+)
+
+// NamespaceConfig is the OpenShift SecurityContextConstraints configuration of a namespace
+type NamespaceConfig struct {
+	// PodSecurityLabelSync is the value of the label sync label, nil if the label is not set
+	PodSecurityLabelSync *bool `json:"podSecurityLabelSync,omitempty"`
+}
+
+// NamespaceConfigFor returns the OpenShift configuration of the namespace or nil if the namespace
+// is not an OpenShift one. Besides the label sync label, the OpenShift namespaces are recognized
+// by the SCC ranges, those are allocated to every namespace of the cluster. The ranges themselves
+// are not needed as the pods are evaluated after the SCCs set their security context.
+func NamespaceConfigFor(ns *corev1.Namespace) *NamespaceConfig {
+	v, hasSyncLabel := ns.Labels[PodSecurityLabelSyncLabel]
+	if _, ok := ns.Annotations[UIDRangeAnnotation]; !ok && !hasSyncLabel {
+		return nil
+	}
+
+	config := &NamespaceConfig{}
+	// the syncer only recognizes "true" and "false"
+	if v == "true" || v == "false" {
+		sync := v == "true"
+		config.PodSecurityLabelSync = &sync
+	}
+	return config
+}
+
+// unsyncedNamespaces are the namespaces the label syncer never manages in addition to
+// those prefixed with "openshift"
+var unsyncedNamespaces = sets.NewString("default", "kube-system", "kube-public", "kube-node-lease")
+
+// labelSyncerFieldManager is the field manager the label syncer applies the PodSecurity labels with
+const labelSyncerFieldManager = "pod-security-admission-label-synchronization-controller"
+
+var podSecurityLabels = []string{
+	psapi.EnforceLevelLabel, psapi.EnforceVersionLabel,
+	psapi.AuditLevelLabel, psapi.AuditVersionLabel,
+	psapi.WarnLevelLabel, psapi.WarnVersionLabel,
+}
+
+// LabelsSynced tells whether the OpenShift label syncer manages the PodSecurity labels of
+// the namespace. The sync must not be disabled by the label and the namespace must not belong
+// to the platform unless the label enables the sync. The syncer does not override the labels
+// that were set by anyone else, those are recognized by the managed fields of the namespace.
+// If the namespace has some of the labels but not its managed fields, e.g. in dumps, the owners
+// of the labels are not known and the labels are not considered synced.
+func LabelsSynced(ns *corev1.Namespace) bool {
+	config := NamespaceConfigFor(ns)
+	if config == nil {
+		return false
+	}
+	if config.PodSecurityLabelSync != nil && !*config.PodSecurityLabelSync {
+		return false
+	}
+	if config.PodSecurityLabelSync == nil && (strings.HasPrefix(ns.Name, "openshift") || unsyncedNamespaces.Has(ns.Name)) {
+		return false
+	}
+
+	labeled := false
+	for _, label := range podSecurityLabels {
+		if _, ok := ns.Labels[label]; ok {
+			labeled = true
+			break
+		}
+	}
+	if !labeled {
+		return true
+	}
+	if len(ns.ManagedFields) == 0 {
+		return false
+	}
+	return len(labelOwners(ns.ManagedFields).Delete(labelSyncerFieldManager)) == 0
+}
+
+// labelOwners returns the field managers that own any of the PodSecurity labels
+func labelOwners(managedFields []metav1.ManagedFieldsEntry) sets.String {
+	owners := sets.NewString()
+	for _, entry := range managedFields {
+		if entry.FieldsV1 == nil {
+			continue
+		}
+		fields := struct {
+			Metadata struct {
+				Labels map[string]interface{} `json:"f:labels"`
+			} `json:"f:metadata"`
+		}{}
+		if err := json.Unmarshal(entry.FieldsV1.Raw, &fields); err != nil {
+			continue
+		}
+		for _, label := range podSecurityLabels {
+			if _, ok := fields.Metadata.Labels["f:"+label]; ok {
+				owners.Insert(entry.Manager)
+			}
+		}
+	}
+	return owners
+}
+
+// PodSCC returns the SecurityContextConstraints that admitted the pod, empty outside of OpenShift
+func PodSCC(pod *corev1.Pod) string {
+	return pod.Annotations[SCCAnnotation]
+}
@@ -0,0 +1,104 @@
+package openshift
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func labelsManagedBy(manager string) metav1.ManagedFieldsEntry {
+	return metav1.ManagedFieldsEntry{
+		Manager:    manager,
+		Operation:  metav1.ManagedFieldsOperationApply,
+		FieldsType: "FieldsV1",
+		FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:labels":{` +
+			`"f:pod-security.kubernetes.io/audit":{},"f:pod-security.kubernetes.io/warn":{}}}}`)},
+	}
+}
+
+func TestLabelsSynced(t *testing.T) {
+	sccAnnotations := map[string]string{UIDRangeAnnotation: "1000660000/10000"}
+	psaLabels := map[string]string{"pod-security.kubernetes.io/audit": "restricted", "pod-security.kubernetes.io/warn": "restricted"}
+	withLabels := func(labels map[string]string, extra map[string]string) map[string]string {
+		merged := map[string]string{}
+		for _, m := range []map[string]string{labels, extra} {
+			for k, v := range m {
+				merged[k] = v
+			}
+		}
+		return merged
+	}
+
+	tests := []struct {
+		name          string
+		namespace     string
+		labels        map[string]string
+		annotations   map[string]string
+		managedFields []metav1.ManagedFieldsEntry
+		expected      bool
+	}{
+		{name: "not openshift", namespace: "app"},
+		{name: "unlabeled", namespace: "app", annotations: sccAnnotations, expected: true},
+		{name: "platform namespace", namespace: "openshift-monitoring", annotations: sccAnnotations},
+		{name: "kube-system", namespace: "kube-system", annotations: sccAnnotations},
+		{
+			name:        "platform namespace with sync enabled",
+			namespace:   "openshift-operators",
+			labels:      map[string]string{PodSecurityLabelSyncLabel: "true"},
+			annotations: sccAnnotations,
+			expected:    true,
+		},
+		{
+			name:        "sync disabled",
+			namespace:   "app",
+			labels:      map[string]string{PodSecurityLabelSyncLabel: "false"},
+			annotations: sccAnnotations,
+		},
+		{
+			name:          "labels owned by the syncer",
+			namespace:     "app",
+			labels:        psaLabels,
+			annotations:   sccAnnotations,
+			managedFields: []metav1.ManagedFieldsEntry{labelsManagedBy(labelSyncerFieldManager)},
+			expected:      true,
+		},
+		{
+			name:          "labels owned by a user",
+			namespace:     "app",
+			labels:        psaLabels,
+			annotations:   sccAnnotations,
+			managedFields: []metav1.ManagedFieldsEntry{labelsManagedBy("kubectl-label")},
+		},
+		{
+			name:        "labels owned by a user with sync enabled",
+			namespace:   "app",
+			labels:      withLabels(psaLabels, map[string]string{PodSecurityLabelSyncLabel: "true"}),
+			annotations: sccAnnotations,
+			managedFields: []metav1.ManagedFieldsEntry{
+				labelsManagedBy(labelSyncerFieldManager),
+				labelsManagedBy("kubectl-client-side-apply"),
+			},
+		},
+		{
+			name:        "unknown owners of the labels",
+			namespace:   "app",
+			labels:      psaLabels,
+			annotations: sccAnnotations,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Name:          tt.namespace,
+				Labels:        tt.labels,
+				Annotations:   tt.annotations,
+				ManagedFields: tt.managedFields,
+			}}
+			if got := LabelsSynced(ns); got != tt.expected {
+				t.Errorf("expected LabelsSynced to be %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
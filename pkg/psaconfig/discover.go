@@ -0,0 +1,216 @@
+package psaconfig
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	psapi "k8s.io/pod-security-admission/api"
+)
+
+// Defaults are the PodSecurity levels the cluster applies to namespaces without
+// PodSecurity labels. Empty fields mean that the value could not be discovered.
+type Defaults struct {
+	// Namespace is the unlabeled namespace the defaults were probed in
+	Namespace      string `json:"namespace,omitempty"`
+	Enforce        string `json:"enforce,omitempty"`
+	EnforceVersion string `json:"enforceVersion,omitempty"`
+	Warn           string `json:"warn,omitempty"`
+	WarnVersion    string `json:"warnVersion,omitempty"`
+	// Error explains why the defaults could not be discovered
+	Error string `json:"error,omitempty"`
+}
+
+// EnforceLevel returns the default enforce level, empty if it is not known
+func (d *Defaults) EnforceLevel() psapi.Level {
+	if d == nil {
+		return ""
+	}
+	level, err := psapi.ParseLevel(d.Enforce)
+	if err != nil {
+		return ""
+	}
+	return level
+}
+
+// Apply returns the labels of a namespace with the PodSecurity labels it lacks set to the known
+// defaults. Like in the admission, each of the labels falls back to its default separately.
+func (d *Defaults) Apply(labels map[string]string) map[string]string {
+	if d == nil {
+		return labels
+	}
+
+	applied := make(map[string]string, len(labels)+4)
+	for k, v := range labels {
+		applied[k] = v
+	}
+	for label, value := range map[string]string{
+		psapi.EnforceLevelLabel:   d.Enforce,
+		psapi.EnforceVersionLabel: d.EnforceVersion,
+		psapi.WarnLevelLabel:      d.Warn,
+		psapi.WarnVersionLabel:    d.WarnVersion,
+	} {
+		if _, ok := applied[label]; !ok && len(value) > 0 {
+			applied[label] = value
+		}
+	}
+	return applied
+}
+
+var podSecurityPolicyRegexp = regexp.MustCompile(`violate[s]? PodSecurity "([a-z]+):([a-z0-9.]+)"`)
+
+// Discover finds out the default PodSecurity levels of the cluster by creating probe pods
+// in a namespace without PodSecurity labels in the dry-run mode. The audit level cannot be
+// discovered this way. Exempted namespaces and users make the defaults look privileged.
+func Discover(ctx context.Context, clientConfig *rest.Config, namespaces []corev1.Namespace) *Defaults {
+	defaults := &Defaults{}
+	for _, ns := range namespaces {
+		if !hasPodSecurityLabels(ns.Labels) && ns.DeletionTimestamp == nil {
+			defaults.Namespace = ns.Name
+			break
+		}
+	}
+	if len(defaults.Namespace) == 0 {
+		defaults.Error = "there is no namespace without PodSecurity labels to probe the defaults in"
+		return defaults
+	}
+
+	warnings := &warningRecorder{}
+	clientConfig = rest.CopyConfig(clientConfig)
+	clientConfig.WarningHandler = warnings
+	client, err := kubernetes.NewForConfig(clientConfig)
+	if err != nil {
+		defaults.Error = fmt.Sprintf("failed to create kube client: %v", err)
+		return defaults
+	}
+
+	// the privileged pod is denied by any enforce level other than privileged and
+	// if it gets allowed, it triggers a warning for any such warn level
+	lv, warnLV, err := probe(ctx, client, warnings, defaults.Namespace, privilegedProbePod())
+	if err != nil {
+		defaults.Error = err.Error()
+		return defaults
+	}
+	if lv != nil {
+		defaults.Enforce, defaults.EnforceVersion = string(lv.Level), lv.Version.String()
+	} else {
+		// the version does not matter for the privileged level
+		defaults.Enforce, defaults.Warn = string(psapi.LevelPrivileged), string(psapi.LevelPrivileged)
+		if warnLV != nil {
+			defaults.Warn, defaults.WarnVersion = string(warnLV.Level), warnLV.Version.String()
+		}
+		return defaults
+	}
+
+	// a baseline enforce level lets the baseline pod in, which then tells whether the warn
+	// level is restricted. Any probe gets denied by the restricted level so the warn level
+	// remains unknown then.
+	if defaults.Enforce == string(psapi.LevelBaseline) {
+		_, warnLV, err := probe(ctx, client, warnings, defaults.Namespace, baselineProbePod())
+		if err != nil {
+			defaults.Error = err.Error()
+			return defaults
+		}
+		if warnLV != nil {
+			defaults.Warn, defaults.WarnVersion = string(warnLV.Level), warnLV.Version.String()
+		}
+	}
+
+	return defaults
+}
+
+// probe creates the pod in the dry-run mode and returns the enforce policy the pod
+// was denied by and the warn policy it violates, both are nil if the pod passes them.
+func probe(ctx context.Context, client kubernetes.Interface, warnings *warningRecorder, namespace string, pod *corev1.Pod) (enforce, warn *psapi.LevelVersion, err error) {
+	warnings.reset()
+	_, err = client.CoreV1().Pods(namespace).Create(ctx, pod, metav1.CreateOptions{DryRun: []string{metav1.DryRunAll}})
+	if err != nil {
+		if !apierrors.IsForbidden(err) {
+			return nil, nil, fmt.Errorf("failed to create the probe pod: %w", err)
+		}
+		lv := parsePolicy(err.Error())
+		if lv == nil {
+			return nil, nil, fmt.Errorf("the probe pod was rejected by something else than PodSecurity: %w", err)
+		}
+		return lv, nil, nil
+	}
+
+	for _, w := range warnings.get() {
+		if lv := parsePolicy(w); lv != nil {
+			return nil, lv, nil
+		}
+	}
+	return nil, nil, nil
+}
+
+func parsePolicy(message string) *psapi.LevelVersion {
+	match := podSecurityPolicyRegexp.FindStringSubmatch(message)
+	if match == nil {
+		return nil
+	}
+
+	level, err := psapi.ParseLevel(match[1])
+	if err != nil {
+		return nil
+	}
+	version, err := psapi.ParseVersion(match[2])
+	if err != nil {
+		return nil
+	}
+	return &psapi.LevelVersion{Level: level, Version: version}
+}
+
+func hasPodSecurityLabels(labels map[string]string) bool {
+	for _, k := range []string{psapi.EnforceLevelLabel, psapi.WarnLevelLabel, psapi.AuditLevelLabel} {
+		if _, ok := labels[k]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func baselineProbePod() *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{GenerateName: "psachecker-probe-"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "probe", Image: "registry.k8s.io/pause:3.9"}},
+		},
+	}
+}
+
+func privilegedProbePod() *corev1.Pod {
+	pod := baselineProbePod()
+	privileged := true
+	pod.Spec.Containers[0].SecurityContext = &corev1.SecurityContext{Privileged: &privileged}
+	return pod
+}
+
+// warningRecorder collects the warnings returned by the API server
+type warningRecorder struct {
+	lock     sync.Mutex
+	warnings []string
+}
+
+func (r *warningRecorder) HandleWarningHeader(_ int, _ string, text string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.warnings = append(r.warnings, text)
+}
+
+func (r *warningRecorder) reset() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.warnings = nil
+}
+
+func (r *warningRecorder) get() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]string(nil), r.warnings...)
+}
@@ -0,0 +1,34 @@
+package simulate
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+func NewSimulateCommand(clientConfigOptions *genericclioptions.ConfigFlags) *cobra.Command {
+	o := newSimulateOptions()
+
+	cmd := &cobra.Command{
+		Use:          "simulate (--level namespace=level ... | --levels-file <path>) [flags]",
+		Short:        "report the pods and controllers that the desired namespace enforce levels would reject",
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(clientConfigOptions); err != nil {
+				return err
+			}
+			errs := o.Validate()
+			if len(errs) > 0 {
+				return fmt.Errorf("there were errors while setting up the command: %v", errs)
+			}
+
+			return o.Run(context.Background(), c.OutOrStdout(), c.ErrOrStderr())
+		},
+	}
+
+	o.AddFlags(cmd)
+	return cmd
+}
@@ -0,0 +1,203 @@
+package simulate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/spf13/cobra"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+	psapi "k8s.io/pod-security-admission/api"
+
+	"github.com/stlaz/psachecker/pkg/admission"
+	"github.com/stlaz/psachecker/pkg/collect"
+	"github.com/stlaz/psachecker/pkg/dump"
+	"github.com/stlaz/psachecker/pkg/psaconfig"
+)
+
+type SimulateOptions struct {
+	levels       map[string]string
+	levelsFile   string
+	defaultLevel string
+	fromDump     string
+	output       string
+
+	desiredLevels *DesiredLevels
+	kubeClient    kubernetes.Interface
+	// defaults are the default PodSecurity levels of the cluster, nil if they are not known
+	defaults *psaconfig.Defaults
+}
+
+func newSimulateOptions() *SimulateOptions {
+	return &SimulateOptions{}
+}
+
+func (o *SimulateOptions) AddFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+
+	flags.StringToStringVar(&o.levels, "level", nil, "The enforce level to simulate in a namespace as namespace=level. Can be repeated or comma-separated, takes precedence over --levels-file.")
+	flags.StringVar(&o.levelsFile, "levels-file", "", "YAML file with the levels to simulate under the \"namespaces\" key mapping the namespace names to the levels and an optional \"default\" level for the remaining namespaces.")
+	flags.StringVar(&o.defaultLevel, "default-level", "", "The enforce level to simulate in the namespaces without a level of their own. The namespaces without a level are not simulated by default.")
+	flags.StringVar(&o.fromDump, "from-dump", "", "Simulate against the objects of a dump instead of a live cluster, e.g. an archive created by the collect command.")
+	flags.StringVarP(&o.output, "output", "o", "", "Output format. One of: (json).")
+}
+
+func (o *SimulateOptions) Complete(clientConfigOptions *genericclioptions.ConfigFlags) error {
+	o.desiredLevels = &DesiredLevels{Namespaces: map[string]psapi.Level{}}
+	if len(o.levelsFile) > 0 {
+		var err error
+		if o.desiredLevels, err = ReadDesiredLevels(o.levelsFile); err != nil {
+			return err
+		}
+		if o.desiredLevels.Namespaces == nil {
+			o.desiredLevels.Namespaces = map[string]psapi.Level{}
+		}
+	}
+	for ns, level := range o.levels {
+		o.desiredLevels.Namespaces[ns] = psapi.Level(level)
+	}
+	if len(o.defaultLevel) > 0 {
+		o.desiredLevels.Default = psapi.Level(o.defaultLevel)
+	}
+
+	var err error
+	o.kubeClient, o.defaults, err = newKubeClient(clientConfigOptions, o.fromDump)
+	return err
+}
+
+// newKubeClient returns a client of the cluster or of the dump if one is given along with
+// the default PodSecurity levels stored in the dump if it is an archive created by the collect
+// command
+func newKubeClient(clientConfigOptions *genericclioptions.ConfigFlags, fromDump string) (kubernetes.Interface, *psaconfig.Defaults, error) {
+	if len(fromDump) > 0 {
+		defaults, err := collect.PodSecurityDefaults(fromDump)
+		if err != nil {
+			return nil, nil, err
+		}
+		client, err := dump.NewClientset(fromDump)
+		return client, defaults, err
+	}
+
+	clientConfig, err := clientConfigOptions.ToRawKubeConfigLoader().ClientConfig()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read kube client configuration")
+	}
+	client, err := kubernetes.NewForConfig(clientConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create kube client: %w", err)
+	}
+	return client, nil, nil
+}
+
+func (o *SimulateOptions) Validate() []error {
+	errs := []error{}
+
+	if len(o.desiredLevels.Namespaces) == 0 && len(o.desiredLevels.Default) == 0 {
+		errs = append(errs, fmt.Errorf("no levels to simulate, use --level, --levels-file or --default-level"))
+	}
+	if err := o.desiredLevels.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if o.output != "" && o.output != "json" {
+		errs = append(errs, fmt.Errorf("unsupported output format %q", o.output))
+	}
+
+	if o.kubeClient == nil {
+		errs = append(errs, fmt.Errorf("missing kube client"))
+	}
+
+	return errs
+}
+
+func (o *SimulateOptions) Run(ctx context.Context, out, errOut io.Writer) error {
+	adm, err := admission.NewParallelAdmission(o.kubeClient)
+	if err != nil {
+		return fmt.Errorf("failed to set up admission: %w", err)
+	}
+
+	nsList, err := o.kubeClient.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list namespaces: %w", err)
+	}
+	o.warnMissingNamespaces(errOut, nsList.Items)
+
+	simulations, err := Simulate(ctx, o.kubeClient, adm, nsList.Items, o.desiredLevels, o.defaults)
+	if err != nil {
+		return err
+	}
+
+	if o.output == "json" {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(simulations)
+	}
+
+	printSimulations(out, simulations)
+	return nil
+}
+
+// warnMissingNamespaces reports the namespaces with a desired level that do not exist
+func (o *SimulateOptions) warnMissingNamespaces(errOut io.Writer, namespaces []corev1.Namespace) {
+	existing := map[string]bool{}
+	for _, ns := range namespaces {
+		existing[ns.Name] = true
+	}
+
+	var missing []string
+	for ns := range o.desiredLevels.Namespaces {
+		if !existing[ns] {
+			missing = append(missing, ns)
+		}
+	}
+	sort.Strings(missing)
+	for _, ns := range missing {
+		fmt.Fprintf(errOut, "warning: namespace %q does not exist\n", ns)
+	}
+}
+
+func printSimulations(out io.Writer, simulations []*NamespaceSimulation) {
+	for _, s := range simulations {
+		current := s.CurrentLevel
+		if len(current) == 0 {
+			current = "unset"
+		}
+		fmt.Fprintf(out, "%s: %s (currently %s)\n", s.Name, s.Level, current)
+
+		if len(s.Warnings) > 0 {
+			fmt.Fprintf(out, "  setting the label would warn about:\n")
+			for _, v := range s.Warnings {
+				fmt.Fprintf(out, "    %s\n", v)
+			}
+		}
+		if s.AdmissionCheckedPods < s.TotalPods {
+			fmt.Fprintf(out, "  the admission would only check %d of the %d pods when setting the label\n", s.AdmissionCheckedPods, s.TotalPods)
+		}
+
+		if len(s.Rejected) == 0 {
+			fmt.Fprintln(out, "  nothing would be rejected")
+			continue
+		}
+		fmt.Fprintln(out, "  rejected:")
+		for _, w := range s.Rejected {
+			fmt.Fprintf(out, "    %s/%s:\n", w.Kind, w.Name)
+			printChecks(out, "      ", w.Checks)
+		}
+	}
+}
+
+func printChecks(out io.Writer, indent string, checks []admission.FailedCheck) {
+	for _, c := range checks {
+		detail := c.Reason
+		if len(c.Detail) > 0 {
+			detail += " (" + c.Detail + ")"
+		}
+		fmt.Fprintf(out, "%s%s: %s\n", indent, c.ID, detail)
+	}
+}
@@ -0,0 +1,274 @@
+package simulate
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	psapi "k8s.io/pod-security-admission/api"
+	"sigs.k8s.io/yaml"
+
+	"github.com/stlaz/psachecker/pkg/admission"
+	"github.com/stlaz/psachecker/pkg/psaconfig"
+)
+
+// DesiredLevels are the enforce levels to simulate, read from a YAML file such as
+//
+//	default: baseline
+//	namespaces:
+//	  kube-system: privileged
+//	  app: restricted
+type DesiredLevels struct {
+	// Default is the level of the namespaces that are not listed, they are not simulated if it's empty
+	Default    psapi.Level            `json:"default,omitempty"`
+	Namespaces map[string]psapi.Level `json:"namespaces,omitempty"`
+}
+
+// ReadDesiredLevels reads the desired levels from a YAML file
+func ReadDesiredLevels(path string) (*DesiredLevels, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	levels := &DesiredLevels{}
+	if err := yaml.UnmarshalStrict(data, levels); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return levels, levels.validate()
+}
+
+func (l *DesiredLevels) validate() error {
+	if len(l.Default) > 0 {
+		if _, err := psapi.ParseLevel(string(l.Default)); err != nil {
+			return fmt.Errorf("invalid default level: %w", err)
+		}
+	}
+	for ns, level := range l.Namespaces {
+		if _, err := psapi.ParseLevel(string(level)); err != nil {
+			return fmt.Errorf("invalid level of namespace %q: %w", ns, err)
+		}
+	}
+	return nil
+}
+
+// levelFor returns the level to simulate in the namespace, if any
+func (l *DesiredLevels) levelFor(namespace string) (psapi.Level, bool) {
+	if level, ok := l.Namespaces[namespace]; ok {
+		return level, true
+	}
+	return l.Default, len(l.Default) > 0
+}
+
+// RejectedWorkload is a pod or a pod controller whose pods the desired level would reject
+type RejectedWorkload struct {
+	Kind   string                  `json:"kind"`
+	Name   string                  `json:"name"`
+	Checks []admission.FailedCheck `json:"checks"`
+}
+
+// NamespaceSimulation is the outcome of enforcing the desired level in a namespace
+type NamespaceSimulation struct {
+	Name  string      `json:"name"`
+	Level psapi.Level `json:"level"`
+	// CurrentLevel is the enforce label of the namespace or the default level if it is known
+	CurrentLevel string `json:"currentLevel,omitempty"`
+	// Warnings are the violations the PodSecurity admission warns about when the enforce label
+	// of the namespace gets set to the level
+	Warnings []admission.Violation `json:"warnings,omitempty"`
+	// AdmissionCheckedPods is the number of the pods the admission checks within its limits
+	// when the label gets set
+	AdmissionCheckedPods int `json:"admissionCheckedPods"`
+	TotalPods            int `json:"totalPods"`
+	// Rejected are the pods and the controllers whose new pods would be rejected
+	Rejected []RejectedWorkload `json:"rejected,omitempty"`
+}
+
+// Simulate evaluates the namespaces as if their enforce labels were set to the desired levels.
+// The namespace label update is evaluated the same way the admission does, the existing pods
+// and the pod templates of the top-level controllers are evaluated one by one. The pods are
+// reported as their top-level controllers along with the checks their templates fail. The
+// defaults may be nil.
+func Simulate(ctx context.Context, client kubernetes.Interface, adm *admission.ParallelAdmission, namespaces []corev1.Namespace, levels *DesiredLevels, defaults *psaconfig.Defaults) ([]*NamespaceSimulation, error) {
+	var simulations []*NamespaceSimulation
+	for _, ns := range namespaces {
+		level, ok := levels.levelFor(ns.Name)
+		if !ok {
+			continue
+		}
+
+		evaluations, err := adm.ValidateNamespaces(ctx, ns)
+		if err != nil {
+			return nil, err
+		}
+		evaluation := evaluations[ns.Name]
+
+		simulation := &NamespaceSimulation{
+			Name:                 ns.Name,
+			Level:                level,
+			CurrentLevel:         defaults.Apply(ns.Labels)[psapi.EnforceLevelLabel],
+			Warnings:             evaluation.Violations[level],
+			AdmissionCheckedPods: evaluation.AdmissionCheckedPods,
+			TotalPods:            evaluation.TotalPods,
+		}
+
+		ws, err := workloads(ctx, client, ns.Name)
+		if err != nil {
+			return nil, err
+		}
+		lv := psapi.LevelVersion{Level: level, Version: psapi.LatestVersion()}
+		for _, w := range ws {
+			var checks []admission.FailedCheck
+			for _, obj := range w.objects {
+				failed, err := adm.ChecksFailingLevelVersion(obj, lv)
+				if err != nil {
+					return nil, err
+				}
+				checks = admission.MergeFailedChecks(checks, failed)
+			}
+			if len(checks) == 0 {
+				continue
+			}
+			simulation.Rejected = append(simulation.Rejected, RejectedWorkload{
+				Kind:   w.kind,
+				Name:   w.name,
+				Checks: checks,
+			})
+		}
+		sort.Slice(simulation.Rejected, func(i, j int) bool {
+			if simulation.Rejected[i].Kind != simulation.Rejected[j].Kind {
+				return simulation.Rejected[i].Kind < simulation.Rejected[j].Kind
+			}
+			return simulation.Rejected[i].Name < simulation.Rejected[j].Name
+		})
+
+		simulations = append(simulations, simulation)
+	}
+
+	return simulations, nil
+}
+
+// workload is a top-level pod controller or a pod without a controller along with the objects
+// whose pods it is responsible for
+type workload struct {
+	kind, name string
+	// objects are the top-level controller, if it is known, and the live pods of the workload
+	objects []runtime.Object
+}
+
+// workloads lists the top-level pod controllers of the namespace along with the pods that are not
+// managed by any of them. The live pods of the controllers are evaluated along with the pod
+// templates as they may differ from the templates, e.g. by the containers injected by mutating
+// webhooks or because they belong to older revisions. The pods that terminated are left out.
+func workloads(ctx context.Context, client kubernetes.Interface, namespace string) ([]*workload, error) {
+	var objs []runtime.Object
+	listers := []func() (runtime.Object, error){
+		func() (runtime.Object, error) { return client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{}) },
+		func() (runtime.Object, error) {
+			return client.CoreV1().ReplicationControllers(namespace).List(ctx, metav1.ListOptions{})
+		},
+		func() (runtime.Object, error) {
+			return client.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+		},
+		func() (runtime.Object, error) {
+			return client.AppsV1().ReplicaSets(namespace).List(ctx, metav1.ListOptions{})
+		},
+		func() (runtime.Object, error) {
+			return client.AppsV1().StatefulSets(namespace).List(ctx, metav1.ListOptions{})
+		},
+		func() (runtime.Object, error) {
+			return client.AppsV1().DaemonSets(namespace).List(ctx, metav1.ListOptions{})
+		},
+		func() (runtime.Object, error) {
+			return client.BatchV1().Jobs(namespace).List(ctx, metav1.ListOptions{})
+		},
+		func() (runtime.Object, error) {
+			return client.BatchV1().CronJobs(namespace).List(ctx, metav1.ListOptions{})
+		},
+	}
+	for _, list := range listers {
+		listObj, err := list()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the workloads of namespace %q: %w", namespace, err)
+		}
+		items, err := meta.ExtractList(listObj)
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, items...)
+	}
+
+	// the items of typed lists are missing their kind
+	controllers := map[string]metav1.Object{}
+	for _, obj := range objs {
+		gvks, _, err := scheme.Scheme.ObjectKinds(obj)
+		if err != nil {
+			return nil, err
+		}
+		obj.GetObjectKind().SetGroupVersionKind(gvks[0])
+
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return nil, err
+		}
+		if _, isPod := obj.(*corev1.Pod); !isPod {
+			controllers[gvks[0].Kind+"/"+accessor.GetName()] = accessor
+		}
+	}
+
+	var ret []*workload
+	byOwner := map[string]*workload{}
+	for _, obj := range objs {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return nil, err
+		}
+		pod, isPod := obj.(*corev1.Pod)
+		switch {
+		case isPod && admission.PodTerminated(pod):
+			continue
+		case !isPod && metav1.GetControllerOfNoCopy(accessor) != nil:
+			// the pod templates of the controllers managed by other controllers, e.g. of the
+			// older revisions of Deployments, only matter for the pods they still run
+			if owner := metav1.GetControllerOfNoCopy(accessor); controllers[owner.Kind+"/"+owner.Name] != nil {
+				continue
+			}
+		}
+
+		kind, name := topLevelOwner(controllers, obj.GetObjectKind().GroupVersionKind().Kind, accessor)
+		w, ok := byOwner[kind+"/"+name]
+		if !ok {
+			w = &workload{kind: kind, name: name}
+			byOwner[kind+"/"+name] = w
+			ret = append(ret, w)
+		}
+		w.objects = append(w.objects, obj)
+	}
+	return ret, nil
+}
+
+// topLevelOwner follows the controllers of the object up to the first controller that either is
+// not listed or does not have a controller itself
+func topLevelOwner(controllers map[string]metav1.Object, kind string, obj metav1.Object) (string, string) {
+	name := obj.GetName()
+	visited := sets.NewString()
+	for {
+		owner := metav1.GetControllerOfNoCopy(obj)
+		if owner == nil || visited.Has(owner.Kind+"/"+owner.Name) {
+			return kind, name
+		}
+		kind, name = owner.Kind, owner.Name
+		visited.Insert(kind + "/" + name)
+		if obj = controllers[kind+"/"+name]; obj == nil {
+			return kind, name
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package simulate
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	psapi "k8s.io/pod-security-admission/api"
+
+	"github.com/stlaz/psachecker/pkg/admission"
+)
+
+func controlledBy(kind, name string) []metav1.OwnerReference {
+	controller := true
+	return []metav1.OwnerReference{{Kind: kind, Name: name, Controller: &controller}}
+}
+
+func TestWorkloads(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "app", Name: "web"}},
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Namespace: "app", Name: "web-5d4f8b9c6", OwnerReferences: controlledBy("Deployment", "web")}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "app", Name: "web-5d4f8b9c6-x7k2p", OwnerReferences: controlledBy("ReplicaSet", "web-5d4f8b9c6")}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "app", Name: "debug"}},
+		// the controllers of these are not listed, they are reported as their owners
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "app", Name: "db-0", OwnerReferences: controlledBy("Database", "db")}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "app", Name: "operand", OwnerReferences: controlledBy("Operator", "operator")}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "other"}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "app", Name: "completed"}, Status: corev1.PodStatus{Phase: corev1.PodSucceeded}},
+	)
+
+	ws, err := workloads(context.Background(), client, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string][]string{}
+	for _, w := range ws {
+		for _, obj := range w.objects {
+			got[w.kind+"/"+w.name] = append(got[w.kind+"/"+w.name], obj.GetObjectKind().GroupVersionKind().Kind+"/"+obj.(metav1.Object).GetName())
+		}
+	}
+
+	expected := map[string][]string{
+		"Database/db":       {"Pod/db-0"},
+		"Deployment/web":    {"Pod/web-5d4f8b9c6-x7k2p", "Deployment/web"},
+		"Operator/operator": {"Deployment/operand"},
+		"Pod/debug":         {"Pod/debug"},
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected workloads %v, got %v", expected, got)
+	}
+}
+
+func TestSimulateOwnedPods(t *testing.T) {
+	privileged := true
+	ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "app"}}
+	client := fake.NewSimpleClientset(
+		&ns,
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "app", Name: "web"},
+			Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{
+				Spec: restrictedPod("", func(pod *corev1.Pod) {}).Spec,
+			}},
+		},
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Namespace: "app", Name: "web-5d4f8b9c6", OwnerReferences: controlledBy("Deployment", "web")}},
+		// a sidecar injected by a mutating webhook
+		restrictedPod("web-5d4f8b9c6-x7k2p", func(pod *corev1.Pod) {
+			pod.OwnerReferences = controlledBy("ReplicaSet", "web-5d4f8b9c6")
+			pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{
+				Name:            "proxy",
+				Image:           "proxy",
+				SecurityContext: &corev1.SecurityContext{Privileged: &privileged},
+			})
+		}),
+		// terminated pods are not evaluated
+		restrictedPod("migration", func(pod *corev1.Pod) {
+			pod.Spec.SecurityContext = nil
+			pod.Status.Phase = corev1.PodSucceeded
+		}),
+	)
+	adm, err := admission.NewParallelAdmission(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	simulations, err := Simulate(context.Background(), client, adm, []corev1.Namespace{ns}, &DesiredLevels{Default: psapi.LevelRestricted}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(simulations) != 1 {
+		t.Fatalf("expected a single simulation, got %d", len(simulations))
+	}
+
+	var got []string
+	for _, r := range simulations[0].Rejected {
+		for _, c := range r.Checks {
+			got = append(got, r.Kind+"/"+r.Name+": "+string(c.ID))
+		}
+	}
+	expected := []string{"Deployment/web: privileged", "Deployment/web: allowPrivilegeEscalation", "Deployment/web: capabilities_restricted"}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected rejected workloads %v, got %v", expected, got)
+	}
+}
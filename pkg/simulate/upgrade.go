@@ -0,0 +1,167 @@
+package simulate
+
+import (
+	"context"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	psapi "k8s.io/pod-security-admission/api"
+
+	"github.com/stlaz/psachecker/pkg/admission"
+	"github.com/stlaz/psachecker/pkg/psaconfig"
+)
+
+// VersionRegression is a workload that passes the level in the old version but not in the new one
+type VersionRegression struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// Checks are the checks of the new version the workload fails, only the ones it does not
+	// fail in the old version if it already fails the old version
+	Checks []admission.FailedCheck `json:"checks"`
+	// AlreadyFailing is true if the workload fails the old version as well
+	AlreadyFailing bool `json:"alreadyFailing,omitempty"`
+}
+
+// UpgradeImpact lists the workloads of a namespace that a change of the PodSecurity version
+// of its enforce level would reject
+type UpgradeImpact struct {
+	Namespace  string      `json:"namespace"`
+	Level      psapi.Level `json:"level"`
+	OldVersion string      `json:"oldVersion"`
+	NewVersion string      `json:"newVersion"`
+	// Regressions are the workloads that pass the old version but fail the new one along with
+	// the workloads that fail checks of the new version they do not fail in the old one
+	Regressions []VersionRegression `json:"regressions,omitempty"`
+	// AlreadyFailing is the number of the workloads that fail both of the versions
+	AlreadyFailing int `json:"alreadyFailing"`
+	Workloads      int `json:"workloads"`
+}
+
+// VersionChange describes the versions to compare. Empty fields are taken from the enforce labels
+// of the namespaces or the defaults for the namespaces without the labels, an unset enforce-version
+// means the latest version.
+type VersionChange struct {
+	Level      psapi.Level
+	OldVersion string
+	NewVersion string
+	// Defaults are the default PodSecurity levels of the cluster, nil if they are not known
+	Defaults *psaconfig.Defaults
+}
+
+// forNamespace resolves the level and the versions for the namespace, it returns false if there
+// is nothing to compare, i.e. the level is privileged or the versions are the same
+func (c *VersionChange) forNamespace(ns *corev1.Namespace) (psapi.Level, psapi.LevelVersion, psapi.LevelVersion, bool) {
+	labels := c.Defaults.Apply(ns.Labels)
+	level := c.Level
+	if len(level) == 0 {
+		var err error
+		if level, err = psapi.ParseLevel(labels[psapi.EnforceLevelLabel]); err != nil {
+			return "", psapi.LevelVersion{}, psapi.LevelVersion{}, false
+		}
+	}
+
+	oldVersion, newVersion := c.OldVersion, c.NewVersion
+	if len(oldVersion) == 0 {
+		oldVersion = labels[psapi.EnforceVersionLabel]
+	}
+	oldV, err := psapi.ParseVersion(oldVersion)
+	if err != nil {
+		oldV = psapi.LatestVersion()
+	}
+	newV, err := psapi.ParseVersion(newVersion)
+	if err != nil {
+		newV = psapi.LatestVersion()
+	}
+
+	if level == psapi.LevelPrivileged || oldV == newV {
+		return "", psapi.LevelVersion{}, psapi.LevelVersion{}, false
+	}
+	return level, psapi.LevelVersion{Level: level, Version: oldV}, psapi.LevelVersion{Level: level, Version: newV}, true
+}
+
+// AnalyzeUpgrade evaluates the pods and the top-level pod controllers of the namespaces against
+// the old and the new version of their enforce levels. The pods that did not terminate are
+// reported as their top-level controllers along with the checks their templates fail.
+func AnalyzeUpgrade(ctx context.Context, client kubernetes.Interface, adm *admission.ParallelAdmission, namespaces []corev1.Namespace, change *VersionChange) ([]*UpgradeImpact, error) {
+	var impacts []*UpgradeImpact
+	for i := range namespaces {
+		ns := &namespaces[i]
+		level, oldLV, newLV, ok := change.forNamespace(ns)
+		if !ok {
+			continue
+		}
+
+		ws, err := workloads(ctx, client, ns.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		impact := &UpgradeImpact{
+			Namespace:  ns.Name,
+			Level:      level,
+			OldVersion: oldLV.Version.String(),
+			NewVersion: newLV.Version.String(),
+			Workloads:  len(ws),
+		}
+		for _, w := range ws {
+			var checks []admission.FailedCheck
+			var failsOld, failsNew bool
+			for _, obj := range w.objects {
+				oldFailed, err := adm.ChecksFailingLevelVersion(obj, oldLV)
+				if err != nil {
+					return nil, err
+				}
+				newFailed, err := adm.ChecksFailingLevelVersion(obj, newLV)
+				if err != nil {
+					return nil, err
+				}
+				failsNew = failsNew || len(newFailed) > 0
+				if len(oldFailed) > 0 {
+					failsOld = true
+					newFailed = newlyFailed(oldFailed, newFailed)
+				}
+				checks = admission.MergeFailedChecks(checks, newFailed)
+			}
+			if failsOld && failsNew {
+				impact.AlreadyFailing++
+			}
+			if len(checks) == 0 {
+				continue
+			}
+
+			impact.Regressions = append(impact.Regressions, VersionRegression{
+				Kind:           w.kind,
+				Name:           w.name,
+				Checks:         checks,
+				AlreadyFailing: failsOld,
+			})
+		}
+
+		sort.Slice(impact.Regressions, func(i, j int) bool {
+			if impact.Regressions[i].Kind != impact.Regressions[j].Kind {
+				return impact.Regressions[i].Kind < impact.Regressions[j].Kind
+			}
+			return impact.Regressions[i].Name < impact.Regressions[j].Name
+		})
+		impacts = append(impacts, impact)
+	}
+	return impacts, nil
+}
+
+// newlyFailed returns the checks failed in the new version that are not failed the same way in
+// the old version, i.e. the added checks and the checks the new version forbids more in
+func newlyFailed(oldFailed, newFailed []admission.FailedCheck) []admission.FailedCheck {
+	old := map[admission.FailedCheck]bool{}
+	for _, c := range oldFailed {
+		old[c] = true
+	}
+
+	var ret []admission.FailedCheck
+	for _, c := range newFailed {
+		if !old[c] {
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
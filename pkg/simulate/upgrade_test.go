@@ -0,0 +1,161 @@
+package simulate
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	psapi "k8s.io/pod-security-admission/api"
+	"k8s.io/pod-security-admission/policy"
+
+	"github.com/stlaz/psachecker/pkg/admission"
+)
+
+func restrictedPod(name string, mutate func(pod *corev1.Pod)) *corev1.Pod {
+	runAsNonRoot, allowPrivilegeEscalation := true, false
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "app", Name: name},
+		Spec: corev1.PodSpec{
+			SecurityContext: &corev1.PodSecurityContext{
+				RunAsNonRoot:   &runAsNonRoot,
+				SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+			},
+			Containers: []corev1.Container{{
+				Name:  "app",
+				Image: "app",
+				SecurityContext: &corev1.SecurityContext{
+					AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+					Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+				},
+			}},
+		},
+	}
+	mutate(pod)
+	return pod
+}
+
+func TestAnalyzeUpgrade(t *testing.T) {
+	// the runAsUser check was added to the restricted level in v1.23
+	rootUser := int64(0)
+	ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "app", Labels: map[string]string{
+		psapi.EnforceLevelLabel:   string(psapi.LevelRestricted),
+		psapi.EnforceVersionLabel: "v1.22",
+	}}}
+	client := fake.NewSimpleClientset(
+		restrictedPod("restricted", func(pod *corev1.Pod) {}),
+		restrictedPod("root-user", func(pod *corev1.Pod) { pod.Spec.SecurityContext.RunAsUser = &rootUser }),
+		restrictedPod("root", func(pod *corev1.Pod) { pod.Spec.SecurityContext.RunAsNonRoot = nil }),
+		restrictedPod("root-and-root-user", func(pod *corev1.Pod) {
+			pod.Spec.SecurityContext.RunAsNonRoot = nil
+			pod.Spec.SecurityContext.RunAsUser = &rootUser
+		}),
+	)
+	adm, err := admission.NewParallelAdmission(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	impacts, err := AnalyzeUpgrade(context.Background(), client, adm, []corev1.Namespace{ns}, &VersionChange{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(impacts) != 1 {
+		t.Fatalf("expected the impact on a single namespace, got %v", impacts)
+	}
+	impact := impacts[0]
+
+	if impact.Workloads != 4 || impact.AlreadyFailing != 2 {
+		t.Errorf("expected 4 workloads with 2 of them already failing, got %d and %d", impact.Workloads, impact.AlreadyFailing)
+	}
+
+	type regression struct {
+		name           string
+		checks         []policy.CheckID
+		alreadyFailing bool
+	}
+	var got []regression
+	for _, r := range impact.Regressions {
+		var checks []policy.CheckID
+		for _, c := range r.Checks {
+			checks = append(checks, c.ID)
+		}
+		got = append(got, regression{name: r.Name, checks: checks, alreadyFailing: r.AlreadyFailing})
+	}
+	expected := []regression{
+		{name: "root-and-root-user", checks: []policy.CheckID{"runAsUser"}, alreadyFailing: true},
+		{name: "root-user", checks: []policy.CheckID{"runAsUser"}},
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected regressions %+v, got %+v", expected, got)
+	}
+}
+
+func TestAnalyzeUpgradeTightenedCheck(t *testing.T) {
+	// the baseline seccomp check only looked at the annotations before v1.19
+	unconfined := func(pod *corev1.Pod) {
+		pod.Spec.Containers[0].SecurityContext.SeccompProfile = &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeUnconfined}
+	}
+	ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "app", Labels: map[string]string{
+		psapi.EnforceLevelLabel:   string(psapi.LevelBaseline),
+		psapi.EnforceVersionLabel: "v1.18",
+	}}}
+	client := fake.NewSimpleClientset(
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "app", Name: "web"},
+			Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{
+				Spec: restrictedPod("", func(pod *corev1.Pod) {}).Spec,
+			}},
+		},
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Namespace: "app", Name: "web-5d4f8b9c6", OwnerReferences: controlledBy("Deployment", "web")}},
+		// the live pod differs from the template, e.g. by a webhook
+		restrictedPod("web-5d4f8b9c6-x7k2p", func(pod *corev1.Pod) {
+			pod.OwnerReferences = controlledBy("ReplicaSet", "web-5d4f8b9c6")
+			unconfined(pod)
+		}),
+		restrictedPod("privileged-unconfined", func(pod *corev1.Pod) {
+			privileged := true
+			pod.Spec.Containers[0].SecurityContext.Privileged = &privileged
+			unconfined(pod)
+		}),
+		restrictedPod("completed", func(pod *corev1.Pod) {
+			unconfined(pod)
+			pod.Status.Phase = corev1.PodSucceeded
+		}),
+	)
+	adm, err := admission.NewParallelAdmission(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	impacts, err := AnalyzeUpgrade(context.Background(), client, adm, []corev1.Namespace{ns}, &VersionChange{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(impacts) != 1 {
+		t.Fatalf("expected the impact on a single namespace, got %v", impacts)
+	}
+	impact := impacts[0]
+
+	if impact.Workloads != 2 || impact.AlreadyFailing != 1 {
+		t.Errorf("expected 2 workloads with 1 of them already failing, got %d and %d", impact.Workloads, impact.AlreadyFailing)
+	}
+
+	var got []string
+	for _, r := range impact.Regressions {
+		for _, c := range r.Checks {
+			got = append(got, fmt.Sprintf("%s/%s: %s (already failing: %v)", r.Kind, r.Name, c.ID, r.AlreadyFailing))
+		}
+	}
+	expected := []string{
+		"Deployment/web: seccompProfile_baseline (already failing: false)",
+		"Pod/privileged-unconfined: seccompProfile_baseline (already failing: true)",
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected regressions %v, got %v", expected, got)
+	}
+}
@@ -0,0 +1,181 @@
+package simulate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+	psapi "k8s.io/pod-security-admission/api"
+
+	"github.com/stlaz/psachecker/pkg/admission"
+	"github.com/stlaz/psachecker/pkg/psaconfig"
+)
+
+// NewUpgradeImpactCommand compares the workloads against two PodSecurity versions of the enforce levels
+func NewUpgradeImpactCommand(clientConfigOptions *genericclioptions.ConfigFlags) *cobra.Command {
+	o := newUpgradeImpactOptions()
+
+	cmd := &cobra.Command{
+		Use:          "upgrade-impact [--from <version>] [--to <version>] [flags]",
+		Short:        "list the workloads that a newer PodSecurity version of the enforce levels would reject",
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(clientConfigOptions); err != nil {
+				return err
+			}
+			errs := o.Validate()
+			if len(errs) > 0 {
+				return fmt.Errorf("there were errors while setting up the command: %v", errs)
+			}
+
+			return o.Run(context.Background(), c.OutOrStdout())
+		},
+	}
+
+	o.AddFlags(cmd)
+	return cmd
+}
+
+type UpgradeImpactOptions struct {
+	from      string
+	to        string
+	level     string
+	namespace string
+	fromDump  string
+	output    string
+
+	kubeClient kubernetes.Interface
+	// defaults are the default PodSecurity levels of the cluster, nil if they are not known
+	defaults *psaconfig.Defaults
+}
+
+func newUpgradeImpactOptions() *UpgradeImpactOptions {
+	return &UpgradeImpactOptions{
+		to: psapi.VersionLatest,
+	}
+}
+
+func (o *UpgradeImpactOptions) AddFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+
+	flags.StringVar(&o.from, "from", "", "The PodSecurity version the workloads currently pass, e.g. v1.24. Defaults to the enforce-version label of each namespace.")
+	flags.StringVar(&o.to, "to", o.to, "The PodSecurity version to upgrade to.")
+	flags.StringVar(&o.level, "level", "", "The level to compare the versions of. Defaults to the enforce label of each namespace, the namespaces without the label are skipped unless the dump is an archive created by the collect command with the default levels of the cluster.")
+	flags.StringVar(&o.fromDump, "from-dump", "", "Analyze the objects of a dump instead of a live cluster, e.g. an archive created by the collect command.")
+	flags.StringVarP(&o.output, "output", "o", "", "Output format. One of: (json).")
+}
+
+func (o *UpgradeImpactOptions) Complete(clientConfigOptions *genericclioptions.ConfigFlags) error {
+	if clientConfigOptions.Namespace != nil {
+		o.namespace = *clientConfigOptions.Namespace
+	}
+
+	var err error
+	o.kubeClient, o.defaults, err = newKubeClient(clientConfigOptions, o.fromDump)
+	return err
+}
+
+func (o *UpgradeImpactOptions) Validate() []error {
+	errs := []error{}
+
+	if len(o.from) > 0 {
+		if _, err := psapi.ParseVersion(o.from); err != nil {
+			errs = append(errs, fmt.Errorf("invalid --from: %w", err))
+		}
+	}
+	if _, err := psapi.ParseVersion(o.to); err != nil {
+		errs = append(errs, fmt.Errorf("invalid --to: %w", err))
+	}
+	if len(o.level) > 0 {
+		if _, err := psapi.ParseLevel(o.level); err != nil {
+			errs = append(errs, fmt.Errorf("invalid --level: %w", err))
+		}
+	}
+
+	if o.output != "" && o.output != "json" {
+		errs = append(errs, fmt.Errorf("unsupported output format %q", o.output))
+	}
+
+	if o.kubeClient == nil {
+		errs = append(errs, fmt.Errorf("missing kube client"))
+	}
+
+	return errs
+}
+
+func (o *UpgradeImpactOptions) Run(ctx context.Context, out io.Writer) error {
+	adm, err := admission.NewParallelAdmission(o.kubeClient)
+	if err != nil {
+		return fmt.Errorf("failed to set up admission: %w", err)
+	}
+
+	nsList, err := o.kubeClient.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list namespaces: %w", err)
+	}
+	namespaces := nsList.Items
+	if len(o.namespace) > 0 {
+		namespaces = nil
+		for _, ns := range nsList.Items {
+			if ns.Name == o.namespace {
+				namespaces = append(namespaces, ns)
+			}
+		}
+	}
+
+	impacts, err := AnalyzeUpgrade(ctx, o.kubeClient, adm, namespaces, &VersionChange{
+		Level:      psapi.Level(o.level),
+		OldVersion: o.from,
+		NewVersion: o.to,
+		Defaults:   o.defaults,
+	})
+	if err != nil {
+		return err
+	}
+
+	if o.output == "json" {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(impacts)
+	}
+
+	printImpacts(out, impacts)
+	return nil
+}
+
+func printImpacts(out io.Writer, impacts []*UpgradeImpact) {
+	if len(impacts) == 0 {
+		fmt.Fprintln(out, "no namespace enforces a level whose version would change")
+		return
+	}
+
+	for _, i := range impacts {
+		rejected := 0
+		for _, r := range i.Regressions {
+			if !r.AlreadyFailing {
+				rejected++
+			}
+		}
+		fmt.Fprintf(out, "%s: %s %s -> %s, %d of %d workloads would be rejected",
+			i.Namespace, i.Level, i.OldVersion, i.NewVersion, rejected, i.Workloads)
+		if i.AlreadyFailing > 0 {
+			fmt.Fprintf(out, " (%d already fail %s)", i.AlreadyFailing, i.OldVersion)
+		}
+		fmt.Fprintln(out)
+
+		for _, r := range i.Regressions {
+			if r.AlreadyFailing {
+				fmt.Fprintf(out, "  %s/%s (already failing %s) newly fails:\n", r.Kind, r.Name, i.OldVersion)
+			} else {
+				fmt.Fprintf(out, "  %s/%s:\n", r.Kind, r.Name)
+			}
+			printChecks(out, "    ", r.Checks)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/stlaz/psachecker/pkg/admission"
+)
+
+// Snapshot is the serializable result of a single cluster inspection
+type Snapshot struct {
+	Timestamp  time.Time                    `json:"timestamp"`
+	Namespaces []*admission.NamespaceResult `json:"namespaces"`
+}
+
+func New(timestamp time.Time, namespaces []*admission.NamespaceResult) *Snapshot {
+	s := &Snapshot{
+		Timestamp:  timestamp.UTC(),
+		Namespaces: namespaces,
+	}
+	sort.Slice(s.Namespaces, func(i, j int) bool {
+		return s.Namespaces[i].Name < s.Namespaces[j].Name
+	})
+	return s
+}
+
+// Namespace returns the result for the namespace of the given name or nil if
+// the snapshot does not contain such a namespace.
+func (s *Snapshot) Namespace(name string) *admission.NamespaceResult {
+	for _, ns := range s.Namespaces {
+		if ns.Name == name {
+			return ns
+		}
+	}
+	return nil
+}
+
+func ReadFile(path string) (*Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Snapshot{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to decode inspection snapshot %q: %w", path, err)
+	}
+	return s, nil
+}
+
+func (s *Snapshot) WriteFile(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
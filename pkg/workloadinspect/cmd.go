@@ -31,6 +31,11 @@ func NewWorkloadInspectCommand(clientConfigOptions *genericclioptions.ConfigFlag
 			for _, ns := range nsAggregatedResults.Keys() {
 				fmt.Fprintf(c.OutOrStdout(), "%s: %s\n", ns, nsAggregatedResults.Get(ns))
 			}
+
+			if o.evaluationSummary {
+				counts := o.evaluationCounter.Counts()
+				fmt.Fprintf(c.ErrOrStderr(), "\n%s", counts.String())
+			}
 			return nil
 		},
 	}
@@ -36,16 +36,20 @@ type WorkloadInspectOptions struct {
 
 	updatesOnly       bool
 	defaultNamespaces bool
+	evaluationSummary bool
 
 	builder    *resource.Builder
 	kubeClient kubernetes.Interface
 
 	isLocal bool
+
+	evaluationCounter *admission.EvaluationCounter
 }
 
 func newWorkloadInspectOptions() *WorkloadInspectOptions {
 	return &WorkloadInspectOptions{
-		filenameOptions: &resource.FilenameOptions{},
+		filenameOptions:   &resource.FilenameOptions{},
+		evaluationCounter: admission.NewEvaluationCounter(),
 	}
 }
 
@@ -58,6 +62,7 @@ func (o *WorkloadInspectOptions) AddFlags(cmd *cobra.Command) {
 	)
 
 	flags.BoolVar(&o.defaultNamespaces, "default-namespaces", false, "Default empty namespaces in files to the --namespace value.")
+	flags.BoolVar(&o.evaluationSummary, "evaluation-summary", false, "Print the counts of the performed PodSecurity evaluations and errors to stderr.")
 }
 
 func (o *WorkloadInspectOptions) Complete(cmd *cobra.Command, args []string, clientConfigOptions *genericclioptions.ConfigFlags) error {
@@ -118,7 +123,7 @@ func (o *WorkloadInspectOptions) Validate() []error {
 }
 
 func (opts *WorkloadInspectOptions) Run(ctx context.Context) (*admission.OrderedStringToPSALevelMap, error) {
-	adm, err := admission.NewParallelAdmission(opts.kubeClient)
+	adm, err := admission.NewParallelAdmissionWithMetrics(opts.kubeClient, opts.evaluationCounter)
 	if err != nil {
 		return nil, fmt.Errorf("failed to set up admission: %w", err)
 	}